@@ -0,0 +1,323 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Audit actions
+const (
+	AuditBan         = "account:ban"
+	AuditUnban       = "account:unban"
+	AuditChangeRole  = "account:change-role"
+	AuditForceVerify = "account:force-verify"
+)
+
+// Pagination query parameters, shared by list endpoints
+type PageQuery struct {
+	Page     int `form:"page" binding:"omitempty,min=1"`
+	PageSize int `form:"page_size" binding:"omitempty,min=1,max=100"`
+}
+
+// Helper method: fill in the default values and return the offset
+func (query *PageQuery) Offset() int {
+	if query.Page == 0 {
+		query.Page = 1
+	}
+	if query.PageSize == 0 {
+		query.PageSize = 20
+	}
+	return (query.Page - 1) * query.PageSize
+}
+
+type PageResponse[T any] struct {
+	Items    []T   `json:"items"`
+	Total    int64 `json:"total"`
+	Page     int   `json:"page"`
+	PageSize int   `json:"page_size"`
+}
+
+type ListAccountsQuery struct {
+	PageQuery
+
+	// Search by username or email
+	Search string           `form:"q"`
+	Status db.AccountStatus `form:"status" binding:"omitempty,oneof=inactive active banned"`
+	Role   db.Role          `form:"role" binding:"omitempty,oneof=admin organiser staff user"`
+}
+
+func (server *Server) ListAccounts(ctx *gin.Context) {
+	var query ListAccountsQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/admin/accounts: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	// Build query
+	tx := server.queries.DB.Model(&db.Account{})
+	if query.Search != "" {
+		pattern := "%" + strings.ToLower(query.Search) + "%"
+		tx = tx.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+	}
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+	if query.Role != "" {
+		tx = tx.Where("role = ?", query.Role)
+	}
+
+	// Count and get the page
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/admin/accounts: failed to count accounts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var accounts []db.Account
+	if err := tx.Order("id").Offset(offset).Limit(query.PageSize).Find(&accounts).Error; err != nil {
+		server.logger.Error("GET /api/admin/accounts: failed to list accounts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	items := make([]AccountResponse, len(accounts))
+	for i, account := range accounts {
+		items[i] = NewAccountResponse(account)
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[AccountResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
+
+type BanAccountRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+func (server *Server) BanAccount(ctx *gin.Context) {
+	var req BanAccountRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/admin/accounts/:id/ban: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	server.adminUpdateAccount(ctx, "POST /api/admin/accounts/:id/ban", AuditBan, func(account *db.Account) (any, error) {
+		if account.Status == db.Banned {
+			return nil, ConflictError{"account has already been banned"}
+		}
+		detail := map[string]any{"reason": req.Reason, "old_status": account.Status}
+		account.Status = db.Banned
+		return detail, nil
+	}, true)
+}
+
+func (server *Server) UnbanAccount(ctx *gin.Context) {
+	server.adminUpdateAccount(ctx, "POST /api/admin/accounts/:id/unban", AuditUnban, func(account *db.Account) (any, error) {
+		if account.Status != db.Banned {
+			return nil, ConflictError{"account is not banned"}
+		}
+		account.Status = db.Active
+		return nil, nil
+	}, false)
+}
+
+type ChangeRoleRequest struct {
+	Role db.Role `json:"role" binding:"required,oneof=admin organiser staff user"`
+}
+
+func (server *Server) ChangeRole(ctx *gin.Context) {
+	var req ChangeRoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("PUT /api/admin/accounts/:id/role: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	// Role is embedded in tokens, so old tokens must be revoked
+	server.adminUpdateAccount(ctx, "PUT /api/admin/accounts/:id/role", AuditChangeRole, func(account *db.Account) (any, error) {
+		if account.Role == req.Role {
+			return nil, ConflictError{"account already has this role"}
+		}
+		detail := map[string]any{"old_role": account.Role, "new_role": req.Role}
+		account.Role = req.Role
+		return detail, nil
+	}, true)
+}
+
+func (server *Server) ForceVerifyAccount(ctx *gin.Context) {
+	server.adminUpdateAccount(ctx, "POST /api/admin/accounts/:id/verify", AuditForceVerify, func(account *db.Account) (any, error) {
+		if account.Status != db.Inactive {
+			return nil, ConflictError{"account is not waiting for verification"}
+		}
+		account.Status = db.Active
+		return nil, nil
+	}, false)
+}
+
+// Helper method: apply an admin action to the account in the path, and write the audit log in the same transaction.
+// The action mutates the account and returns the audit detail, or a ConflictError if the action is not allowed.
+// If revoke is true, all tokens of the account are revoked after the change is committed
+func (server *Server) adminUpdateAccount(
+	ctx *gin.Context,
+	route string,
+	action string,
+	apply func(account *db.Account) (any, error),
+	revoke bool,
+) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid account ID"})
+		return
+	}
+	if uint(id) == claims.ID {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"cannot perform this action on your own account"})
+		return
+	}
+
+	var account db.Account
+	err = server.queries.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, id).Error; err != nil {
+			return err
+		}
+
+		detail, err := apply(&account)
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(&account).Error; err != nil {
+			return err
+		}
+
+		return server.writeAuditLog(tx, ctx, action, "account", account.ID, detail)
+	})
+	if err != nil {
+		server.writeError(ctx, route, "account", err)
+		return
+	}
+
+	if revoke {
+		if err := server.revokeAllSessions(ctx, account.ID); err != nil {
+			server.logger.Error(route+": failed to revoke sessions", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, NewAccountResponse(account))
+}
+
+// Helper method: write an audit log of the current user within a transaction
+func (server *Server) writeAuditLog(tx *gorm.DB, ctx *gin.Context, action, targetType string, targetID uint, detail any) error {
+	var data []byte
+	if detail != nil {
+		var err error
+		if data, err = json.Marshal(detail); err != nil {
+			return err
+		}
+	}
+
+	return tx.Create(&db.AuditLog{
+		ActorID:    getClaims(ctx).ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Detail:     string(data),
+		IP:         ctx.ClientIP(),
+	}).Error
+}
+
+type ListAuditLogsQuery struct {
+	PageQuery
+
+	ActorID    uint   `form:"actor_id"`
+	TargetType string `form:"target_type"`
+	TargetID   uint   `form:"target_id"`
+	Action     string `form:"action"`
+}
+
+type AuditLogResponse struct {
+	ID         uint            `json:"id"`
+	ActorID    uint            `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   uint            `json:"target_id"`
+	Detail     json.RawMessage `json:"detail,omitempty"`
+	IP         string          `json:"ip"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+func (server *Server) ListAuditLogs(ctx *gin.Context) {
+	var query ListAuditLogsQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/admin/audit-logs: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	tx := server.queries.DB.Model(&db.AuditLog{})
+	if query.ActorID != 0 {
+		tx = tx.Where("actor_id = ?", query.ActorID)
+	}
+	if query.TargetType != "" {
+		tx = tx.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetID != 0 {
+		tx = tx.Where("target_id = ?", query.TargetID)
+	}
+	if query.Action != "" {
+		tx = tx.Where("action = ?", query.Action)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/admin/audit-logs: failed to count audit logs", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var logs []db.AuditLog
+	if err := tx.Order("id DESC").Offset(offset).Limit(query.PageSize).Find(&logs).Error; err != nil {
+		server.logger.Error("GET /api/admin/audit-logs: failed to list audit logs", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	items := make([]AuditLogResponse, len(logs))
+	for i, log := range logs {
+		items[i] = AuditLogResponse{
+			ID:         log.ID,
+			ActorID:    log.ActorID,
+			Action:     log.Action,
+			TargetType: log.TargetType,
+			TargetID:   log.TargetID,
+			IP:         log.IP,
+			CreatedAt:  log.CreatedAt,
+		}
+		if log.Detail != "" {
+			items[i].Detail = json.RawMessage(log.Detail)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[AuditLogResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
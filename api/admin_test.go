@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/danglnh07/ticket-system/util"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// Helper function: create a server connected to the database and cache, skip the test if they are not configured
+func newTestServer(t *testing.T) *Server {
+	dbConn, redisConn := os.Getenv("DB_CONN"), os.Getenv("REDIS_ADDRESS")
+	if dbConn == "" || redisConn == "" {
+		t.Skip("DB_CONN and REDIS_ADDRESS are required")
+	}
+
+	queries := db.NewQueries()
+	require.NoError(t, queries.ConnectDB(dbConn))
+	require.NoError(t, queries.AutoMigration())
+	require.NoError(t, queries.ConnectRedis(&redis.Options{Addr: redisConn}))
+
+	gin.SetMode(gin.TestMode)
+	return NewServer(queries, nil, nil, nil, nil, nil, nil, &util.Config{}, slog.New(slog.DiscardHandler))
+}
+
+// Helper function: create an active account with a login session
+func createTestAccount(t *testing.T, server *Server, role db.Role) (db.Account, db.Session) {
+	suffix := time.Now().UnixNano()
+	account := db.Account{
+		Username: fmt.Sprintf("admin-test-%d", suffix),
+		Email:    fmt.Sprintf("admin-test-%d@example.com", suffix),
+		Status:   db.Active,
+		Role:     role,
+	}
+	require.NoError(t, server.queries.DB.Create(&account).Error)
+
+	session := db.Session{
+		ID:               fmt.Sprintf("session-%d", suffix),
+		AccountID:        account.ID,
+		RefreshTokenHash: fmt.Sprintf("hash-%d", suffix),
+	}
+	require.NoError(t, server.queries.SaveSession(t.Context(), session, time.Minute))
+	require.NoError(t, server.queries.StoreRefreshToken(t.Context(), session.RefreshTokenHash, session.ID, time.Minute))
+	return account, session
+}
+
+// Helper function: call an admin handler on an account as the admin, return the status code
+func runAdminAction(t *testing.T, handler gin.HandlerFunc, admin db.Account, targetID uint, body string) int {
+	t.Helper()
+
+	router := gin.New()
+	router.POST("/:id", func(ctx *gin.Context) {
+		ctx.Set(claimsKey, &security.CustomClaims{ID: admin.ID, Role: db.Admin})
+		ctx.Next()
+	}, handler)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%d", targetID), bytes.NewBufferString(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+// Helper function: check that the account has been logged out everywhere and its tokens revoked
+func requireRevoked(t *testing.T, server *Server, account db.Account, session db.Session) {
+	t.Helper()
+
+	sessions, err := server.queries.ListSessions(t.Context(), account.ID)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+	_, err = server.queries.UseRefreshToken(t.Context(), session.RefreshTokenHash)
+	require.ErrorIs(t, err, db.ErrRefreshTokenNotFound)
+
+	var updated db.Account
+	require.NoError(t, server.queries.DB.First(&updated, account.ID).Error)
+	require.Greater(t, updated.TokenVersion, account.TokenVersion)
+}
+
+func TestAdminUpdateAccount(t *testing.T) {
+	server := newTestServer(t)
+	admin, _ := createTestAccount(t, server, db.Admin)
+
+	// Banning logs the account out everywhere and is audited with the reason
+	target, session := createTestAccount(t, server, db.User)
+	require.Equal(t, http.StatusOK, runAdminAction(t, server.BanAccount, admin, target.ID, `{"reason":"spam"}`))
+	requireRevoked(t, server, target, session)
+
+	var banned db.Account
+	require.NoError(t, server.queries.DB.First(&banned, target.ID).Error)
+	require.Equal(t, db.Banned, banned.Status)
+	var log db.AuditLog
+	err := server.queries.DB.
+		Where("actor_id = ? AND action = ? AND target_type = ? AND target_id = ?", admin.ID, AuditBan, "account", target.ID).
+		First(&log).Error
+	require.NoError(t, err)
+	require.Contains(t, log.Detail, "spam")
+
+	// Banning again is rejected and not audited
+	require.Equal(t, http.StatusConflict, runAdminAction(t, server.BanAccount, admin, target.ID, `{"reason":"spam"}`))
+	var count int64
+	require.NoError(t, server.queries.DB.Model(&db.AuditLog{}).Where("target_id = ? AND action = ?", target.ID, AuditBan).
+		Count(&count).Error)
+	require.Equal(t, int64(1), count)
+
+	// The role is embedded in tokens, so changing it revokes them too
+	target, session = createTestAccount(t, server, db.User)
+	require.Equal(t, http.StatusOK, runAdminAction(t, server.ChangeRole, admin, target.ID, `{"role":"organiser"}`))
+	requireRevoked(t, server, target, session)
+
+	var promoted db.Account
+	require.NoError(t, server.queries.DB.First(&promoted, target.ID).Error)
+	require.Equal(t, db.Organiser, promoted.Role)
+	var roleLog db.AuditLog
+	err = server.queries.DB.
+		Where("actor_id = ? AND action = ? AND target_id = ?", admin.ID, AuditChangeRole, target.ID).
+		First(&roleLog).Error
+	require.NoError(t, err)
+	require.Contains(t, roleLog.Detail, "organiser")
+
+	// An admin cannot act on their own account
+	require.Equal(t, http.StatusBadRequest, runAdminAction(t, server.BanAccount, admin, admin.ID, `{"reason":"spam"}`))
+}
@@ -0,0 +1,399 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/danglnh07/ticket-system/service/worker"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+)
+
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required,alphanum,min=3,max=32"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+type AccountResponse struct {
+	ID       uint             `json:"id"`
+	Username string           `json:"username"`
+	Email    string           `json:"email"`
+	Avatar   string           `json:"avatar"`
+	Status   db.AccountStatus `json:"status"`
+	Role     db.Role          `json:"role"`
+	Point    uint             `json:"point"`
+}
+
+// Helper function: convert an account model into response, hiding credentials
+func NewAccountResponse(account db.Account) AccountResponse {
+	return AccountResponse{
+		ID:       account.ID,
+		Username: account.Username,
+		Email:    account.Email,
+		Avatar:   account.Avatar,
+		Status:   account.Status,
+		Role:     account.Role,
+		Point:    account.Point,
+	}
+}
+
+func (server *Server) Register(ctx *gin.Context) {
+	var req RegisterRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/auth/register: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	// Hash password
+	hashed, err := security.BcryptHash(req.Password)
+	if err != nil {
+		server.logger.Error("POST /api/auth/register: failed to hash password", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Create the inactive account, then send the verification email
+	account := db.Account{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: sql.NullString{String: hashed, Valid: true},
+		Status:   db.Inactive,
+		Role:     db.User,
+	}
+	// The unique indexes reject a username or email that has been taken, even by a concurrent registration
+	if err := server.queries.DB.Create(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			ctx.JSON(http.StatusConflict, ErrorResponse{"username or email has already been taken"})
+			return
+		}
+		server.logger.Error("POST /api/auth/register: failed to create account", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// If we cannot send the email, the account is deleted so the username and email are not taken
+	err = server.distributor.DistributeTask(ctx, worker.SendVerifyEmail, worker.SendVerifyEmailPayload{
+		Email:    account.Email,
+		Username: account.Username,
+		Link:     server.verifyLink(account),
+	}, asynq.MaxRetry(5))
+	if err != nil {
+		server.logger.Error("POST /api/auth/register: failed to distribute task", "error", err)
+		if err := server.queries.DB.Unscoped().Delete(&account).Error; err != nil {
+			server.logger.Error("POST /api/auth/register: failed to delete account", "error", err)
+		}
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, NewAccountResponse(account))
+}
+
+// Helper method: build the signed, expiring verification link for an account.
+// The email is part of the signed message, so the link is no longer valid if the email changed
+func (server *Server) verifyLink(account db.Account) string {
+	expires := time.Now().Add(server.config.VerifyLinkExpiration).Unix()
+	signature := security.Sign(verifyMessage(account.ID, account.Email, expires), server.config.SecretKey)
+
+	query := url.Values{}
+	query.Set("id", strconv.FormatUint(uint64(account.ID), 10))
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", signature)
+	return fmt.Sprintf("%s/api/auth/verify?%s", server.config.Domain, query.Encode())
+}
+
+func verifyMessage(id uint, email string, expires int64) string {
+	return fmt.Sprintf("verify:%d:%s:%d", id, email, expires)
+}
+
+func (server *Server) VerifyEmail(ctx *gin.Context) {
+	// Get the link parameters
+	id, err := strconv.ParseUint(ctx.Query("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid verification link"})
+		return
+	}
+	expires, err := strconv.ParseInt(ctx.Query("expires"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid verification link"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"verification link has expired"})
+		return
+	}
+
+	// Get the account
+	var account db.Account
+	if err := server.queries.DB.First(&account, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid verification link"})
+			return
+		}
+		server.logger.Error("GET /api/auth/verify: failed to get account", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Check the signature
+	signature := ctx.Query("signature")
+	if !security.VerifySignature(verifyMessage(account.ID, account.Email, expires), signature, server.config.SecretKey) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid verification link"})
+		return
+	}
+
+	switch account.Status {
+	case db.Active:
+		ctx.JSON(http.StatusOK, MessageResponse{"account has already been verified"})
+		return
+	case db.Banned:
+		ctx.JSON(http.StatusForbidden, ErrorResponse{"account has been banned"})
+		return
+	}
+
+	// Activate the account. Only inactive account can be activated, so a banned account cannot use
+	// an old link to unban itself
+	result := server.queries.DB.
+		Model(&db.Account{}).
+		Where("id = ? AND status = ?", account.ID, db.Inactive).
+		Update("status", db.Active)
+	if result.Error != nil {
+		server.logger.Error("GET /api/auth/verify: failed to activate account", "error", result.Error)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, MessageResponse{"account verified successfully"})
+}
+
+type LoginRequest struct {
+	// Either the username or the email of the account
+	Identifier string `json:"identifier" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+
+	// Optional name of the device, shown in the session list. Fallback to the user agent if empty
+	DeviceName string `json:"device_name" binding:"max=128"`
+}
+
+type AuthResponse struct {
+	AccessToken  string          `json:"access_token"`
+	RefreshToken string          `json:"refresh_token"`
+	Account      AccountResponse `json:"account"`
+}
+
+func (server *Server) Login(ctx *gin.Context) {
+	var req LoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/auth/login: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	// Get account by username or email
+	var account db.Account
+	result := server.queries.DB.
+		Where("username = ? OR email = ?", req.Identifier, req.Identifier).
+		First(&account)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse{"invalid credentials"})
+			return
+		}
+		server.logger.Error("POST /api/auth/login: failed to get account", "error", result.Error)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Compare password. Account created by OAuth2 has no password, so it cannot login this way
+	if !account.Password.Valid || !security.BcryptCompare(account.Password.String, req.Password) {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{"invalid credentials"})
+		return
+	}
+
+	// Check account status
+	if !server.checkAccountStatus(ctx, account) {
+		return
+	}
+
+	// Start a new session and issue tokens
+	session, err := server.newSession(ctx, account.ID, req.DeviceName)
+	if err != nil {
+		server.logger.Error("POST /api/auth/login: failed to create session", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	resp, err := server.issueTokens(ctx, account, session)
+	if err != nil {
+		server.logger.Error("POST /api/auth/login: failed to issue tokens", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// Helper method: check if the account is allowed to login. If not, the response is written and false is returned
+func (server *Server) checkAccountStatus(ctx *gin.Context, account db.Account) bool {
+	switch account.Status {
+	case db.Inactive:
+		ctx.JSON(http.StatusForbidden, ErrorResponse{"account has not been verified"})
+		return false
+	case db.Banned:
+		ctx.JSON(http.StatusForbidden, ErrorResponse{"account has been banned"})
+		return false
+	}
+	return true
+}
+
+// Helper method: create a new session for an account logging in from the current request
+func (server *Server) newSession(ctx *gin.Context, accountID uint, deviceName string) (db.Session, error) {
+	id, err := security.RandomToken(16)
+	if err != nil {
+		return db.Session{}, err
+	}
+
+	if deviceName == "" {
+		deviceName = ctx.Request.UserAgent()
+	}
+
+	return db.Session{
+		ID:         id,
+		AccountID:  accountID,
+		DeviceName: deviceName,
+		IssuedAt:   time.Now(),
+	}, nil
+}
+
+// Helper method: create a new pair of access token and refresh token for an account in a session.
+// The refresh token is registered in cache so it can be exchanged exactly once, and the session is
+// updated to point to the new refresh token
+func (server *Server) issueTokens(ctx *gin.Context, account db.Account, session db.Session) (AuthResponse, error) {
+	version := int(account.TokenVersion)
+
+	accessToken, err := server.jwtService.CreateToken(account.ID, account.Role, security.AccessToken, version, session.ID)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	refreshToken, err := server.jwtService.CreateToken(account.ID, account.Role, security.RefreshToken, version, session.ID)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	// Save the session before storing the refresh token, so a refresh token never points to a missing session
+	session.RefreshTokenHash = security.Hash(refreshToken)
+	session.IP = ctx.ClientIP()
+	session.LastSeen = time.Now()
+	if err := server.queries.SaveSession(ctx, session, server.config.RefreshTokenExpiration); err != nil {
+		return AuthResponse{}, err
+	}
+
+	err = server.queries.StoreRefreshToken(
+		ctx, session.RefreshTokenHash, session.ID, server.config.RefreshTokenExpiration)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Account:      NewAccountResponse(account),
+	}, nil
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func (server *Server) Refresh(ctx *gin.Context) {
+	var req RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/auth/refresh: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	// Verify token, only refresh token can be exchanged
+	claims, err := server.jwtService.VerifyToken(req.RefreshToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{err.Error()})
+		return
+	}
+	if claims.TokenType != security.RefreshToken {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{"invalid token type"})
+		return
+	}
+
+	// Get the account, since role, status and token version may have changed since the token was issued
+	var account db.Account
+	if err := server.queries.DB.First(&account, claims.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse{"invalid token"})
+			return
+		}
+		server.logger.Error("POST /api/auth/refresh: failed to get account", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if !server.checkAccountStatus(ctx, account) {
+		return
+	}
+	if claims.Version != int(account.TokenVersion) {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{"token version not match"})
+		return
+	}
+
+	// Mark the refresh token as used. If it has been used before, someone is replaying it,
+	// so we revoke the whole token family by bumping the token version
+	sessionID, err := server.queries.UseRefreshToken(ctx, security.Hash(req.RefreshToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrRefreshTokenNotFound):
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse{"invalid token"})
+		case errors.Is(err, db.ErrRefreshTokenReused):
+			server.logger.Warn("POST /api/auth/refresh: refresh token reuse detected", "account_id", account.ID)
+			if err := server.revokeAllSessions(ctx, account.ID); err != nil {
+				server.logger.Error("POST /api/auth/refresh: failed to revoke tokens", "error", err)
+				ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+				return
+			}
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse{"refresh token reuse detected, all sessions have been revoked"})
+		default:
+			server.logger.Error("POST /api/auth/refresh: failed to use refresh token", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		}
+		return
+	}
+
+	// Get the session of this refresh token
+	session, err := server.queries.GetSession(ctx, account.ID, sessionID)
+	if err != nil {
+		if errors.Is(err, db.ErrSessionNotFound) {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse{"session has been revoked"})
+			return
+		}
+		server.logger.Error("POST /api/auth/refresh: failed to get session", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Issue new token pair in the same session
+	resp, err := server.issueTokens(ctx, account, session)
+	if err != nil {
+		server.logger.Error("POST /api/auth/refresh: failed to issue tokens", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
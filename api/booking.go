@@ -0,0 +1,204 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/danglnh07/ticket-system/service/worker"
+	"github.com/danglnh07/ticket-system/util"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+)
+
+type BookingResponse struct {
+	ID            uint            `json:"id"`
+	AccountID     uint            `json:"account_id"`
+	TicketID      uint            `json:"ticket_id"`
+	SeatNumber    string          `json:"seat_number"`
+	OrderID       *uint           `json:"order_id,omitempty"`
+	Status        db.TicketStatus `json:"status"`
+	HoldExpiresAt *time.Time      `json:"hold_expires_at,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+func NewBookingResponse(booking db.Booking) BookingResponse {
+	resp := BookingResponse{
+		ID:         booking.ID,
+		AccountID:  booking.AccountID,
+		TicketID:   booking.TicketID,
+		SeatNumber: booking.SeatNumber,
+		OrderID:    booking.OrderID,
+		Status:     booking.Status,
+		CreatedAt:  booking.CreatedAt,
+	}
+	if booking.HoldExpiresAt.Valid {
+		resp.HoldExpiresAt = &booking.HoldExpiresAt.Time
+	}
+	return resp
+}
+
+type CreateBookingRequest struct {
+	TicketID uint `json:"ticket_id" binding:"required"`
+	Quantity uint `json:"quantity" binding:"required,min=1,max=10"`
+
+	// Seats to book in a seated tier, one for each ticket. If empty, the best available seats are assigned
+	SeatIDs []uint `json:"seat_ids" binding:"omitempty,max=10"`
+}
+
+// Reserve tickets for the current user. The tickets are held as pending bookings until they are paid,
+// or released when the hold expires
+func (server *Server) CreateBooking(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req CreateBookingRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/bookings: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	if len(req.SeatIDs) > 0 && len(req.SeatIDs) != int(req.Quantity) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"number of seats must match the quantity"})
+		return
+	}
+
+	bookings, err := server.queries.ReserveTickets(
+		ctx, claims.ID, req.TicketID, req.Quantity, req.SeatIDs, server.config.BookingHoldDuration)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrSoldOut), errors.Is(err, db.ErrTicketNotOnSale), errors.Is(err, db.ErrSeatUnavailable):
+			ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+		case errors.Is(err, db.ErrInvalidSeatSelection):
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"ticket not found"})
+		default:
+			server.logger.Error("POST /api/bookings: failed to reserve tickets", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		}
+		return
+	}
+
+	// Release the bookings once the hold expires. If this fails, the cron sweeper will release them instead
+	ids := make([]uint, len(bookings))
+	resp := make([]BookingResponse, len(bookings))
+	for i, booking := range bookings {
+		ids[i] = booking.ID
+		resp[i] = NewBookingResponse(booking)
+	}
+	err = server.distributor.DistributeTask(ctx, worker.ReleaseBooking, worker.ReleaseBookingPayload{BookingIDs: ids},
+		asynq.ProcessIn(server.config.BookingHoldDuration))
+	if err != nil {
+		server.logger.Warn("POST /api/bookings: failed to schedule booking release", "error", err)
+	}
+	ctx.JSON(http.StatusCreated, resp)
+}
+
+// Cancel a pending booking of the current user, giving its ticket back before the hold expires
+func (server *Server) CancelBooking(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid booking ID"})
+		return
+	}
+
+	var booking db.Booking
+	if err := server.queries.DB.Where("account_id = ?", claims.ID).First(&booking, id).Error; err != nil {
+		server.writeError(ctx, "DELETE /api/bookings/:id", "booking", err)
+		return
+	}
+
+	if booking.OrderID != nil {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"booking belongs to an order, cancel the order instead"})
+		return
+	}
+
+	released, err := server.queries.ReleaseBookings(ctx, []uint{booking.ID})
+	if err != nil {
+		if released == nil {
+			server.logger.Error("DELETE /api/bookings/:id: failed to release booking", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+		server.logger.Warn("DELETE /api/bookings/:id: failed to update ticket stock", "error", err)
+	}
+	if len(released) == 0 {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"only pending booking can be canceled"})
+		return
+	}
+
+	server.offerWaitlist(ctx, "DELETE /api/bookings/:id", released[0].TicketID)
+	server.cancelPayments(ctx, "DELETE /api/bookings/:id", worker.ReleasedPayments(released)...)
+	ctx.JSON(http.StatusOK, NewBookingResponse(released[0]))
+}
+
+type BookingQRQuery struct {
+	Format string `form:"format" binding:"omitempty,oneof=png svg"`
+}
+
+// Get the QR code of the e-ticket of a paid booking of the current user, as a PNG (default) or SVG image
+func (server *Server) GetBookingQR(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var query BookingQRQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid booking ID"})
+		return
+	}
+
+	var booking db.Booking
+	err = server.queries.DB.Preload("Ticket").Where("account_id = ?", claims.ID).First(&booking, id).Error
+	if err != nil {
+		server.writeError(ctx, "GET /api/me/bookings/:id/qr", "booking", err)
+		return
+	}
+	if booking.Status != db.Valid {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"only paid and unused booking has an e-ticket"})
+		return
+	}
+
+	code, err := server.ticketCode(booking)
+	if err != nil {
+		server.logger.Error("GET /api/me/bookings/:id/qr: failed to sign ticket code", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	contentType, render := "image/png", util.GenerateQRCode
+	if query.Format == "svg" {
+		contentType, render = "image/svg+xml", util.GenerateQRCodeSVG
+	}
+	image, err := render(code)
+	if err != nil {
+		server.logger.Error("GET /api/me/bookings/:id/qr: failed to render QR code", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// The code changes when the ticket changes hands, so it must not be cached
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Data(http.StatusOK, contentType, image)
+}
+
+// Helper method: sign the e-ticket code of a booking. The ticket of the booking must be loaded
+func (server *Server) ticketCode(booking db.Booking) (string, error) {
+	return security.SignTicketCode(security.TicketClaims{
+		BookingID: booking.ID,
+		EventID:   booking.Ticket.EventID,
+		Seat:      booking.SeatNumber,
+		Version:   booking.TicketVersion,
+	}, server.config.SecretKey)
+}
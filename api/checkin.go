@@ -0,0 +1,250 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CheckInRequest struct {
+	Code string `json:"code" binding:"required,max=512"`
+}
+
+type CheckInResponse struct {
+	BookingID   uint      `json:"booking_id"`
+	EventID     uint      `json:"event_id"`
+	AccountID   uint      `json:"account_id"`
+	SeatNumber  string    `json:"seat_number"`
+	CheckedInAt time.Time `json:"checked_in_at"`
+	CheckedIn   int64     `json:"checked_in"`
+	Total       int64     `json:"total"`
+}
+
+// Returned when a ticket is scanned again, so the staff can tell the attendee who let them in and when
+type CheckInConflictResponse struct {
+	Error       string    `json:"error"`
+	CheckedInBy uint      `json:"checked_in_by"`
+	CheckedInAt time.Time `json:"checked_in_at"`
+}
+
+// Message published to the organiser each time a ticket of their event is checked in
+type AttendanceMessage struct {
+	Type      string `json:"type"`
+	EventID   uint   `json:"event_id"`
+	CheckedIn int64  `json:"checked_in"`
+	Total     int64  `json:"total"`
+}
+
+// Scan the QR code of an e-ticket at the door. Only staff assigned to the event and its organisers can check in
+func (server *Server) CheckIn(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req CheckInRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/checkin: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	ticket, err := security.ParseTicketCode(req.Code, server.config.SecretKey)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+
+	var event db.Event
+	if err := server.queries.DB.First(&event, ticket.EventID).Error; err != nil {
+		server.writeError(ctx, "POST /api/checkin", "event", err)
+		return
+	}
+	if !canManageEvent(claims, event) {
+		assigned, err := server.queries.IsEventStaff(ctx, event.ID, claims.ID)
+		if err != nil {
+			server.logger.Error("POST /api/checkin: failed to check event staff", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+		if !assigned {
+			ctx.JSON(http.StatusForbidden, ErrorResponse{"you are not assigned to this event"})
+			return
+		}
+	}
+	if event.Status != db.Published {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"event is not open for check-in"})
+		return
+	}
+
+	booking, err := server.queries.CheckIn(ctx, ticket.BookingID, event.ID, ticket.Version, claims.ID)
+	if err != nil {
+		var checkedIn *db.AlreadyCheckedInError
+		switch {
+		case errors.As(err, &checkedIn):
+			ctx.JSON(http.StatusConflict, CheckInConflictResponse{
+				Error:       err.Error(),
+				CheckedInBy: checkedIn.By,
+				CheckedInAt: checkedIn.At,
+			})
+		case errors.Is(err, db.ErrTicketCodeRevoked), errors.Is(err, db.ErrBookingNotValid):
+			ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"ticket not found"})
+		default:
+			server.logger.Error("POST /api/checkin: failed to check in", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		}
+		return
+	}
+
+	// The ticket has been checked in, failing to count only leaves the counters out of date
+	checkedIn, total, err := server.queries.EventAttendance(ctx, event.ID)
+	if err != nil {
+		server.logger.Warn("POST /api/checkin: failed to count attendance", "error", err)
+	} else if server.hub.IsUserOnline(event.HostID) {
+		err := server.hub.Publish(event.HostID, AttendanceMessage{
+			Type:      "attendance",
+			EventID:   event.ID,
+			CheckedIn: checkedIn,
+			Total:     total,
+		})
+		if err != nil {
+			server.logger.Warn("POST /api/checkin: failed to publish attendance", "error", err)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, CheckInResponse{
+		BookingID:   booking.ID,
+		EventID:     event.ID,
+		AccountID:   booking.AccountID,
+		SeatNumber:  booking.SeatNumber,
+		CheckedInAt: booking.CheckedInAt.Time,
+		CheckedIn:   checkedIn,
+		Total:       total,
+	})
+}
+
+type EventStaffResponse struct {
+	AccountID  uint      `json:"account_id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+type AssignEventStaffRequest struct {
+	AccountID uint `json:"account_id" binding:"required"`
+}
+
+// Assign a staff account to check tickets in at an event
+func (server *Server) AssignEventStaff(ctx *gin.Context) {
+	var req AssignEventStaffRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/events/:id/staff: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	var staff db.EventStaff
+	_, ok := server.mutateEvent(ctx, "POST /api/events/:id/staff", func(tx *gorm.DB, event *db.Event) error {
+		var account db.Account
+		if err := tx.First(&account, req.AccountID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return NotFoundError{"account"}
+			}
+			return err
+		}
+		if account.Role != db.SupportedStaff || account.Status != db.Active {
+			return ConflictError{"only active staff accounts can be assigned"}
+		}
+
+		var count int64
+		result := tx.
+			Model(&db.EventStaff{}).
+			Where("event_id = ? AND account_id = ?", event.ID, account.ID).
+			Count(&count)
+		if result.Error != nil {
+			return result.Error
+		}
+		if count > 0 {
+			return ConflictError{"staff has already been assigned to this event"}
+		}
+
+		staff = db.EventStaff{EventID: event.ID, AccountID: account.ID, Account: account}
+		return tx.Create(&staff).Error
+	})
+	if ok {
+		ctx.JSON(http.StatusCreated, EventStaffResponse{
+			AccountID:  staff.AccountID,
+			Username:   staff.Account.Username,
+			Email:      staff.Account.Email,
+			AssignedAt: staff.CreatedAt,
+		})
+	}
+}
+
+// Remove a staff account from an event
+func (server *Server) RemoveEventStaff(ctx *gin.Context) {
+	accountID, err := strconv.ParseUint(ctx.Param("account_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid account ID"})
+		return
+	}
+
+	_, ok := server.mutateEvent(ctx, "DELETE /api/events/:id/staff/:account_id", func(tx *gorm.DB, event *db.Event) error {
+		result := tx.Unscoped().Where("event_id = ? AND account_id = ?", event.ID, accountID).Delete(&db.EventStaff{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return NotFoundError{"staff"}
+		}
+		return nil
+	})
+	if ok {
+		ctx.JSON(http.StatusOK, MessageResponse{"staff removed"})
+	}
+}
+
+// List the staff assigned to an event
+func (server *Server) ListEventStaff(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid event ID"})
+		return
+	}
+
+	var event db.Event
+	err = server.queries.DB.First(&event, id).Error
+	if err == nil && !canManageEvent(claims, event) {
+		err = errPermissionDenied
+	}
+	if err != nil {
+		server.writeError(ctx, "GET /api/events/:id/staff", "event", err)
+		return
+	}
+
+	var staffs []db.EventStaff
+	err = server.queries.DB.Preload("Account").Where("event_id = ?", event.ID).Order("id").Find(&staffs).Error
+	if err != nil {
+		server.logger.Error("GET /api/events/:id/staff: failed to list staff", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	resp := make([]EventStaffResponse, len(staffs))
+	for i, staff := range staffs {
+		resp[i] = EventStaffResponse{
+			AccountID:  staff.AccountID,
+			Username:   staff.Account.Username,
+			Email:      staff.Account.Email,
+			AssignedAt: staff.CreatedAt,
+		}
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
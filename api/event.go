@@ -0,0 +1,678 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/danglnh07/ticket-system/service/worker"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TicketResponse struct {
+	ID        uint           `json:"id"`
+	EventID   uint           `json:"event_id"`
+	Rank      string         `json:"rank"`
+	Total     uint           `json:"total"`
+	Available uint           `json:"available"`
+	Price     float64        `json:"price"`
+	Status    db.EventStatus `json:"status"`
+}
+
+func NewTicketResponse(ticket db.Ticket) TicketResponse {
+	return TicketResponse{
+		ID:        ticket.ID,
+		EventID:   ticket.EventID,
+		Rank:      ticket.Rank,
+		Total:     ticket.Total,
+		Available: ticket.Available,
+		Price:     ticket.Price,
+		Status:    ticket.Status,
+	}
+}
+
+type EventResponse struct {
+	ID           uint             `json:"id"`
+	HostID       uint             `json:"host_id"`
+	Name         string           `json:"name"`
+	Description  string           `json:"description"`
+	Location     string           `json:"location"`
+	StartTime    time.Time        `json:"start_time"`
+	EndTime      time.Time        `json:"end_time"`
+	PreviewImage string           `json:"preview_image"`
+	Status       db.EventStatus   `json:"status"`
+	Tickets      []TicketResponse `json:"tickets"`
+
+	TransfersDisabled bool `json:"transfers_disabled"`
+	ResaleCapPercent  uint `json:"resale_cap_percent"`
+}
+
+func NewEventResponse(event db.Event, tickets []db.Ticket) EventResponse {
+	resp := EventResponse{
+		ID:           event.ID,
+		HostID:       event.HostID,
+		Name:         event.Name,
+		Description:  event.Description,
+		Location:     event.Location,
+		StartTime:    event.StartTime,
+		EndTime:      event.EndTime,
+		PreviewImage: event.PreviewImage.String,
+		Status:       event.Status,
+		Tickets:      make([]TicketResponse, len(tickets)),
+
+		TransfersDisabled: event.TransfersDisabled,
+		ResaleCapPercent:  event.ResaleCapPercent,
+	}
+	for i, ticket := range tickets {
+		resp.Tickets[i] = NewTicketResponse(ticket)
+	}
+	return resp
+}
+
+type TicketTierRequest struct {
+	Rank  string  `json:"rank" binding:"required,max=64"`
+	Total uint    `json:"total" binding:"required,min=1"`
+	Price float64 `json:"price" binding:"min=0"`
+}
+
+type CreateEventRequest struct {
+	Name         string    `json:"name" binding:"required,max=255"`
+	Description  string    `json:"description" binding:"required"`
+	Location     string    `json:"location" binding:"required,max=255"`
+	StartTime    time.Time `json:"start_time" binding:"required"`
+	EndTime      time.Time `json:"end_time" binding:"required"`
+	PreviewImage string    `json:"preview_image" binding:"omitempty,url"`
+
+	// Ticket tiers can be created along with the event, or added later
+	Tickets []TicketTierRequest `json:"tickets" binding:"dive"`
+}
+
+func (server *Server) CreateEvent(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req CreateEventRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/events: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+	if !req.StartTime.Before(req.EndTime) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"start time must be before end time"})
+		return
+	}
+	if !req.StartTime.After(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"event must start in the future"})
+		return
+	}
+
+	// Create the draft event along with its ticket tiers
+	event := db.Event{
+		HostID:       claims.ID,
+		Name:         req.Name,
+		Description:  req.Description,
+		Location:     req.Location,
+		StartTime:    req.StartTime,
+		EndTime:      req.EndTime,
+		PreviewImage: sql.NullString{String: req.PreviewImage, Valid: req.PreviewImage != ""},
+		Status:       db.Draft,
+	}
+	tickets := make([]db.Ticket, len(req.Tickets))
+	err := server.queries.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+
+		for i, tier := range req.Tickets {
+			tickets[i] = db.Ticket{
+				EventID:   event.ID,
+				Rank:      tier.Rank,
+				Total:     tier.Total,
+				Available: tier.Total,
+				Price:     tier.Price,
+				Status:    db.Draft,
+			}
+		}
+		if len(tickets) > 0 {
+			return tx.Create(&tickets).Error
+		}
+		return nil
+	})
+	if err != nil {
+		server.logger.Error("POST /api/events: failed to create event", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, NewEventResponse(event, tickets))
+}
+
+// How long a listing page is cached. Listing is invalidated when an event or tier changes, but not when tickets
+// are sold, so the available count of cached pages can be stale for this long
+const eventListCacheTTL = time.Minute
+
+type ListEventsQuery struct {
+	// Full-text search over name, description and location
+	Query string `form:"q" binding:"max=200"`
+
+	From      *time.Time   `form:"from"`
+	To        *time.Time   `form:"to"`
+	HostID    uint         `form:"host_id"`
+	MinPrice  *float64     `form:"min_price" binding:"omitempty,min=0"`
+	MaxPrice  *float64     `form:"max_price" binding:"omitempty,min=0"`
+	Available bool         `form:"available"`
+	Sort      db.EventSort `form:"sort" binding:"omitempty,oneof=start_time -start_time price -price newest"`
+	Limit     int          `form:"limit" binding:"omitempty,min=1,max=100"`
+
+	// Opaque cursor returned by the previous page
+	Cursor string `form:"cursor"`
+}
+
+type EventSummaryResponse struct {
+	ID           uint      `json:"id"`
+	HostID       uint      `json:"host_id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	Location     string    `json:"location"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	PreviewImage string    `json:"preview_image"`
+	MinPrice     float64   `json:"min_price"`
+}
+
+type EventListResponse struct {
+	Items []EventSummaryResponse `json:"items"`
+
+	// Empty if this is the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+// Helper function: encode the cursor into an opaque string
+func encodeCursor(cursor *db.EventCursor) string {
+	if cursor == nil {
+		return ""
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Helper function: decode the cursor from an opaque string
+func decodeCursor(str string) (*db.EventCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	var cursor db.EventCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// List published events for customers, with search, filters and cursor pagination
+func (server *Server) ListEvents(ctx *gin.Context) {
+	var query ListEventsQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/events: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 20
+	}
+
+	params := db.EventSearchParams{
+		Query:     query.Query,
+		From:      query.From,
+		To:        query.To,
+		HostID:    query.HostID,
+		MinPrice:  query.MinPrice,
+		MaxPrice:  query.MaxPrice,
+		Available: query.Available,
+		Sort:      query.Sort,
+		Limit:     query.Limit,
+	}
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid cursor"})
+			return
+		}
+		params.Cursor = cursor
+	}
+
+	// Try the cache first. Query parameters are encoded in sorted order, so the same query has the same key
+	key := fmt.Sprintf("events:list:%s:%s",
+		server.queries.EventListVersion(ctx), security.Hash(ctx.Request.URL.Query().Encode()))
+	if cached, err := server.queries.GetCache(ctx, key); err == nil {
+		ctx.Data(http.StatusOK, "application/json; charset=utf-8", []byte(cached))
+		return
+	}
+
+	events, next, err := server.queries.SearchEvents(ctx, params)
+	if err != nil {
+		server.logger.Error("GET /api/events: failed to search events", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	resp := EventListResponse{
+		Items:      make([]EventSummaryResponse, len(events)),
+		NextCursor: encodeCursor(next),
+	}
+	for i, event := range events {
+		resp.Items[i] = EventSummaryResponse{
+			ID:           event.ID,
+			HostID:       event.HostID,
+			Name:         event.Name,
+			Description:  event.Description,
+			Location:     event.Location,
+			StartTime:    event.StartTime,
+			EndTime:      event.EndTime,
+			PreviewImage: event.PreviewImage.String,
+			MinPrice:     event.MinPrice,
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		server.logger.Error("GET /api/events: failed to marshal response", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	server.queries.SetCache(ctx, key, string(data), eventListCacheTTL)
+
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// Get a published (or canceled) event. Draft events are only visible to their host via /api/me/events
+func (server *Server) GetEvent(ctx *gin.Context) {
+	var event db.Event
+	result := server.queries.DB.
+		Where("id = ? AND status <> ?", ctx.Param("id"), db.Draft).
+		First(&event)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"event not found"})
+			return
+		}
+		server.logger.Error("GET /api/events/:id: failed to get event", "error", result.Error)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	var tickets []db.Ticket
+	if err := server.queries.DB.Where("event_id = ?", event.ID).Order("price").Find(&tickets).Error; err != nil {
+		server.logger.Error("GET /api/events/:id: failed to get tickets", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewEventResponse(event, tickets))
+}
+
+type ListMyEventsQuery struct {
+	PageQuery
+
+	Status db.EventStatus `form:"status" binding:"omitempty,oneof=draft published canceled"`
+}
+
+// List the events hosted by the current user, including drafts
+func (server *Server) ListMyEvents(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var query ListMyEventsQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/me/events: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	tx := server.queries.DB.Model(&db.Event{}).Where("host_id = ?", claims.ID)
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/me/events: failed to count events", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var events []db.Event
+	if err := tx.Order("start_time DESC").Offset(offset).Limit(query.PageSize).Find(&events).Error; err != nil {
+		server.logger.Error("GET /api/me/events: failed to list events", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Get the ticket tiers of all events in one query
+	ids := make([]uint, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	var tickets []db.Ticket
+	if err := server.queries.DB.Where("event_id IN ?", ids).Order("price").Find(&tickets).Error; err != nil {
+		server.logger.Error("GET /api/me/events: failed to get tickets", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	ticketsByEvent := map[uint][]db.Ticket{}
+	for _, ticket := range tickets {
+		ticketsByEvent[ticket.EventID] = append(ticketsByEvent[ticket.EventID], ticket)
+	}
+
+	items := make([]EventResponse, len(events))
+	for i, event := range events {
+		items[i] = NewEventResponse(event, ticketsByEvent[event.ID])
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[EventResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
+
+type UpdateEventRequest struct {
+	Name         *string    `json:"name" binding:"omitempty,max=255"`
+	Description  *string    `json:"description"`
+	Location     *string    `json:"location" binding:"omitempty,max=255"`
+	StartTime    *time.Time `json:"start_time"`
+	EndTime      *time.Time `json:"end_time"`
+	PreviewImage *string    `json:"preview_image" binding:"omitempty,url"`
+
+	// Stop or allow attendees passing their tickets to other accounts
+	TransfersDisabled *bool `json:"transfers_disabled"`
+
+	// Highest resale price as a percentage of the face price, zero closes the resale market
+	ResaleCapPercent *uint `json:"resale_cap_percent" binding:"omitempty,max=500"`
+}
+
+// Update an event. Draft events can be fully edited, while published events cannot change their time
+// since attendees have bought tickets for it. A new start time must be in the future. Canceled events cannot be edited
+func (server *Server) UpdateEvent(ctx *gin.Context) {
+	var req UpdateEventRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("PUT /api/events/:id: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+	if req.StartTime != nil && !req.StartTime.After(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"event must start in the future"})
+		return
+	}
+
+	event, ok := server.mutateEvent(ctx, "PUT /api/events/:id", func(tx *gorm.DB, event *db.Event) error {
+		switch event.Status {
+		case db.Canceled:
+			return ConflictError{"canceled event cannot be edited"}
+		case db.Published:
+			if req.StartTime != nil || req.EndTime != nil {
+				return ConflictError{"time of a published event cannot be changed"}
+			}
+		}
+
+		if req.Name != nil {
+			event.Name = *req.Name
+		}
+		if req.Description != nil {
+			event.Description = *req.Description
+		}
+		if req.Location != nil {
+			event.Location = *req.Location
+		}
+		if req.StartTime != nil {
+			event.StartTime = *req.StartTime
+		}
+		if req.EndTime != nil {
+			event.EndTime = *req.EndTime
+		}
+		if req.PreviewImage != nil {
+			event.PreviewImage = sql.NullString{String: *req.PreviewImage, Valid: *req.PreviewImage != ""}
+		}
+		if req.TransfersDisabled != nil {
+			event.TransfersDisabled = *req.TransfersDisabled
+		}
+		if req.ResaleCapPercent != nil {
+			event.ResaleCapPercent = *req.ResaleCapPercent
+		}
+		if !event.StartTime.Before(event.EndTime) {
+			return ConflictError{"start time must be before end time"}
+		}
+
+		return tx.Save(event).Error
+	})
+	if ok {
+		server.writeEvent(ctx, "PUT /api/events/:id", event)
+	}
+}
+
+// Delete an event. Only draft can be deleted, a published event must be canceled instead
+func (server *Server) DeleteEvent(ctx *gin.Context) {
+	_, ok := server.mutateEvent(ctx, "DELETE /api/events/:id", func(tx *gorm.DB, event *db.Event) error {
+		if event.Status != db.Draft {
+			return ConflictError{"only draft event can be deleted, cancel it instead"}
+		}
+
+		if err := tx.Where("event_id = ?", event.ID).Delete(&db.Ticket{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(event).Error
+	})
+	if ok {
+		ctx.JSON(http.StatusOK, MessageResponse{"event deleted"})
+	}
+}
+
+// Publish a draft event. The event must start in the future and have at least one ticket tier
+func (server *Server) PublishEvent(ctx *gin.Context) {
+	event, ok := server.mutateEvent(ctx, "POST /api/events/:id/publish", func(tx *gorm.DB, event *db.Event) error {
+		if !event.StartTime.Before(event.EndTime) {
+			return ConflictError{"start time must be before end time"}
+		}
+		if !event.StartTime.After(time.Now()) {
+			return ConflictError{"event must start in the future"}
+		}
+
+		var tiers int64
+		result := tx.
+			Model(&db.Ticket{}).
+			Where("event_id = ? AND status <> ?", event.ID, db.Canceled).
+			Count(&tiers)
+		if result.Error != nil {
+			return result.Error
+		}
+		if tiers == 0 {
+			return ConflictError{"event must have at least one ticket tier"}
+		}
+
+		return transitionEvent(tx, event, db.Published)
+	})
+	if ok {
+		server.writeEvent(ctx, "POST /api/events/:id/publish", event)
+	}
+}
+
+// Cancel an event, which also cancels all of its ticket tiers. Paid bookings are refunded in the background
+// and their owners are notified, the progress can be followed with GET /api/events/:id/cancellation
+func (server *Server) CancelEvent(ctx *gin.Context) {
+	event, ok := server.mutateEvent(ctx, "POST /api/events/:id/cancel", func(tx *gorm.DB, event *db.Event) error {
+		if err := transitionEvent(tx, event, db.Canceled); err != nil {
+			return err
+		}
+
+		cancellation := db.EventCancellation{EventID: event.ID, Status: db.CancellationRunning}
+		return tx.Create(&cancellation).Error
+	})
+	if !ok {
+		return
+	}
+
+	// The refunds start once the cancellation is committed. If the task cannot be queued,
+	// the organiser can start it again with POST /api/events/:id/cancellation/resume
+	err := server.distributor.DistributeTask(ctx, worker.CancelEvent, worker.CancelEventPayload{EventID: event.ID},
+		asynq.MaxRetry(10))
+	if err != nil {
+		server.logger.Error("POST /api/events/:id/cancel: failed to distribute task", "error", err)
+	}
+	server.writeEvent(ctx, "POST /api/events/:id/cancel", event)
+}
+
+type CancellationResponse struct {
+	EventID     uint                  `json:"event_id"`
+	Status      db.CancellationStatus `json:"status"`
+	Total       uint                  `json:"total"`
+	Processed   uint                  `json:"processed"`
+	CreatedAt   time.Time             `json:"created_at"`
+	CompletedAt *time.Time            `json:"completed_at,omitempty"`
+}
+
+func NewCancellationResponse(cancellation db.EventCancellation) CancellationResponse {
+	resp := CancellationResponse{
+		EventID:   cancellation.EventID,
+		Status:    cancellation.Status,
+		Total:     cancellation.Total,
+		Processed: cancellation.Processed,
+		CreatedAt: cancellation.CreatedAt,
+	}
+	if cancellation.CompletedAt.Valid {
+		resp.CompletedAt = &cancellation.CompletedAt.Time
+	}
+	return resp
+}
+
+// Get the refund progress of a canceled event
+func (server *Server) GetEventCancellation(ctx *gin.Context) {
+	cancellation, ok := server.getEventCancellation(ctx, "GET /api/events/:id/cancellation")
+	if ok {
+		ctx.JSON(http.StatusOK, NewCancellationResponse(cancellation))
+	}
+}
+
+// Distribute the refund tasks of a canceled event again, for bookings whose task has run out of retries.
+// Bookings that have already been refunded are skipped
+func (server *Server) ResumeEventCancellation(ctx *gin.Context) {
+	cancellation, ok := server.getEventCancellation(ctx, "POST /api/events/:id/cancellation/resume")
+	if !ok {
+		return
+	}
+	if cancellation.Status == db.CancellationCompleted {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"cancellation has already been completed"})
+		return
+	}
+
+	err := server.distributor.DistributeTask(ctx, worker.CancelEvent,
+		worker.CancelEventPayload{EventID: cancellation.EventID}, asynq.MaxRetry(10))
+	if err != nil {
+		server.logger.Error("POST /api/events/:id/cancellation/resume: failed to distribute task", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, NewCancellationResponse(cancellation))
+}
+
+// Helper method: get the cancellation of the event in the path, if the current user can manage it.
+// If failed, the response is written and false is returned
+func (server *Server) getEventCancellation(ctx *gin.Context, route string) (db.EventCancellation, bool) {
+	claims := getClaims(ctx)
+
+	var cancellation db.EventCancellation
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid event ID"})
+		return cancellation, false
+	}
+
+	err = server.queries.DB.Preload("Event").Where("event_id = ?", id).First(&cancellation).Error
+	if err == nil && !canManageEvent(claims, cancellation.Event) {
+		err = errPermissionDenied
+	}
+	if err != nil {
+		server.writeError(ctx, route, "cancellation", err)
+		return cancellation, false
+	}
+
+	return cancellation, true
+}
+
+// Helper function: move the event and its active ticket tiers to the next status, enforcing the state machine
+func transitionEvent(tx *gorm.DB, event *db.Event, next db.EventStatus) error {
+	if !event.Status.CanTransitionTo(next) {
+		return ConflictError{fmt.Sprintf("cannot move %s event to %s", event.Status, next)}
+	}
+
+	event.Status = next
+	if err := tx.Save(event).Error; err != nil {
+		return err
+	}
+
+	// Retired tiers stay canceled
+	return tx.
+		Model(&db.Ticket{}).
+		Where("event_id = ? AND status <> ?", event.ID, db.Canceled).
+		Update("status", next).Error
+}
+
+// Helper method: lock the event in the path, check if the current user can manage it, then apply the mutation
+// in a transaction. If failed, the response is written and false is returned
+func (server *Server) mutateEvent(
+	ctx *gin.Context,
+	route string,
+	mutate func(tx *gorm.DB, event *db.Event) error,
+) (db.Event, bool) {
+	claims := getClaims(ctx)
+
+	var event db.Event
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid event ID"})
+		return event, false
+	}
+
+	err = server.queries.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&event, id).Error; err != nil {
+			return err
+		}
+		if !canManageEvent(claims, event) {
+			return errPermissionDenied
+		}
+
+		return mutate(tx, &event)
+	})
+	if err != nil {
+		server.writeError(ctx, route, "event", err)
+		return event, false
+	}
+
+	// The event may appear in cached listing pages
+	if err := server.queries.InvalidateEventList(ctx); err != nil {
+		server.logger.Warn(route+": failed to invalidate event listing cache", "error", err)
+	}
+
+	return event, true
+}
+
+// Helper method: write the event along with its ticket tiers to the response
+func (server *Server) writeEvent(ctx *gin.Context, route string, event db.Event) {
+	var tickets []db.Ticket
+	if err := server.queries.DB.Where("event_id = ?", event.ID).Order("price").Find(&tickets).Error; err != nil {
+		server.logger.Error(route+": failed to get tickets", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewEventResponse(event, tickets))
+}
@@ -1,11 +1,13 @@
 package api
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
 	"github.com/gin-gonic/gin"
 )
 
@@ -23,9 +25,15 @@ func (server *Server) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Only access token can be used to access protected routes
+		if claims.TokenType != security.AccessToken {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{"invalid token type"})
+			return
+		}
+
 		// Check if token version valid
 		var tokenVersion int
-		rawToken, err := server.queries.GetCache(ctx, fmt.Sprintf("%d", claims.ID))
+		rawToken, err := server.queries.GetCache(ctx, db.TokenVersionKey(claims.ID))
 
 		if err == nil {
 			tokenVersion, _ = strconv.Atoi(rawToken)
@@ -40,10 +48,15 @@ func (server *Server) AuthMiddleware() gin.HandlerFunc {
 				Select("token_version").
 				Scan(&tokenVersion)
 			if result.Error != nil {
-				server.logger.Error("AuthMiddleware: failed to get token version", "error", err)
+				server.logger.Error("AuthMiddleware: failed to get token version", "error", result.Error)
 				ctx.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
 				return
 			}
+
+			// Cache the token version for the next requests. A newer version cached in the meantime is kept
+			if err := server.queries.CacheTokenVersion(ctx, claims.ID, uint(tokenVersion)); err != nil {
+				server.logger.Warn("AuthMiddleware: failed to cache token version", "error", err)
+			}
 		}
 
 		if claims.Version != tokenVersion {
@@ -51,12 +64,28 @@ func (server *Server) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Check if the session of the token still exists, so logging out of a device revokes its tokens too
+		if _, err := server.queries.GetSession(ctx, claims.ID, claims.SessionID); err != nil {
+			if errors.Is(err, db.ErrSessionNotFound) {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{"session has been revoked"})
+				return
+			}
+			server.logger.Error("AuthMiddleware: failed to get session", "error", err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+
 		// If match, put the claims to context before forward to the next handler
 		ctx.Set(claimsKey, claims)
 		ctx.Next()
 	}
 }
 
+// Helper function: get the claims put in the context by AuthMiddleware. Only use it in handlers behind AuthMiddleware
+func getClaims(ctx *gin.Context) *security.CustomClaims {
+	return ctx.MustGet(claimsKey).(*security.CustomClaims)
+}
+
 func (server *Server) CORSMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ctx.Header("Access-Control-Allow-Origin", "*")
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/danglnh07/ticket-system/service/notify"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// CORS is handled by CORSMiddleware, so any origin is accepted here
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Open a websocket connection to receive in-app notifications and live updates, like the attendance
+// of an event during check-in. The connection is kept until the client closes it
+func (server *Server) SubscribeNotifications(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		// The upgrader has already written the error response
+		server.logger.Warn("GET /api/me/notifications: failed to upgrade connection", "error", err)
+		return
+	}
+
+	client := notify.NewClient(claims.ID, conn)
+	server.hub.Subscribe(client)
+	defer server.hub.Unsubscribe(claims.ID, client)
+
+	// Messages only go to the client, but reading is required to notice when the connection is closed
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/oauth"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/danglnh07/ticket-system/util"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	oauthStateCookie = "oauth_state"
+	oauthStateTTL    = 10 * time.Minute
+)
+
+var errOauthProviderConflict = errors.New("account has been linked to another provider")
+
+// Cache key of the PKCE code verifier of an authorization request, keyed by its state
+func oauthStateKey(state string) string {
+	return fmt.Sprintf("oauth-state:%s", state)
+}
+
+// Redirect user to Google consent page. The state is kept in a cookie to prevent CSRF,
+// and the PKCE code verifier is kept in cache so it never leaves the server
+func (server *Server) GoogleLogin(ctx *gin.Context) {
+	state, err := security.RandomToken(16)
+	if err != nil {
+		server.logger.Error("GET /api/auth/google/login: failed to generate state", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	verifier, err := security.RandomToken(32)
+	if err != nil {
+		server.logger.Error("GET /api/auth/google/login: failed to generate code verifier", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	if err := server.queries.Cache.Set(ctx, oauthStateKey(state), verifier, oauthStateTTL).Err(); err != nil {
+		server.logger.Error("GET /api/auth/google/login: failed to save code verifier", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	secure := strings.HasPrefix(server.config.Domain, "https://")
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oauthStateCookie, state, int(oauthStateTTL.Seconds()), "/api/auth/google", "", secure, true)
+	ctx.Redirect(http.StatusFound, server.google.AuthCodeURL(state, oauth.CodeChallenge(verifier)))
+}
+
+func (server *Server) GoogleCallback(ctx *gin.Context) {
+	// Check if user denied the consent
+	if errMsg := ctx.Query("error"); errMsg != "" {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{fmt.Sprintf("google login failed: %s", errMsg)})
+		return
+	}
+
+	// Check the state against the cookie, then clear the cookie since the state can only be used once
+	state, err := ctx.Cookie(oauthStateCookie)
+	if err != nil || state == "" || state != ctx.Query("state") {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid oauth state"})
+		return
+	}
+	ctx.SetCookie(oauthStateCookie, "", -1, "/api/auth/google", "", false, true)
+
+	verifier, err := server.queries.Cache.GetDel(ctx, oauthStateKey(state)).Result()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"oauth state has expired"})
+		return
+	}
+
+	// Exchange code for tokens, then get the user profile
+	token, err := server.google.Exchange(ctx, ctx.Query("code"), verifier)
+	if err != nil {
+		server.logger.Warn("GET /api/auth/google/callback: failed to exchange code", "error", err)
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{"google login failed"})
+		return
+	}
+	user, err := server.google.UserInfo(ctx, token.AccessToken)
+	if err != nil {
+		server.logger.Error("GET /api/auth/google/callback: failed to get user info", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if !user.EmailVerified {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{"google email has not been verified"})
+		return
+	}
+
+	// Create or link the account
+	account, err := server.upsertOauthAccount(ctx, oauthProfile{
+		Provider:     db.Google,
+		ProviderID:   user.Sub,
+		Email:        user.Email,
+		Username:     strings.Split(user.Email, "@")[0],
+		Avatar:       user.Picture,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+	})
+	if err != nil {
+		if errors.Is(err, errOauthProviderConflict) {
+			ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+			return
+		}
+		server.logger.Error("GET /api/auth/google/callback: failed to save account", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	server.loginOauthAccount(ctx, account, "GET /api/auth/google/callback")
+}
+
+// The profile returned by an OAuth2 provider
+type oauthProfile struct {
+	Provider   db.OauthProvider
+	ProviderID string
+
+	// Email verified by the provider, empty if the provider does not give us one
+	Email string
+
+	// Preferred username, a random suffix is added to avoid collision
+	Username string
+	Avatar   string
+
+	AccessToken  string
+	RefreshToken string
+}
+
+// Helper method: find the account linked to the provider profile, or link the profile to the account with
+// the same verified email, or create a new account if none exists. The provider tokens are persisted
+func (server *Server) upsertOauthAccount(ctx context.Context, profile oauthProfile) (db.Account, error) {
+	var account db.Account
+	err := server.queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Find the account that has been linked to this provider
+		result := tx.
+			Where("oauth_provider = ? AND oauth_provider_id = ?", profile.Provider, profile.ProviderID).
+			Limit(1).
+			Find(&account)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// If not found, find the account with the same email to link with
+		if result.RowsAffected == 0 && profile.Email != "" {
+			result = tx.Where("email = ?", profile.Email).Limit(1).Find(&account)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 && account.OauthProvider != "" && account.OauthProvider != profile.Provider {
+				return errOauthProviderConflict
+			}
+		}
+
+		// If still not found, create a new account. Since the provider has verified the user, it is active.
+		// Email is required, so provider without email (Telegram) get a placeholder that cannot receive mail
+		if result.RowsAffected == 0 {
+			email := profile.Email
+			if email == "" {
+				email = fmt.Sprintf("%s-%s@users.noreply", profile.Provider, profile.ProviderID)
+			}
+			account = db.Account{
+				Username: generateUsername(profile.Username),
+				Email:    email,
+				Avatar:   profile.Avatar,
+				Status:   db.Active,
+				Role:     db.User,
+			}
+		}
+
+		// Link the provider and persist its tokens. Provider only returns refresh token on the first consent,
+		// so we keep the old one if there is no new one
+		account.OauthProvider = profile.Provider
+		account.OauthProviderID = sql.NullString{String: profile.ProviderID, Valid: true}
+		account.OauthAccessToken = sql.NullString{String: profile.AccessToken, Valid: profile.AccessToken != ""}
+		if profile.RefreshToken != "" {
+			account.OauthRefreshToken = sql.NullString{String: profile.RefreshToken, Valid: true}
+		}
+
+		// Email has been verified by the provider, so an account waiting for verification can be activated.
+		// Its password was set by whoever registered the email without proving they own it, which may not be
+		// the user signing in now, so it is dropped. The user can set a new one with the forgot password flow
+		if account.Status == db.Inactive && profile.Email != "" && account.Email == profile.Email {
+			account.Status = db.Active
+			account.Password = sql.NullString{}
+		}
+
+		return tx.Save(&account).Error
+	})
+
+	return account, err
+}
+
+// Helper method: link the provider profile to an existing account. A provider account can only be linked to
+// one of our accounts, and each of our accounts can only be linked to one provider
+func (server *Server) linkOauthAccount(ctx context.Context, accountID uint, profile oauthProfile) (db.Account, error) {
+	var account db.Account
+	err := server.queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&account, accountID).Error; err != nil {
+			return err
+		}
+		if account.OauthProvider != "" &&
+			(account.OauthProvider != profile.Provider || account.OauthProviderID.String != profile.ProviderID) {
+			return errOauthProviderConflict
+		}
+
+		// Check if the provider account has been linked to another account
+		var count int64
+		result := tx.
+			Model(&db.Account{}).
+			Where("oauth_provider = ? AND oauth_provider_id = ? AND id <> ?",
+				profile.Provider, profile.ProviderID, accountID).
+			Count(&count)
+		if result.Error != nil {
+			return result.Error
+		}
+		if count > 0 {
+			return errOauthProviderConflict
+		}
+
+		account.OauthProvider = profile.Provider
+		account.OauthProviderID = sql.NullString{String: profile.ProviderID, Valid: true}
+		return tx.Save(&account).Error
+	})
+
+	return account, err
+}
+
+// Helper method: start a new session for an account logged in via OAuth2 and write the tokens to response
+func (server *Server) loginOauthAccount(ctx *gin.Context, account db.Account, route string) {
+	if !server.checkAccountStatus(ctx, account) {
+		return
+	}
+
+	session, err := server.newSession(ctx, account.ID, "")
+	if err != nil {
+		server.logger.Error(route+": failed to create session", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	resp, err := server.issueTokens(ctx, account, session)
+	if err != nil {
+		server.logger.Error(route+": failed to issue tokens", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// Helper function: generate a username from a preferred name, keeping only alphanumeric characters
+// and adding a random suffix to avoid collision
+func generateUsername(base string) string {
+	var sb strings.Builder
+	for _, c := range base {
+		if c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			sb.WriteRune(c)
+		}
+		if sb.Len() == 20 {
+			break
+		}
+	}
+	if sb.Len() == 0 {
+		sb.WriteString("user")
+	}
+
+	return sb.String() + util.RandomString(6)
+}
+
+// Helper method: bind and verify the Telegram Login Widget payload. If failed, the response is written and false is returned
+func (server *Server) bindTelegramUser(ctx *gin.Context, route string) (oauth.TelegramUser, bool) {
+	var user oauth.TelegramUser
+	if err := ctx.ShouldBindJSON(&user); err != nil {
+		server.logger.Warn(route+": failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return user, false
+	}
+
+	// Without bot token, anyone could forge the hash, so we refuse to verify anything
+	if server.config.TelegramBotToken == "" {
+		ctx.JSON(http.StatusServiceUnavailable, ErrorResponse{"telegram login is not enabled"})
+		return user, false
+	}
+
+	err := oauth.VerifyTelegramLogin(user, server.config.TelegramBotToken, server.config.TelegramAuthMaxAge, time.Now())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{err.Error()})
+		return user, false
+	}
+
+	return user, true
+}
+
+// Helper function: build the provider profile from Telegram user
+func telegramProfile(user oauth.TelegramUser) oauthProfile {
+	username := user.Username
+	if username == "" {
+		username = user.FirstName
+	}
+
+	return oauthProfile{
+		Provider:   db.Telegram,
+		ProviderID: strconv.FormatInt(user.ID, 10),
+		Username:   username,
+		Avatar:     user.PhotoURL,
+	}
+}
+
+func (server *Server) TelegramLogin(ctx *gin.Context) {
+	user, ok := server.bindTelegramUser(ctx, "POST /api/auth/telegram")
+	if !ok {
+		return
+	}
+
+	// Create the account, or get the account linked to this Telegram user
+	account, err := server.upsertOauthAccount(ctx, telegramProfile(user))
+	if err != nil {
+		server.logger.Error("POST /api/auth/telegram: failed to save account", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	server.loginOauthAccount(ctx, account, "POST /api/auth/telegram")
+}
+
+// Link Telegram to the account of the current user, so they can login with Telegram later
+func (server *Server) LinkTelegram(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	user, ok := server.bindTelegramUser(ctx, "POST /api/me/telegram")
+	if !ok {
+		return
+	}
+
+	account, err := server.linkOauthAccount(ctx, claims.ID, telegramProfile(user))
+	if err != nil {
+		if errors.Is(err, errOauthProviderConflict) {
+			ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+			return
+		}
+		server.logger.Error("POST /api/me/telegram: failed to link account", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewAccountResponse(account))
+}
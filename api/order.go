@@ -0,0 +1,300 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/payment"
+	"github.com/danglnh07/ticket-system/service/worker"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+)
+
+// At most this many tickets can be bought in one order
+const maxOrderTickets = 10
+
+type OrderItemRequest struct {
+	TicketID uint `json:"ticket_id" binding:"required"`
+	Quantity uint `json:"quantity" binding:"required,min=1,max=10"`
+
+	// Seats to book in a seated tier, one for each ticket. If empty, the best available seats are assigned
+	SeatIDs []uint `json:"seat_ids" binding:"omitempty,max=10"`
+}
+
+type CreateOrderRequest struct {
+	Items     []OrderItemRequest `json:"items" binding:"required,min=1,max=10,dive"`
+	PromoCode string             `json:"promo_code" binding:"max=64"`
+}
+
+type OrderResponse struct {
+	ID        uint              `json:"id"`
+	AccountID uint              `json:"account_id"`
+	Amount    int64             `json:"amount"`
+	Currency  string            `json:"currency"`
+	Status    db.OrderStatus    `json:"status"`
+	PaymentID *uint             `json:"payment_id,omitempty"`
+	Bookings  []BookingResponse `json:"bookings"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func NewOrderResponse(order db.Order) OrderResponse {
+	resp := OrderResponse{
+		ID:        order.ID,
+		AccountID: order.AccountID,
+		Amount:    order.Amount,
+		Currency:  order.Currency,
+		Status:    order.Status,
+		PaymentID: order.PaymentID,
+		Bookings:  make([]BookingResponse, len(order.Bookings)),
+		CreatedAt: order.CreatedAt,
+	}
+	for i, booking := range order.Bookings {
+		resp.Bookings[i] = NewBookingResponse(booking)
+	}
+	return resp
+}
+
+type CreateOrderResponse struct {
+	OrderResponse
+
+	// Client secret of the payment intent of the order
+	SecretKey string `json:"secret_key"`
+}
+
+// Check out several tiers at once. All tickets are reserved together in a single order with a single payment
+// intent, so the buyer pays once and either gets every ticket or none of them
+func (server *Server) CreateOrder(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req CreateOrderRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/orders: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	items := make([]db.OrderItem, len(req.Items))
+	var quantity uint
+	for i, item := range req.Items {
+		if len(item.SeatIDs) > 0 && len(item.SeatIDs) != int(item.Quantity) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"number of seats must match the quantity"})
+			return
+		}
+		quantity += item.Quantity
+		items[i] = db.OrderItem{TicketID: item.TicketID, Quantity: item.Quantity, SeatIDs: item.SeatIDs}
+	}
+	if quantity > maxOrderTickets {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"too many tickets in one order"})
+		return
+	}
+
+	order, err := server.queries.CreateOrder(ctx, claims.ID, items, server.config.BookingHoldDuration)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrSoldOut), errors.Is(err, db.ErrTicketNotOnSale), errors.Is(err, db.ErrSeatUnavailable):
+			ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+		case errors.Is(err, db.ErrInvalidSeatSelection):
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{err.Error()})
+		default:
+			server.writeError(ctx, "POST /api/orders", "ticket", err)
+		}
+		return
+	}
+
+	// The tickets are given back if the payment cannot be started, so the order can simply be placed again
+	secretKey, err := server.startOrderPayment(ctx, claims.ID, &order, req.PromoCode)
+	if err != nil {
+		ids := make([]uint, len(order.Bookings))
+		for i, booking := range order.Bookings {
+			ids[i] = booking.ID
+		}
+		released, releaseErr := server.queries.ReleaseBookings(ctx, ids)
+		if releaseErr != nil {
+			server.logger.Warn("POST /api/orders: failed to release bookings", "error", releaseErr)
+		}
+		server.offerWaitlist(ctx, "POST /api/orders", bookingTickets(released)...)
+		if errors.Is(err, db.ErrOrderNotPending) {
+			ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+			return
+		}
+		server.writeError(ctx, "POST /api/orders", "ticket", err)
+		return
+	}
+
+	// Release the order once the hold expires. If this fails, the cron sweeper will release it instead
+	ids := make([]uint, len(order.Bookings))
+	for i, booking := range order.Bookings {
+		ids[i] = booking.ID
+	}
+	err = server.distributor.DistributeTask(ctx, worker.ReleaseBooking, worker.ReleaseBookingPayload{BookingIDs: ids},
+		asynq.ProcessIn(server.config.BookingHoldDuration))
+	if err != nil {
+		server.logger.Warn("POST /api/orders: failed to schedule booking release", "error", err)
+	}
+
+	ctx.JSON(http.StatusCreated, CreateOrderResponse{NewOrderResponse(order), secretKey})
+}
+
+// Helper method: start the payment of a committed order. The intent is created outside of any transaction,
+// so the reserved tiers are not locked while waiting for the provider, then recorded in a short transaction.
+// If the order cannot be linked to it, the intent is canceled
+func (server *Server) startOrderPayment(ctx *gin.Context, accountID uint, order *db.Order, promoCode string) (string, error) {
+	var bookings []db.Booking
+	err := server.queries.DB.Preload("Ticket").Where("order_id = ?", order.ID).Order("id").Find(&bookings).Error
+	if err != nil {
+		return "", err
+	}
+	amount, promo, err := server.paymentAmount(server.queries.DB, accountID, bookings, promoCode)
+	if err != nil {
+		return "", err
+	}
+
+	ids := make([]uint, len(bookings))
+	prices := make([]float64, len(bookings))
+	for i, booking := range bookings {
+		ids[i] = booking.ID
+		prices[i] = booking.Ticket.Price
+	}
+
+	// A new order has a new key, retrying the request creates a new order
+	intent, err := server.payment.CreatePaymentIntent(amount,
+		map[string]string{
+			payment.MetadataAccountID:  strconv.FormatUint(uint64(accountID), 10),
+			payment.MetadataBookingIDs: payment.FormatIDs(ids),
+			payment.MetadataOrderID:    strconv.FormatUint(uint64(order.ID), 10),
+		},
+		fmt.Sprintf("order:%d:%d", order.ID, amount))
+	if err != nil {
+		return "", err
+	}
+
+	// Each booking knows its share of the total, so it can be refunded alone
+	for i, share := range payment.SplitAmount(amount, prices) {
+		bookings[i].Amount = share
+	}
+	order.Bookings = bookings
+	record := db.Payment{
+		AccountID: accountID,
+		IntentID:  intent.ID,
+		Amount:    amount,
+		Currency:  payment.Currency,
+		Status:    db.PaymentPending,
+	}
+	if promo != nil {
+		record.PromoCodeID = &promo.ID
+	}
+	if err := server.queries.SetOrderPayment(ctx, order, &record); err != nil {
+		if _, err := server.payment.CancelPaymentIntent(intent.ID); err != nil {
+			server.logger.Warn("POST /api/orders: failed to cancel payment intent", "error", err)
+		}
+		return "", err
+	}
+
+	return intent.ClientSecret, nil
+}
+
+// Cancel a pending order of the current user, giving all of its tickets back before the hold expires
+func (server *Server) CancelOrder(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid order ID"})
+		return
+	}
+
+	var order db.Order
+	if err := server.queries.DB.Preload("Bookings").Where("account_id = ?", claims.ID).First(&order, id).Error; err != nil {
+		server.writeError(ctx, "DELETE /api/orders/:id", "order", err)
+		return
+	}
+	if order.Status != db.OrderPending {
+		ctx.JSON(http.StatusConflict, ErrorResponse{"only pending order can be canceled"})
+		return
+	}
+
+	ids := make([]uint, len(order.Bookings))
+	for i, booking := range order.Bookings {
+		ids[i] = booking.ID
+	}
+	released, err := server.queries.ReleaseBookings(ctx, ids)
+	if err != nil {
+		if released == nil {
+			server.logger.Error("DELETE /api/orders/:id: failed to release bookings", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+		server.logger.Warn("DELETE /api/orders/:id: failed to update ticket stock", "error", err)
+	}
+	if len(released) == 0 {
+		// Paid or released in the meantime
+		ctx.JSON(http.StatusConflict, ErrorResponse{"only pending order can be canceled"})
+		return
+	}
+
+	server.offerWaitlist(ctx, "DELETE /api/orders/:id", bookingTickets(released)...)
+	server.cancelPayments(ctx, "DELETE /api/orders/:id", worker.ReleasedPayments(released)...)
+	order.Status = db.OrderReleased
+	order.Bookings = released
+	ctx.JSON(http.StatusOK, NewOrderResponse(order))
+}
+
+type ListMyOrdersQuery struct {
+	PageQuery
+
+	Status db.OrderStatus `form:"status" binding:"omitempty,oneof=pending paid failed released"`
+}
+
+// List the orders of the current user, newest first
+func (server *Server) ListMyOrders(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var query ListMyOrdersQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/me/orders: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	tx := server.queries.DB.Model(&db.Order{}).Where("account_id = ?", claims.ID)
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/me/orders: failed to count orders", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var orders []db.Order
+	err := tx.
+		Preload("Bookings", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Order("id DESC").
+		Offset(offset).
+		Limit(query.PageSize).
+		Find(&orders).Error
+	if err != nil {
+		server.logger.Error("GET /api/me/orders: failed to list orders", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	items := make([]OrderResponse, len(orders))
+	for i, order := range orders {
+		items[i] = NewOrderResponse(order)
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[OrderResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
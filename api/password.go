@@ -0,0 +1,187 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/danglnh07/ticket-system/service/worker"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+func (server *Server) ForgotPassword(ctx *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/auth/forgot-password: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	// We always return the same response, so no one can use this endpoint to check if an email is registered
+	resp := MessageResponse{"if the email is registered, a password reset link has been sent"}
+
+	var account db.Account
+	result := server.queries.DB.Where("email = ?", req.Email).Limit(1).Find(&account)
+	if result.Error != nil {
+		server.logger.Error("POST /api/auth/forgot-password: failed to get account", "error", result.Error)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if result.RowsAffected == 0 || account.Status == db.Banned {
+		ctx.JSON(http.StatusOK, resp)
+		return
+	}
+
+	// Generate the reset token, only its hash is stored
+	token, err := security.RandomToken(32)
+	if err != nil {
+		server.logger.Error("POST /api/auth/forgot-password: failed to generate token", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	err = server.queries.StoreResetToken(ctx, account.ID, security.Hash(token), server.config.ResetTokenExpiration)
+	if err != nil {
+		server.logger.Error("POST /api/auth/forgot-password: failed to store token", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Send the reset email
+	err = server.distributor.DistributeTask(ctx, worker.SendResetPasswordEmail, worker.SendResetPasswordEmailPayload{
+		Email:     account.Email,
+		Username:  account.Username,
+		Link:      fmt.Sprintf("%s?token=%s", server.config.ResetPasswordURL, url.QueryEscape(token)),
+		ExpiresIn: int(server.config.ResetTokenExpiration.Minutes()),
+	}, asynq.MaxRetry(5))
+	if err != nil {
+		server.logger.Error("POST /api/auth/forgot-password: failed to send reset email", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8,max=72"`
+}
+
+func (server *Server) ResetPassword(ctx *gin.Context) {
+	var req ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/auth/reset-password: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	// Consume the token
+	accountID, err := server.queries.UseResetToken(ctx, security.Hash(req.Token))
+	if err != nil {
+		if errors.Is(err, db.ErrResetTokenNotFound) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid or expired reset token"})
+			return
+		}
+		server.logger.Error("POST /api/auth/reset-password: failed to use reset token", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Since the user has proved they own the email, an account waiting for verification is activated too
+	updates := map[string]any{}
+	var account db.Account
+	if err := server.queries.DB.First(&account, accountID).Error; err != nil {
+		server.logger.Error("POST /api/auth/reset-password: failed to get account", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if account.Status == db.Banned {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{"account has been banned"})
+		return
+	}
+	if account.Status == db.Inactive {
+		updates["status"] = db.Active
+	}
+
+	if !server.updatePassword(ctx, accountID, req.NewPassword, updates, "POST /api/auth/reset-password") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, MessageResponse{"password has been reset, please login again"})
+}
+
+type ChangePasswordRequest struct {
+	// Account created via OAuth2 has no password, so they can set one without the old password
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password" binding:"required,min=8,max=72"`
+}
+
+func (server *Server) ChangePassword(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("PUT /api/me/password: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	var account db.Account
+	if err := server.queries.DB.First(&account, claims.ID).Error; err != nil {
+		server.logger.Error("PUT /api/me/password: failed to get account", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	if account.Password.Valid && !security.BcryptCompare(account.Password.String, req.OldPassword) {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{"old password is incorrect"})
+		return
+	}
+
+	if !server.updatePassword(ctx, account.ID, req.NewPassword, map[string]any{}, "PUT /api/me/password") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, MessageResponse{"password has been changed, please login again"})
+}
+
+// Helper method: update the password hash (along with other updates), then revoke all existing sessions.
+// If failed, the response is written and false is returned
+func (server *Server) updatePassword(
+	ctx *gin.Context,
+	accountID uint,
+	password string,
+	updates map[string]any,
+	route string,
+) bool {
+	hashed, err := security.BcryptHash(password)
+	if err != nil {
+		server.logger.Error(route+": failed to hash password", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return false
+	}
+	updates["password"] = sql.NullString{String: hashed, Valid: true}
+
+	result := server.queries.DB.Model(&db.Account{}).Where("id = ?", accountID).Updates(updates)
+	if result.Error != nil {
+		server.logger.Error(route+": failed to update password", "error", result.Error)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return false
+	}
+
+	if err := server.revokeAllSessions(ctx, accountID); err != nil {
+		server.logger.Error(route+": failed to revoke sessions", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return false
+	}
+
+	return true
+}
@@ -2,15 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
+	"github.com/danglnh07/ticket-system/db"
 	"github.com/danglnh07/ticket-system/service/payment"
+	"github.com/danglnh07/ticket-system/service/worker"
 	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
 	"github.com/stripe/stripe-go/v82"
-	"github.com/stripe/stripe-go/v82/webhook"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Stripe config response struct
@@ -22,92 +29,244 @@ func (server *Server) StripeConfig(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, StripeConfigResponse{server.config.StripePublishableKey})
 }
 
+type CreatePaymentIntentRequest struct {
+	// The pending bookings to pay for. They must belong to the same event
+	BookingIDs []uint `json:"booking_ids" binding:"required,min=1,max=10"`
+	PromoCode  string `json:"promo_code" binding:"max=64"`
+}
+
 type PaymentIntentResponse struct {
 	SecretKey string `json:"secret_key"`
+	PaymentID uint   `json:"payment_id"`
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
 }
 
+// Create a payment intent for pending bookings of the current user. The amount is computed from the ticket prices,
+// the membership discount of the user and the promo code, never taken from the client
 func (server *Server) CreatePaymentIntent(ctx *gin.Context) {
-	// Get the amount from query string
-	amount, err := strconv.ParseInt(ctx.Query("amount"), 10, 64)
+	claims := getClaims(ctx)
+
+	var req CreatePaymentIntentRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/payment/intent: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+	ids := slices.Clone(req.BookingIDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	now := time.Now()
+	var (
+		bookings []db.Booking
+		amount   int64
+		promo    *db.PromoCode
+	)
+	err := server.queries.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the bookings while checking them, so they cannot be paid by another payment in the meantime
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Ticket").
+			Where("id IN ? AND account_id = ?", ids, claims.ID).
+			Order("id").
+			Find(&bookings).Error
+		if err != nil {
+			return err
+		}
+		if len(bookings) != len(ids) {
+			return NotFoundError{"booking"}
+		}
+
+		eventID := bookings[0].Ticket.EventID
+		paymentID := bookings[0].PaymentID
+		for _, booking := range bookings {
+			if booking.Status != db.Pending || !booking.HoldExpiresAt.Time.After(now) {
+				return ConflictError{fmt.Sprintf("booking %d is not waiting for payment", booking.ID)}
+			}
+			if booking.OrderID != nil {
+				return ConflictError{fmt.Sprintf("booking %d belongs to an order, pay the order instead", booking.ID)}
+			}
+			if booking.Ticket.EventID != eventID {
+				return ConflictError{"bookings must belong to the same event"}
+			}
+			if !equalID(booking.PaymentID, paymentID) {
+				return ConflictError{"bookings belong to different payments"}
+			}
+		}
+
+		amount, promo, err = server.paymentAmount(tx, claims.ID, bookings, req.PromoCode)
+		if err != nil {
+			return err
+		}
+
+		// If the bookings already have a payment, the same amount must be asked again,
+		// otherwise they would be charged twice
+		if paymentID != nil {
+			var existing db.Payment
+			if err := tx.First(&existing, *paymentID).Error; err != nil {
+				return err
+			}
+			if existing.Status != db.PaymentPending || existing.Amount != amount {
+				return ConflictError{"a different payment has already been started for these bookings"}
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		server.logger.Warn("POST /api/payment/intent: invalid amount query parameter", "error", err)
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid value for amount"})
+		server.writeError(ctx, "POST /api/payment/intent", "booking", err)
 		return
 	}
 
-	// Create payment intent
-	clientSecret, err := payment.CreatePaymentIntent(amount)
+	// The intent is created once the bookings are no longer locked. The same bookings and amount always give
+	// the same key, so retries return the same intent
+	intent, err := server.payment.CreatePaymentIntent(amount,
+		map[string]string{
+			payment.MetadataAccountID:  strconv.FormatUint(uint64(claims.ID), 10),
+			payment.MetadataBookingIDs: payment.FormatIDs(ids),
+		},
+		fmt.Sprintf("payment-intent:%d:%s:%d", claims.ID, payment.FormatIDs(ids), amount))
 	if err != nil {
-		server.logger.Error("POST /api/payment/intent: failed to create payment intent", "error", err)
-		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		server.writeError(ctx, "POST /api/payment/intent", "booking", err)
 		return
 	}
 
-	// Return the client secret key back as an object the frontend expects
-	ctx.JSON(http.StatusOK, PaymentIntentResponse{clientSecret})
-}
+	// Each booking knows its share of the total, so it can be refunded alone
+	prices := make([]float64, len(bookings))
+	for i, booking := range bookings {
+		prices[i] = booking.Ticket.Price
+	}
+	for i, share := range payment.SplitAmount(amount, prices) {
+		bookings[i].Amount = share
+	}
+	record := db.Payment{
+		AccountID: claims.ID,
+		IntentID:  intent.ID,
+		Amount:    amount,
+		Currency:  payment.Currency,
+		Status:    db.PaymentPending,
+	}
+	if promo != nil {
+		record.PromoCodeID = &promo.ID
+	}
 
-type RefundRequest struct {
-	PaymentIntentID string `json:"piID" binding:"required"`
-	Reason          string `json:"reason" binding:"required"`
-	Amount          int64  `json:"amount" binding:"min=1"`
-}
+	// The bookings may have been released or paid by another payment while the intent was created,
+	// the intent is then canceled so it cannot be paid
+	if err := server.queries.SetBookingPayment(ctx, claims.ID, bookings, &record, time.Now()); err != nil {
+		if _, err := server.payment.CancelPaymentIntent(intent.ID); err != nil {
+			server.logger.Warn("POST /api/payment/intent: failed to cancel payment intent", "error", err)
+		}
+		if errors.Is(err, db.ErrBookingNotPending) {
+			err = ConflictError{err.Error()}
+		}
+		server.writeError(ctx, "POST /api/payment/intent", "booking", err)
+		return
+	}
 
-type RefundResponse struct {
-	ID        string    `json:"id"`
-	Amount    int64     `json:"amount"`
-	CreatedAt time.Time `json:"created_at"`
-	Status    string    `json:"status"`
+	// Return the client secret key back as an object the frontend expects
+	ctx.JSON(http.StatusOK, PaymentIntentResponse{
+		SecretKey: intent.ClientSecret,
+		PaymentID: record.ID,
+		Amount:    record.Amount,
+		Currency:  record.Currency,
+	})
 }
 
-func (server *Server) Refund(ctx *gin.Context) {
-	var req RefundRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		server.logger.Warn("POST /api/payment/intent: failed to get request body", "error", err)
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
-		return
+// Helper method: compute the amount to pay for pending bookings of an account from the ticket prices,
+// the membership discount of the account and the promo code. Return the promo code applied, if any
+func (server *Server) paymentAmount(
+	tx *gorm.DB,
+	accountID uint,
+	bookings []db.Booking,
+	promoCode string,
+) (int64, *db.PromoCode, error) {
+	prices := make([]float64, len(bookings))
+	for i, booking := range bookings {
+		prices[i] = booking.Ticket.Price
+	}
+
+	var account db.Account
+	if err := tx.First(&account, accountID).Error; err != nil {
+		return 0, nil, err
 	}
+	membershipDiscount, err := db.MembershipDiscount(tx, account.Point)
+	if err != nil {
+		return 0, nil, err
+	}
+	var promo *db.PromoCode
+	if promoCode != "" {
+		found, err := db.FindPromoCode(tx, promoCode, bookings[0].Ticket.EventID, time.Now())
+		if err != nil {
+			if errors.Is(err, db.ErrPromoCodeInvalid) {
+				return 0, nil, ConflictError{err.Error()}
+			}
+			return 0, nil, err
+		}
 
-	// Check if piID exists in database, amount is not exceed the total amount
+		// A promo code of an event cannot discount tickets of other events
+		for _, booking := range bookings {
+			if found.EventID != nil && *found.EventID != booking.Ticket.EventID {
+				return 0, nil, ConflictError{db.ErrPromoCodeInvalid.Error()}
+			}
+		}
+		promo = &found
+	}
+	var promoDiscount float64
+	if promo != nil {
+		promoDiscount = promo.Discount
+	}
+	amount := payment.ComputeAmount(prices, membershipDiscount, promoDiscount)
+	if amount < payment.MinimumAmount {
+		return 0, nil, ConflictError{"amount is below the minimum payment amount"}
+	}
+	return amount, promo, nil
+}
 
-	// Check if the reason match
-	reason := payment.RefundReason(req.Reason)
-	if reason != payment.Duplicate && reason != payment.Fraudulent && reason != payment.RequestedByCustomer {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid reason"})
+// Helper method: cancel the intents of payments that nothing can be bought with anymore, so they cannot be paid late.
+// If this fails, a late payment is still refunded by the webhook
+func (server *Server) cancelPayments(ctx *gin.Context, route string, paymentIDs ...uint) {
+	if len(paymentIDs) == 0 {
 		return
 	}
-
-	// Refund
-	refund, err := payment.CreateRefund(req.PaymentIntentID, reason, req.Amount)
+	err := server.distributor.DistributeTask(ctx, worker.CancelPayments, worker.CancelPaymentsPayload{PaymentIDs: paymentIDs})
 	if err != nil {
-		server.logger.Error("/api/payment/refund: failed to create a refund", "error", err)
-		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
-		return
+		server.logger.Warn(route+": failed to cancel payments", "error", err)
 	}
+}
 
-	ctx.JSON(http.StatusOK, RefundResponse{
-		ID:        refund.ID,
-		Amount:    refund.Amount,
-		CreatedAt: time.Unix(0, refund.Created),
-		Status:    string(refund.Status),
-	})
+// Helper function: check if two nullable IDs are equal
+func equalID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
-// Webhook handler for stripe
+// Loyalty points awarded for a payment: one point for each whole currency unit paid
+func loyaltyPoints(amount int64) uint {
+	if amount <= 0 {
+		return 0
+	}
+	return uint(amount / 100)
+}
+
+// Webhook handler for stripe. Each event is processed at most once, keyed by its event ID,
+// so redelivered events never issue tickets or award points twice
 func (server *Server) WebhookHandler(ctx *gin.Context) {
 	// Read the payload
 	payload, err := io.ReadAll(ctx.Request.Body)
 	if err != nil {
-		server.logger.Error("/webhook: failed to read request body")
+		server.logger.Error("/webhook: failed to read request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
 		return
 	}
 	defer ctx.Request.Body.Close()
 
 	// Construct event
-	event, err := webhook.ConstructEvent(
-		payload, ctx.Request.Header.Get("Stripe-Signature"), server.config.StripeWebhookSecret)
+	event, err := server.payment.ConstructWebhookEvent(payload, ctx.Request.Header.Get("Stripe-Signature"))
 	if err != nil {
 		server.logger.Error("/webhook: failed to construct event", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid signature"})
 		return
 	}
 
@@ -115,16 +274,350 @@ func (server *Server) WebhookHandler(ctx *gin.Context) {
 	var pi stripe.PaymentIntent
 	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
 		server.logger.Error("/webhook: failed to unmarshal the payment intent", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid payment intent"})
 		return
 	}
 	switch event.Type {
 	case "payment_intent.succeeded":
-		// --> IMPLEMENT LOGIC HERE
-		server.logger.Info("/webhook: payment success", "id", pi.ID)
+		err = server.handlePaymentSucceeded(ctx, event.ID, string(event.Type), &pi)
 	case "payment_intent.payment_failed":
-		// --> IMPLEMENT LOGIC HERE
-		server.logger.Warn("/webhook: payment failed", "id", pi.ID)
+		err = server.handlePaymentFailed(ctx, event.ID, string(event.Type), &pi)
 	default:
 		server.logger.Warn("/webhook: unsupported event", "type", event.Type)
 	}
+
+	switch {
+	case errors.Is(err, db.ErrWebhookProcessed):
+		server.logger.Info("/webhook: event has already been processed", "id", event.ID)
+	case err != nil:
+		// Stripe retries the event if we do not respond with 2xx
+		server.logger.Error("/webhook: failed to process event", "id", event.ID, "type", event.Type, "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, MessageResponse{"received"})
+}
+
+// Helper function: get the account and bookings linked to a payment intent
+func paymentBookings(pi *stripe.PaymentIntent) (uint, []uint, error) {
+	accountID, err := strconv.ParseUint(pi.Metadata[payment.MetadataAccountID], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid %s metadata: %w", payment.MetadataAccountID, err)
+	}
+	ids, err := payment.ParseIDs(pi.Metadata[payment.MetadataBookingIDs])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid %s metadata: %w", payment.MetadataBookingIDs, err)
+	}
+	return uint(accountID), ids, nil
+}
+
+// Helper method: make the bookings of a successful payment valid, award loyalty points
+// and send the tickets to the buyer
+func (server *Server) handlePaymentSucceeded(ctx *gin.Context, eventID, eventType string, pi *stripe.PaymentIntent) error {
+	if _, ok := pi.Metadata[payment.MetadataListingID]; ok {
+		return server.handleResaleSucceeded(ctx, eventID, eventType, pi)
+	}
+
+	accountID, ids, err := paymentBookings(pi)
+	if err != nil {
+		// Not a payment of bookings, there is nothing we can do with it
+		server.logger.Warn("/webhook: payment is not linked to any booking", "id", pi.ID, "error", err)
+		return nil
+	}
+
+	var (
+		confirmations []worker.SendBookingConfirmationPayload
+		lateRefund    *worker.RefundLatePaymentPayload
+	)
+	err = server.queries.ProcessWebhookEvent(ctx, eventID, eventType, func(tx *gorm.DB) error {
+		record, err := updatePayment(tx, pi.ID, db.PaymentSucceeded)
+		if err != nil {
+			return err
+		}
+		if record != nil {
+			err := tx.Create(&db.LedgerEntry{
+				AccountID: record.AccountID,
+				Type:      db.LedgerCharge,
+				Amount:    pi.AmountReceived,
+				Currency:  string(pi.Currency),
+				PaymentID: &record.ID,
+				Reference: pi.ID,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		confirmed, err := db.ConfirmBookings(tx, accountID, ids)
+		if err != nil {
+			return err
+		}
+		if len(confirmed) < len(ids) {
+			// The holds have expired before the payment completed, so the share of the released bookings
+			// is given back. Bookings of an order are released together, so an order is refunded in full
+			server.logger.Warn("/webhook: payment received for bookings that are no longer pending",
+				"id", pi.ID, "bookings", ids, "confirmed", len(confirmed))
+			amount := pi.AmountReceived - pi.AmountReceived*int64(len(confirmed))/int64(len(ids))
+			lateRefund = server.latePaymentRefund(record, pi, amount)
+		}
+		if len(confirmed) == 0 {
+			return nil
+		}
+
+		// Only award points for the bookings that have actually been confirmed
+		points := loyaltyPoints(pi.AmountReceived * int64(len(confirmed)) / int64(len(ids)))
+		result := tx.
+			Model(&db.Account{}).
+			Where("id = ?", accountID).
+			Update("point", gorm.Expr("point + ?", points))
+		if result.Error != nil {
+			return result.Error
+		}
+
+		confirmations, err = server.bookingConfirmations(tx, accountID, confirmed)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	server.refundLatePayment(ctx, lateRefund)
+	server.sendBookingConfirmations(ctx, confirmations)
+	return nil
+}
+
+// Helper method: move a resold ticket to the buyer of a successful payment, credit the seller
+// and send the new ticket to the buyer
+func (server *Server) handleResaleSucceeded(ctx *gin.Context, eventID, eventType string, pi *stripe.PaymentIntent) error {
+	listingID, err := strconv.ParseUint(pi.Metadata[payment.MetadataListingID], 10, 64)
+	if err != nil {
+		server.logger.Warn("/webhook: payment is not linked to any listing", "id", pi.ID, "error", err)
+		return nil
+	}
+
+	var (
+		sold          db.ResaleListing
+		confirmations []worker.SendBookingConfirmationPayload
+		lateRefund    *worker.RefundLatePaymentPayload
+	)
+	err = server.queries.ProcessWebhookEvent(ctx, eventID, eventType, func(tx *gorm.DB) error {
+		record, err := updatePayment(tx, pi.ID, db.PaymentSucceeded)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			server.logger.Warn("/webhook: payment of a listing has no payment record", "id", pi.ID)
+			return nil
+		}
+		err = tx.Create(&db.LedgerEntry{
+			AccountID: record.AccountID,
+			Type:      db.LedgerCharge,
+			Amount:    pi.AmountReceived,
+			Currency:  string(pi.Currency),
+			PaymentID: &record.ID,
+			Reference: pi.ID,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		listing, err := db.CompleteResale(tx, uint(listingID), record.ID, pi.ID)
+		if errors.Is(err, db.ErrListingUnavailable) || errors.Is(err, db.ErrBookingNotValid) {
+			// The hold has expired or the booking has changed before the payment completed
+			server.logger.Warn("/webhook: payment received for a listing that can no longer be sold",
+				"id", pi.ID, "listing", listingID, "error", err)
+			lateRefund = server.latePaymentRefund(record, pi, pi.AmountReceived)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sold = listing
+
+		result := tx.
+			Model(&db.Account{}).
+			Where("id = ?", record.AccountID).
+			Update("point", gorm.Expr("point + ?", loyaltyPoints(pi.AmountReceived)))
+		if result.Error != nil {
+			return result.Error
+		}
+
+		confirmations, err = server.bookingConfirmations(tx, record.AccountID, []db.Booking{listing.Booking})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	server.refundLatePayment(ctx, lateRefund)
+	if sold.ID == 0 {
+		return nil
+	}
+
+	server.sendBookingConfirmations(ctx, confirmations)
+
+	// The sale has been recorded, so a failure to notify the seller is only logged
+	err = server.distributor.DistributeTask(ctx, worker.SendNotification, worker.SendNotificationPayload{
+		ReceiverID: sold.SellerID,
+		Title:      "Ticket sold",
+		Content: fmt.Sprintf("Your ticket has been sold, %.2f %s has been credited to your account",
+			float64(sold.Price-sold.Fee)/100, sold.Currency),
+	})
+	if err != nil {
+		server.logger.Warn("/webhook: failed to send notification", "error", err)
+	}
+	return nil
+}
+
+// Helper method: get the refund of a payment, or a part of it, that has been received after what it pays for
+// has been given to someone else. Return nil if there is nothing to refund
+func (server *Server) latePaymentRefund(
+	record *db.Payment,
+	pi *stripe.PaymentIntent,
+	amount int64,
+) *worker.RefundLatePaymentPayload {
+	if amount <= 0 {
+		return nil
+	}
+	if record == nil {
+		// Not a payment of ours, it cannot be refunded from here
+		server.logger.Error("/webhook: late payment has no payment record, it must be refunded manually", "id", pi.ID)
+		return nil
+	}
+	return &worker.RefundLatePaymentPayload{PaymentID: record.ID, Amount: amount}
+}
+
+// Helper method: refund a late payment once the webhook transaction is committed, so the provider is never
+// called while the bookings are locked. The task is retried on failure, but the webhook event will not be
+// processed again, so if it cannot even be queued, the payment must be refunded manually
+func (server *Server) refundLatePayment(ctx *gin.Context, refund *worker.RefundLatePaymentPayload) {
+	if refund == nil {
+		return
+	}
+	err := server.distributor.DistributeTask(ctx, worker.RefundLatePayment, *refund,
+		asynq.TaskID(fmt.Sprintf("%s:%d", worker.RefundLatePayment, refund.PaymentID)), asynq.MaxRetry(10))
+	if err != nil {
+		server.logger.Error("/webhook: failed to refund late payment, it must be refunded manually",
+			"payment_id", refund.PaymentID, "amount", refund.Amount, "error", err)
+	}
+}
+
+// Helper method: build the confirmation emails of the bookings within a transaction, one email for each event
+func (server *Server) bookingConfirmations(
+	tx *gorm.DB,
+	accountID uint,
+	confirmed []db.Booking,
+) ([]worker.SendBookingConfirmationPayload, error) {
+	var account db.Account
+	if err := tx.First(&account, accountID).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(confirmed))
+	for i, booking := range confirmed {
+		ids[i] = booking.ID
+	}
+	var bookings []db.Booking
+	if err := tx.Preload("Ticket.Event").Where("id IN ?", ids).Order("id").Find(&bookings).Error; err != nil {
+		return nil, err
+	}
+
+	var payloads []worker.SendBookingConfirmationPayload
+	index := map[uint]int{}
+	for _, booking := range bookings {
+		event := booking.Ticket.Event
+		i, ok := index[event.ID]
+		if !ok {
+			i = len(payloads)
+			index[event.ID] = i
+			payloads = append(payloads, worker.SendBookingConfirmationPayload{
+				Email:     account.Email,
+				Username:  account.Username,
+				EventName: event.Name,
+				Location:  event.Location,
+				StartTime: event.StartTime,
+			})
+		}
+		code, err := server.ticketCode(booking)
+		if err != nil {
+			return nil, err
+		}
+		payloads[i].Tickets = append(payloads[i].Tickets, worker.BookingConfirmationTicket{
+			BookingID:  booking.ID,
+			Rank:       booking.Ticket.Rank,
+			SeatNumber: booking.SeatNumber,
+			Code:       code,
+		})
+	}
+	return payloads, nil
+}
+
+// Helper method: enqueue the confirmation emails once the bookings are committed, and add each booking
+// to the calendar of its owner. The webhook event has been recorded by then and will not be processed again,
+// so a failure is only logged
+func (server *Server) sendBookingConfirmations(ctx *gin.Context, payloads []worker.SendBookingConfirmationPayload) {
+	for _, pl := range payloads {
+		err := server.distributor.DistributeTask(ctx, worker.SendBookingConfirmation, pl, asynq.MaxRetry(5))
+		if err != nil {
+			server.logger.Warn("/webhook: failed to send booking confirmation", "email", pl.Email, "error", err)
+		}
+
+		for _, ticket := range pl.Tickets {
+			err := server.distributor.DistributeTask(ctx, worker.AddCalendarEntry,
+				worker.AddCalendarEntryPayload{BookingID: ticket.BookingID}, asynq.MaxRetry(3))
+			if err != nil {
+				server.logger.Warn("/webhook: failed to add calendar entry", "booking_id", ticket.BookingID, "error", err)
+			}
+		}
+	}
+}
+
+// Helper method: record that a payment has been declined. The buyer can still retry it with another payment
+// method while the hold lasts, so the bookings or the listing are kept until their hold expires
+func (server *Server) handlePaymentFailed(ctx *gin.Context, eventID, eventType string, pi *stripe.PaymentIntent) error {
+	return server.queries.ProcessWebhookEvent(ctx, eventID, eventType, func(tx *gorm.DB) error {
+		_, err := updatePayment(tx, pi.ID, db.PaymentFailed)
+		return err
+	})
+}
+
+// Helper function: update the status of the payment of an intent and return it. When a payment succeeds, its promo
+// code is counted as used. Since the use is only counted here, concurrent payments may go slightly over the limit.
+// The order owning the payment is paid along with it if it is still pending. A failed payment can be retried,
+// so the order is left as is. Return nil if the intent has no payment record
+func updatePayment(tx *gorm.DB, intentID string, status db.PaymentStatus) (*db.Payment, error) {
+	var record db.Payment
+	err := tx.Where("intent_id = ?", intentID).First(&record).Error
+	if err != nil {
+		// The intent was not created by this server, for example from the Stripe dashboard
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := tx.Model(&record).Update("status", status).Error; err != nil {
+		return nil, err
+	}
+
+	// A paid order whose bookings have been released stays released, its money must be refunded
+	if status == db.PaymentSucceeded {
+		err := tx.
+			Model(&db.Order{}).
+			Where("payment_id = ? AND status = ?", record.ID, db.OrderPending).
+			Update("status", db.OrderPaid).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	if status == db.PaymentSucceeded && record.PromoCodeID != nil {
+		err := tx.
+			Model(&db.PromoCode{}).
+			Where("id = ?", *record.PromoCodeID).
+			Update("used", gorm.Expr("used + 1")).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &record, nil
 }
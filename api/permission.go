@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+const (
+	// Event and ticket tier management. Organisers can only manage their own events,
+	// PermissionEventManageAll allows managing events of other hosts
+	PermissionEventCreate    Permission = "event:create"
+	PermissionEventUpdate    Permission = "event:update"
+	PermissionEventPublish   Permission = "event:publish"
+	PermissionEventCancel    Permission = "event:cancel"
+	PermissionEventManageAll Permission = "event:manage-all"
+	PermissionTicketManage   Permission = "ticket:manage"
+
+	// Booking
+	PermissionBookingCreate   Permission = "booking:create"
+	PermissionBookingCheckin  Permission = "booking:checkin"
+	PermissionBookingTransfer Permission = "booking:transfer"
+
+	// Refund
+	PermissionRefundRequest Permission = "refund:request"
+	PermissionRefundIssue   Permission = "refund:issue"
+
+	// Account management
+	PermissionAccountManage Permission = "account:manage"
+)
+
+// The permission matrix. Admin is not listed since admin can do everything
+var rolePermissions = map[db.Role][]Permission{
+	db.Organiser: {
+		PermissionEventCreate,
+		PermissionEventUpdate,
+		PermissionEventPublish,
+		PermissionEventCancel,
+		PermissionTicketManage,
+		PermissionBookingCreate,
+		PermissionBookingCheckin,
+		PermissionBookingTransfer,
+		PermissionRefundRequest,
+	},
+	db.SupportedStaff: {
+		PermissionBookingCreate,
+		PermissionBookingCheckin,
+		PermissionBookingTransfer,
+		PermissionRefundRequest,
+		PermissionRefundIssue,
+	},
+	db.User: {
+		PermissionBookingCreate,
+		PermissionBookingTransfer,
+		PermissionRefundRequest,
+	},
+}
+
+// Check if a role has a permission
+func HasPermission(role db.Role, permission Permission) bool {
+	if role == db.Admin {
+		return true
+	}
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// Check if the current user can manage an event: admin can manage all events, organiser only their own
+func canManageEvent(claims *security.CustomClaims, event db.Event) bool {
+	return HasPermission(claims.Role, PermissionEventManageAll) || event.HostID == claims.ID
+}
+
+// Middleware to only allow users with one of the roles. Must be used after AuthMiddleware
+func (server *Server) RequireRole(roles ...db.Role) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims := getClaims(ctx)
+
+		if claims.Role != db.Admin && !slices.Contains(roles, claims.Role) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{"permission denied"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Middleware to only allow users having all the permissions. Must be used after AuthMiddleware
+func (server *Server) RequirePermission(permissions ...Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims := getClaims(ctx)
+
+		for _, permission := range permissions {
+			if !HasPermission(claims.Role, permission) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{"permission denied"})
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/security"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasPermission(t *testing.T) {
+	// Admin can do everything
+	require.True(t, HasPermission(db.Admin, PermissionAccountManage))
+	require.True(t, HasPermission(db.Admin, PermissionEventManageAll))
+
+	// Organiser manages events, but not accounts or other hosts' events
+	require.True(t, HasPermission(db.Organiser, PermissionEventPublish))
+	require.False(t, HasPermission(db.Organiser, PermissionEventManageAll))
+	require.False(t, HasPermission(db.Organiser, PermissionAccountManage))
+
+	// Staff can check in, but cannot manage events
+	require.True(t, HasPermission(db.SupportedStaff, PermissionBookingCheckin))
+	require.False(t, HasPermission(db.SupportedStaff, PermissionEventCreate))
+
+	// User can only book, transfer and request refund
+	require.True(t, HasPermission(db.User, PermissionBookingCreate))
+	require.True(t, HasPermission(db.User, PermissionBookingTransfer))
+	require.False(t, HasPermission(db.User, PermissionBookingCheckin))
+	require.False(t, HasPermission(db.User, PermissionRefundIssue))
+}
+
+func TestCanManageEvent(t *testing.T) {
+	event := db.Event{HostID: 1}
+
+	require.True(t, canManageEvent(&security.CustomClaims{ID: 1, Role: db.Organiser}, event))
+	require.False(t, canManageEvent(&security.CustomClaims{ID: 2, Role: db.Organiser}, event))
+	require.True(t, canManageEvent(&security.CustomClaims{ID: 3, Role: db.Admin}, event))
+}
+
+// Helper function: run the middleware with the claims of the role, return the status code
+func runMiddleware(t *testing.T, middleware gin.HandlerFunc, role db.Role) int {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.Set(claimsKey, &security.CustomClaims{ID: 1, Role: role})
+		ctx.Next()
+	}, middleware, func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder.Code
+}
+
+func TestRequireRole(t *testing.T) {
+	server := &Server{}
+	middleware := server.RequireRole(db.Organiser)
+
+	require.Equal(t, http.StatusOK, runMiddleware(t, middleware, db.Organiser))
+	require.Equal(t, http.StatusOK, runMiddleware(t, middleware, db.Admin))
+	require.Equal(t, http.StatusForbidden, runMiddleware(t, middleware, db.User))
+}
+
+func TestRequirePermission(t *testing.T) {
+	server := &Server{}
+	middleware := server.RequirePermission(PermissionBookingCheckin)
+
+	require.Equal(t, http.StatusOK, runMiddleware(t, middleware, db.SupportedStaff))
+	require.Equal(t, http.StatusOK, runMiddleware(t, middleware, db.Admin))
+	require.Equal(t, http.StatusForbidden, runMiddleware(t, middleware, db.User))
+}
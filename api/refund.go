@@ -0,0 +1,416 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/payment"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Audit actions
+const (
+	AuditRefundApprove = "refund:approve"
+	AuditRefundReject  = "refund:reject"
+)
+
+type RefundResponse struct {
+	ID          uint            `json:"id"`
+	BookingID   uint            `json:"booking_id"`
+	RequesterID uint            `json:"requester_id"`
+	Reason      string          `json:"reason"`
+	Amount      int64           `json:"amount"`
+	Status      db.RefundStatus `json:"status"`
+	ReviewerID  *uint           `json:"reviewer_id,omitempty"`
+	ReviewNote  string          `json:"review_note,omitempty"`
+	ProviderID  string          `json:"provider_id,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ReviewedAt  *time.Time      `json:"reviewed_at,omitempty"`
+	RefundedAt  *time.Time      `json:"refunded_at,omitempty"`
+}
+
+func NewRefundResponse(request db.RefundRequest) RefundResponse {
+	resp := RefundResponse{
+		ID:          request.ID,
+		BookingID:   request.BookingID,
+		RequesterID: request.RequesterID,
+		Reason:      request.Reason,
+		Amount:      request.Amount,
+		Status:      request.Status,
+		ReviewerID:  request.ReviewerID,
+		ReviewNote:  request.ReviewNote,
+		ProviderID:  request.ProviderID,
+		CreatedAt:   request.CreatedAt,
+	}
+	if request.ReviewedAt.Valid {
+		resp.ReviewedAt = &request.ReviewedAt.Time
+	}
+	if request.RefundedAt.Valid {
+		resp.RefundedAt = &request.RefundedAt.Time
+	}
+	return resp
+}
+
+type RequestRefundRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// Request a refund of a paid booking of the current user. The amount is computed by the refund policy
+// from how long before the event the request is made, and the request waits for staff approval
+func (server *Server) RequestRefund(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req RequestRefundRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/bookings/:id/refund: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid booking ID"})
+		return
+	}
+
+	var request db.RefundRequest
+	err = server.queries.DB.Transaction(func(tx *gorm.DB) error {
+		var booking db.Booking
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Ticket.Event").
+			Where("account_id = ?", claims.ID).
+			First(&booking, id).Error
+		if err != nil {
+			return err
+		}
+		if booking.Status != db.Valid {
+			return ConflictError{"only paid and unused booking can be refunded"}
+		}
+
+		// The payment belongs to the first owner, the recipient has paid them outside of the platform
+		var transferred int64
+		result := tx.
+			Model(&db.TicketTransfer{}).
+			Where("booking_id = ? AND status = ?", booking.ID, db.TransferAccepted).
+			Count(&transferred)
+		if result.Error != nil {
+			return result.Error
+		}
+		if transferred > 0 {
+			return ConflictError{"transferred booking cannot be refunded"}
+		}
+
+		// A resold booking has been paid to its seller, and a listed one may be sold at any time
+		var listings int64
+		result = tx.
+			Model(&db.ResaleListing{}).
+			Where("booking_id = ? AND status IN ?", booking.ID,
+				[]db.ListingStatus{db.ListingActive, db.ListingReserved, db.ListingSold}).
+			Count(&listings)
+		if result.Error != nil {
+			return result.Error
+		}
+		if listings > 0 {
+			return ConflictError{"resold or listed booking cannot be refunded"}
+		}
+
+		// Only one open request for each booking
+		var open int64
+		result = tx.
+			Model(&db.RefundRequest{}).
+			Where("booking_id = ? AND status = ?", booking.ID, db.RefundRequested).
+			Count(&open)
+		if result.Error != nil {
+			return result.Error
+		}
+		if open > 0 {
+			return ConflictError{"a refund has already been requested for this booking"}
+		}
+
+		paid, _, err := db.BookingPaidAmount(tx, booking)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ConflictError{"booking has no payment to refund"}
+			}
+			return err
+		}
+		amount := payment.DefaultRefundPolicy.Amount(paid, booking.Ticket.Event.StartTime, time.Now())
+		if amount <= 0 {
+			return ConflictError{"booking is no longer refundable under the refund policy"}
+		}
+
+		request = db.RefundRequest{
+			BookingID:   booking.ID,
+			RequesterID: claims.ID,
+			Reason:      req.Reason,
+			Amount:      amount,
+			Status:      db.RefundRequested,
+		}
+		return tx.Create(&request).Error
+	})
+	if err != nil {
+		server.writeError(ctx, "POST /api/bookings/:id/refund", "booking", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, NewRefundResponse(request))
+}
+
+type ListRefundsQuery struct {
+	PageQuery
+
+	Status db.RefundStatus `form:"status" binding:"omitempty,oneof=requested refunding rejected refunded"`
+}
+
+// List refund requests for staff to review, oldest first
+func (server *Server) ListRefunds(ctx *gin.Context) {
+	var query ListRefundsQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/refunds: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	tx := server.queries.DB.Model(&db.RefundRequest{})
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/refunds: failed to count refund requests", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var requests []db.RefundRequest
+	if err := tx.Order("id").Offset(offset).Limit(query.PageSize).Find(&requests).Error; err != nil {
+		server.logger.Error("GET /api/refunds: failed to list refund requests", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	items := make([]RefundResponse, len(requests))
+	for i, request := range requests {
+		items[i] = NewRefundResponse(request)
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[RefundResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
+
+type ReviewRefundRequest struct {
+	Note string `json:"note" binding:"max=500"`
+}
+
+// Approve a refund request: the booking is marked as refunded and its ticket is returned to the tier,
+// then the money is refunded through the payment provider and recorded in the ledger. The provider is called
+// after the approval has been committed, so a slow provider never holds the locks of the booking and its tier.
+// If the provider fails, the request stays refunding and approving it again retries the refund
+func (server *Server) ApproveRefund(ctx *gin.Context) {
+	var req ReviewRefundRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		server.logger.Warn("POST /api/refunds/:id/approve: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid refund ID"})
+		return
+	}
+	var request db.RefundRequest
+	if err := server.queries.DB.First(&request, id).Error; err != nil {
+		server.writeError(ctx, "POST /api/refunds/:id/approve", "refund request", err)
+		return
+	}
+
+	if request.Status != db.RefundRefunding {
+		var (
+			ticketID uint
+			ok       bool
+		)
+		request, ok = server.reviewRefund(ctx, "POST /api/refunds/:id/approve", AuditRefundApprove,
+			func(tx *gorm.DB, request *db.RefundRequest) error {
+				var booking db.Booking
+				err := tx.
+					Clauses(clause.Locking{Strength: "UPDATE"}).
+					Preload("Ticket.Event").
+					First(&booking, request.BookingID).Error
+				if err != nil {
+					return err
+				}
+				if booking.Status != db.Valid {
+					return ConflictError{"booking is no longer refundable"}
+				}
+
+				// The cancellation refunds the booking in full and closes this request
+				if booking.Ticket.Event.Status == db.Canceled {
+					return ConflictError{"event has been canceled, the booking is refunded in full by the cancellation"}
+				}
+
+				booking.Status = db.Refund
+				if err := tx.Save(&booking).Error; err != nil {
+					return err
+				}
+				if err := db.ReturnTickets(tx, booking.TicketID, 1); err != nil {
+					return err
+				}
+				if err := db.FreeSeats(tx, []uint{booking.ID}); err != nil {
+					return err
+				}
+				ticketID = booking.TicketID
+
+				request.Status = db.RefundRefunding
+				request.ReviewNote = req.Note
+				return nil
+			})
+		if !ok {
+			return
+		}
+
+		server.invalidateTicketStock(ctx, "POST /api/refunds/:id/approve", ticketID)
+		server.offerWaitlist(ctx, "POST /api/refunds/:id/approve", ticketID)
+	}
+
+	request, err = server.completeRefund(request)
+	if err != nil {
+		server.logger.Error("POST /api/refunds/:id/approve: failed to refund the payment", "id", request.ID, "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"refund has been approved but not paid out yet, approve it again to retry"})
+		return
+	}
+	ctx.JSON(http.StatusOK, NewRefundResponse(request))
+}
+
+// Helper method: refund an approved request through the payment provider and record it. The idempotency key
+// makes sure retries never refund twice, and only the first of concurrent retries writes the ledger entry
+func (server *Server) completeRefund(request db.RefundRequest) (db.RefundRequest, error) {
+	var booking db.Booking
+	if err := server.queries.DB.First(&booking, request.BookingID).Error; err != nil {
+		return request, err
+	}
+	_, record, err := db.BookingPaidAmount(server.queries.DB, booking)
+	if err != nil {
+		return request, err
+	}
+
+	refund, err := server.payment.CreateRefund(record.IntentID, payment.RequestedByCustomer, request.Amount,
+		fmt.Sprintf("refund-request:%d", request.ID))
+	if err != nil {
+		return request, err
+	}
+
+	err = server.queries.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Model(&request).
+			Clauses(clause.Returning{}).
+			Where("status = ?", db.RefundRefunding).
+			Updates(map[string]any{
+				"status":      db.RefundCompleted,
+				"provider_id": refund.ID,
+				"refunded_at": sql.NullTime{Time: time.Unix(refund.Created, 0), Valid: true},
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		return tx.Create(&db.LedgerEntry{
+			AccountID: booking.AccountID,
+			Type:      db.LedgerRefund,
+			Amount:    refund.Amount,
+			Currency:  string(refund.Currency),
+			PaymentID: &record.ID,
+			BookingID: &booking.ID,
+			Reference: refund.ID,
+		}).Error
+	})
+	if err != nil {
+		return request, err
+	}
+	if request.Status != db.RefundCompleted {
+		// Completed by a concurrent retry
+		err = server.queries.DB.First(&request, request.ID).Error
+	}
+	return request, err
+}
+
+// Reject a refund request. The note is required so the user knows why
+func (server *Server) RejectRefund(ctx *gin.Context) {
+	var req ReviewRefundRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil || req.Note == "" {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	request, ok := server.reviewRefund(ctx, "POST /api/refunds/:id/reject", AuditRefundReject,
+		func(tx *gorm.DB, request *db.RefundRequest) error {
+			request.Status = db.RefundRejected
+			request.ReviewNote = req.Note
+			return nil
+		})
+	if ok {
+		ctx.JSON(http.StatusOK, NewRefundResponse(request))
+	}
+}
+
+// Helper method: lock the open refund request in the path, apply the review and write the audit log
+// in a transaction. If failed, the response is written and false is returned
+func (server *Server) reviewRefund(
+	ctx *gin.Context,
+	route string,
+	action string,
+	review func(tx *gorm.DB, request *db.RefundRequest) error,
+) (db.RefundRequest, bool) {
+	claims := getClaims(ctx)
+
+	var request db.RefundRequest
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid refund ID"})
+		return request, false
+	}
+
+	err = server.queries.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, id).Error; err != nil {
+			return err
+		}
+		if request.Status != db.RefundRequested {
+			return ConflictError{"refund request has already been reviewed"}
+		}
+
+		if err := review(tx, &request); err != nil {
+			return err
+		}
+		request.ReviewerID = &claims.ID
+		request.ReviewedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		if err := tx.Save(&request).Error; err != nil {
+			return err
+		}
+
+		return server.writeAuditLog(tx, ctx, action, "refund", request.ID, map[string]any{
+			"booking_id": request.BookingID,
+			"amount":     request.Amount,
+			"note":       request.ReviewNote,
+		})
+	})
+	if err != nil {
+		server.writeError(ctx, route, "refund request", err)
+		return request, false
+	}
+
+	return request, true
+}
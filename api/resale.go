@@ -0,0 +1,322 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/payment"
+	"github.com/gin-gonic/gin"
+)
+
+type CreateListingRequest struct {
+	// Price asked from the buyer in cents, up to the resale cap of the event
+	Price int64 `json:"price" binding:"required,min=1"`
+}
+
+type ResaleListingResponse struct {
+	ID         uint             `json:"id"`
+	BookingID  uint             `json:"booking_id"`
+	TicketID   uint             `json:"ticket_id"`
+	Rank       string           `json:"rank"`
+	SeatNumber string           `json:"seat_number,omitempty"`
+	Price      int64            `json:"price"`
+	Currency   string           `json:"currency"`
+	Status     db.ListingStatus `json:"status"`
+	CreatedAt  time.Time        `json:"created_at"`
+	SoldAt     *time.Time       `json:"sold_at,omitempty"`
+}
+
+func NewResaleListingResponse(listing db.ResaleListing, now time.Time) ResaleListingResponse {
+	resp := ResaleListingResponse{
+		ID:         listing.ID,
+		BookingID:  listing.BookingID,
+		TicketID:   listing.Booking.TicketID,
+		Rank:       listing.Booking.Ticket.Rank,
+		SeatNumber: listing.Booking.SeatNumber,
+		Price:      listing.Price,
+		Currency:   listing.Currency,
+		Status:     listing.Status,
+		CreatedAt:  listing.CreatedAt,
+	}
+
+	// A hold that has expired no longer keeps others from buying
+	if listing.Status == db.ListingReserved && listing.Available(now) {
+		resp.Status = db.ListingActive
+	}
+	if listing.SoldAt.Valid {
+		resp.SoldAt = &listing.SoldAt.Time
+	}
+	return resp
+}
+
+// The seller also sees what they are paid for the sale
+type MyListingResponse struct {
+	ResaleListingResponse
+
+	Fee      int64 `json:"fee"`
+	Proceeds int64 `json:"proceeds"`
+}
+
+func NewMyListingResponse(listing db.ResaleListing, now time.Time) MyListingResponse {
+	return MyListingResponse{
+		ResaleListingResponse: NewResaleListingResponse(listing, now),
+		Fee:                   listing.Fee,
+		Proceeds:              listing.Price - listing.Fee,
+	}
+}
+
+type BuyListingResponse struct {
+	PaymentIntentResponse
+
+	Listing       ResaleListingResponse `json:"listing"`
+	HoldExpiresAt time.Time             `json:"hold_expires_at"`
+}
+
+// Put a paid booking of the current user on sale. The price cannot exceed the resale cap of the event,
+// and the platform keeps a fee from it once the ticket is sold
+func (server *Server) CreateListing(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req CreateListingRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/bookings/:id/resale: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+	if req.Price < payment.MinimumAmount {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"price is below the minimum payment amount"})
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid booking ID"})
+		return
+	}
+
+	fee := payment.ResaleFee(req.Price, server.config.ResaleFeePercent)
+	listing, err := server.queries.CreateListing(ctx, uint(id), claims.ID, req.Price, fee, payment.Currency,
+		server.config.TransferCutoff)
+	if err != nil {
+		server.writeResaleError(ctx, "POST /api/bookings/:id/resale", "booking", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, NewMyListingResponse(listing, time.Now()))
+}
+
+// Take a listing of the current user off sale
+func (server *Server) CancelListing(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid listing ID"})
+		return
+	}
+
+	listing, err := server.queries.CancelListing(ctx, uint(id), claims.ID)
+	if err != nil {
+		server.writeResaleError(ctx, "DELETE /api/resale/:id", "listing", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewMyListingResponse(listing, time.Now()))
+}
+
+// Hold a listing for the current user and start its payment. The ticket changes hands once the payment succeeds,
+// if it completes before the hold expires. Asking again while holding the listing returns the same payment
+func (server *Server) BuyListing(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid listing ID"})
+		return
+	}
+
+	listing, replaced, err := server.queries.ReserveListing(ctx, uint(id), claims.ID, server.config.BookingHoldDuration,
+		server.config.TransferCutoff)
+	if err != nil {
+		server.writeResaleError(ctx, "POST /api/resale/:id/buy", "listing", err)
+		return
+	}
+
+	// The buyer whose hold has expired can no longer pay for it
+	if replaced != nil {
+		server.cancelPayments(ctx, "POST /api/resale/:id/buy", *replaced)
+	}
+
+	// The intent is created once the hold is committed, so the listing is not locked while waiting for the provider.
+	// Each hold gets its own intent, so a buyer coming back after their hold expired pays anew
+	intent, err := server.payment.CreatePaymentIntent(listing.Price,
+		map[string]string{
+			payment.MetadataAccountID: strconv.FormatUint(uint64(claims.ID), 10),
+			payment.MetadataListingID: strconv.FormatUint(uint64(listing.ID), 10),
+		},
+		fmt.Sprintf("resale:%d:%d:%d", listing.ID, claims.ID, listing.HoldExpiresAt.Time.Unix()))
+	if err != nil {
+		server.logger.Error("POST /api/resale/:id/buy: failed to create payment intent", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	record := db.Payment{
+		AccountID: claims.ID,
+		IntentID:  intent.ID,
+		Amount:    listing.Price,
+		Currency:  listing.Currency,
+		Status:    db.PaymentPending,
+	}
+	if err := server.queries.SetListingPayment(ctx, &listing, &record); err != nil {
+		// The hold has been lost in the meantime, so nothing can be bought with the intent
+		if _, err := server.payment.CancelPaymentIntent(intent.ID); err != nil {
+			server.logger.Warn("POST /api/resale/:id/buy: failed to cancel payment intent", "error", err)
+		}
+		server.writeResaleError(ctx, "POST /api/resale/:id/buy", "listing", err)
+		return
+	}
+
+	resp := BuyListingResponse{
+		PaymentIntentResponse: PaymentIntentResponse{
+			SecretKey: intent.ClientSecret,
+			PaymentID: record.ID,
+			Amount:    record.Amount,
+			Currency:  record.Currency,
+		},
+	}
+	resp.Listing = NewResaleListingResponse(listing, time.Now())
+	resp.HoldExpiresAt = listing.HoldExpiresAt.Time
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// List the tickets of an event on sale by other fans, cheapest first
+func (server *Server) ListEventListings(ctx *gin.Context) {
+	var query PageQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/events/:id/resale: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid event ID"})
+		return
+	}
+
+	now := time.Now()
+	tx := server.queries.DB.
+		Model(&db.ResaleListing{}).
+		Scopes(db.AvailableListings(now)).
+		Joins("JOIN bookings ON bookings.id = resale_listings.booking_id").
+		Joins("JOIN tickets ON tickets.id = bookings.ticket_id").
+		Joins("JOIN events ON events.id = tickets.event_id").
+		Where("tickets.event_id = ? AND events.status = ?", id, db.Published)
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/events/:id/resale: failed to count listings", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var listings []db.ResaleListing
+	err = tx.
+		Preload("Booking.Ticket").
+		Order("resale_listings.price, resale_listings.id").
+		Offset(offset).
+		Limit(query.PageSize).
+		Find(&listings).Error
+	if err != nil {
+		server.logger.Error("GET /api/events/:id/resale: failed to list listings", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	items := make([]ResaleListingResponse, len(listings))
+	for i, listing := range listings {
+		items[i] = NewResaleListingResponse(listing, now)
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[ResaleListingResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
+
+type ListMyListingsQuery struct {
+	PageQuery
+
+	Status db.ListingStatus `form:"status" binding:"omitempty,oneof=active reserved sold canceled"`
+}
+
+// List the listings of the current user, newest first
+func (server *Server) ListMyListings(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var query ListMyListingsQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/me/listings: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	tx := server.queries.DB.Model(&db.ResaleListing{}).Where("seller_id = ?", claims.ID)
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/me/listings: failed to count listings", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var listings []db.ResaleListing
+	err := tx.
+		Preload("Booking.Ticket").
+		Order("id DESC").
+		Offset(offset).
+		Limit(query.PageSize).
+		Find(&listings).Error
+	if err != nil {
+		server.logger.Error("GET /api/me/listings: failed to list listings", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	now := time.Now()
+	items := make([]MyListingResponse, len(listings))
+	for i, listing := range listings {
+		items[i] = NewMyListingResponse(listing, now)
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[MyListingResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
+
+// Helper method: write the response of a failed resale query
+func (server *Server) writeResaleError(ctx *gin.Context, route, resource string, err error) {
+	var priceErr *db.PriceAboveCapError
+	switch {
+	case errors.As(err, &priceErr), errors.Is(err, db.ErrBuyOwnListing):
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{err.Error()})
+	case errors.Is(err, db.ErrResaleClosed), errors.Is(err, db.ErrBookingListed),
+		errors.Is(err, db.ErrListingUnavailable), errors.Is(err, db.ErrListingNotCancelable):
+		ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+	default:
+		server.writeTransferError(ctx, route, resource, err)
+	}
+}
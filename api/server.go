@@ -1,18 +1,24 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 
 	"github.com/danglnh07/ticket-system/db"
 	_ "github.com/danglnh07/ticket-system/docs"
 	"github.com/danglnh07/ticket-system/service/mail"
 	"github.com/danglnh07/ticket-system/service/notify"
+	"github.com/danglnh07/ticket-system/service/oauth"
+	"github.com/danglnh07/ticket-system/service/payment"
 	"github.com/danglnh07/ticket-system/service/security"
 	"github.com/danglnh07/ticket-system/service/worker"
 	"github.com/danglnh07/ticket-system/util"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"gorm.io/gorm"
 )
 
 // Server struct, holds the router, dependencies, system config and logger
@@ -28,6 +34,8 @@ type Server struct {
 	jwtService  *security.JWTService
 	distributor worker.TaskDistributor
 	hub         *notify.Hub
+	google      *oauth.GoogleOAuth
+	payment     payment.PaymentProvider
 
 	// Server's config and logger
 	config *util.Config
@@ -41,6 +49,8 @@ func NewServer(
 	jwtService *security.JWTService,
 	distributor worker.TaskDistributor,
 	hub *notify.Hub,
+	google *oauth.GoogleOAuth,
+	paymentProvider payment.PaymentProvider,
 	config *util.Config,
 	logger *slog.Logger,
 ) *Server {
@@ -51,6 +61,8 @@ func NewServer(
 		jwtService:  jwtService,
 		distributor: distributor,
 		hub:         hub,
+		google:      google,
+		payment:     paymentProvider,
 		config:      config,
 		logger:      logger,
 	}
@@ -63,11 +75,127 @@ func (server *Server) RegisterHandler() {
 	// API routes
 	api := server.router.Group("/api")
 	{
+		auth := api.Group("/auth")
+		{
+			auth.POST("/register", server.Register)
+			auth.POST("/login", server.Login)
+			auth.GET("/verify", server.VerifyEmail)
+			auth.POST("/refresh", server.Refresh)
+			auth.POST("/logout-all", server.AuthMiddleware(), server.LogoutAll)
+			auth.GET("/google/login", server.GoogleLogin)
+			auth.GET("/google/callback", server.GoogleCallback)
+			auth.POST("/telegram", server.TelegramLogin)
+			auth.POST("/forgot-password", server.ForgotPassword)
+			auth.POST("/reset-password", server.ResetPassword)
+		}
+
+		me := api.Group("/me", server.AuthMiddleware())
+		{
+			me.GET("/sessions", server.ListSessions)
+			me.DELETE("/sessions/:id", server.DeleteSession)
+			me.POST("/telegram", server.LinkTelegram)
+			me.PUT("/password", server.ChangePassword)
+			me.GET("/events", server.RequirePermission(PermissionEventCreate), server.ListMyEvents)
+			me.GET("/bookings/:id/qr", server.GetBookingQR)
+			me.GET("/orders", server.ListMyOrders)
+			me.GET("/waitlist", server.ListMyWaitlist)
+			me.GET("/transfers", server.ListMyTransfers)
+			me.GET("/listings", server.ListMyListings)
+			me.GET("/notifications", server.SubscribeNotifications)
+		}
+
+		admin := api.Group("/admin", server.AuthMiddleware(), server.RequirePermission(PermissionAccountManage))
+		{
+			admin.GET("/accounts", server.ListAccounts)
+			admin.POST("/accounts/:id/ban", server.BanAccount)
+			admin.POST("/accounts/:id/unban", server.UnbanAccount)
+			admin.PUT("/accounts/:id/role", server.ChangeRole)
+			admin.POST("/accounts/:id/verify", server.ForceVerifyAccount)
+			admin.GET("/audit-logs", server.ListAuditLogs)
+		}
+
+		bookings := api.Group("/bookings", server.AuthMiddleware())
+		{
+			bookings.POST("", server.RequirePermission(PermissionBookingCreate), server.CreateBooking)
+			bookings.DELETE("/:id", server.CancelBooking)
+			bookings.POST("/:id/refund", server.RequirePermission(PermissionRefundRequest), server.RequestRefund)
+			bookings.POST("/:id/transfer", server.RequirePermission(PermissionBookingTransfer), server.CreateTransfer)
+			bookings.POST("/:id/resale", server.RequirePermission(PermissionBookingTransfer), server.CreateListing)
+		}
+
+		transfers := api.Group("/transfers", server.AuthMiddleware())
+		{
+			transfers.POST("/:id/accept", server.RequirePermission(PermissionBookingTransfer), server.AcceptTransfer)
+			transfers.POST("/:id/decline", server.DeclineTransfer)
+			transfers.DELETE("/:id", server.CancelTransfer)
+		}
+
+		resale := api.Group("/resale", server.AuthMiddleware())
+		{
+			resale.POST("/:id/buy", server.RequirePermission(PermissionBookingCreate), server.BuyListing)
+			resale.DELETE("/:id", server.CancelListing)
+		}
+
+		orders := api.Group("/orders", server.AuthMiddleware())
+		{
+			orders.POST("", server.RequirePermission(PermissionBookingCreate), server.CreateOrder)
+			orders.DELETE("/:id", server.CancelOrder)
+		}
+
+		waitlist := api.Group("/waitlist", server.AuthMiddleware())
+		{
+			waitlist.POST("", server.RequirePermission(PermissionBookingCreate), server.JoinWaitlist)
+			waitlist.DELETE("/:id", server.LeaveWaitlist)
+			waitlist.POST("/:id/claim", server.RequirePermission(PermissionBookingCreate), server.ClaimWaitlistOffer)
+		}
+
+		api.POST("/checkin", server.AuthMiddleware(), server.RequirePermission(PermissionBookingCheckin), server.CheckIn)
+
+		refunds := api.Group("/refunds", server.AuthMiddleware(), server.RequirePermission(PermissionRefundIssue))
+		{
+			refunds.GET("", server.ListRefunds)
+			refunds.POST("/:id/approve", server.ApproveRefund)
+			refunds.POST("/:id/reject", server.RejectRefund)
+		}
+
+		events := api.Group("/events")
+		{
+			events.GET("", server.ListEvents)
+			events.GET("/:id", server.GetEvent)
+			events.GET("/:id/seats", server.ListEventSeats)
+			events.GET("/:id/resale", server.ListEventListings)
+
+			manage := events.Group("", server.AuthMiddleware())
+			{
+				manage.POST("", server.RequirePermission(PermissionEventCreate), server.CreateEvent)
+				manage.PUT("/:id", server.RequirePermission(PermissionEventUpdate), server.UpdateEvent)
+				manage.DELETE("/:id", server.RequirePermission(PermissionEventUpdate), server.DeleteEvent)
+				manage.POST("/:id/publish", server.RequirePermission(PermissionEventPublish), server.PublishEvent)
+				manage.POST("/:id/cancel", server.RequirePermission(PermissionEventCancel), server.CancelEvent)
+				manage.GET("/:id/cancellation", server.GetEventCancellation)
+				manage.POST("/:id/cancellation/resume", server.RequirePermission(PermissionEventCancel), server.ResumeEventCancellation)
+
+				manage.GET("/:id/staff", server.ListEventStaff)
+				manage.POST("/:id/staff", server.RequirePermission(PermissionEventUpdate), server.AssignEventStaff)
+				manage.DELETE("/:id/staff/:account_id", server.RequirePermission(PermissionEventUpdate), server.RemoveEventStaff)
+
+				manage.PUT("/:id/seats", server.RequirePermission(PermissionTicketManage), server.AssignEventSeats)
+				manage.POST("/:id/tickets", server.RequirePermission(PermissionTicketManage), server.AddTicketTier)
+				manage.PUT("/:id/tickets/:ticket_id", server.RequirePermission(PermissionTicketManage), server.UpdateTicketTier)
+				manage.DELETE("/:id/tickets/:ticket_id", server.RequirePermission(PermissionTicketManage), server.RetireTicketTier)
+			}
+		}
+
+		venues := api.Group("/venues")
+		{
+			venues.GET("/:id", server.GetVenue)
+			venues.POST("", server.AuthMiddleware(), server.RequirePermission(PermissionEventCreate), server.CreateVenue)
+		}
+
 		payment := api.Group("/payment")
 		{
 			payment.GET("/config", server.StripeConfig)
-			payment.POST("/intent", server.CreatePaymentIntent)
-			payment.POST("/refund", server.Refund)
+			payment.POST("/intent", server.AuthMiddleware(), server.CreatePaymentIntent)
 		}
 	}
 
@@ -88,3 +216,50 @@ func (server *Server) Start() error {
 type ErrorResponse struct {
 	Message string `json:"error"`
 }
+
+// Generic message response struct
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// Error of a request that conflicts with the current state of a record. It is returned to client as 409 Conflict
+type ConflictError struct {
+	Message string
+}
+
+func (err ConflictError) Error() string {
+	return err.Message
+}
+
+// Returned from a transaction when a nested resource is not found, so the response names it
+// instead of the main resource of the route
+type NotFoundError struct {
+	Resource string
+}
+
+func (err NotFoundError) Error() string {
+	return err.Resource + " not found"
+}
+
+var errPermissionDenied = errors.New("permission denied")
+
+// Helper method: write the error returned from a transaction to the response.
+// Conflict and permission errors are returned as is, record not found is returned as 404 with the resource name,
+// and any other error is logged and hidden behind 500
+func (server *Server) writeError(ctx *gin.Context, route, resource string, err error) {
+	var conflict ConflictError
+	var notFound NotFoundError
+	switch {
+	case errors.As(err, &conflict):
+		ctx.JSON(http.StatusConflict, ErrorResponse{conflict.Message})
+	case errors.As(err, &notFound):
+		ctx.JSON(http.StatusNotFound, ErrorResponse{notFound.Error()})
+	case errors.Is(err, errPermissionDenied):
+		ctx.JSON(http.StatusForbidden, ErrorResponse{errPermissionDenied.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, ErrorResponse{fmt.Sprintf("%s not found", resource)})
+	default:
+		server.logger.Error(route+": failed to process request", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+	}
+}
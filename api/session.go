@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper method: revoke all tokens and sessions of an account.
+// The token version is bumped first, so even if we fail to clean up the sessions, no old token can be used
+func (server *Server) revokeAllSessions(ctx context.Context, accountID uint) error {
+	if _, err := server.queries.BumpTokenVersion(ctx, accountID); err != nil {
+		return err
+	}
+
+	return server.queries.DeleteAllSessions(ctx, accountID)
+}
+
+func (server *Server) LogoutAll(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	if err := server.revokeAllSessions(ctx, claims.ID); err != nil {
+		server.logger.Error("POST /api/auth/logout-all: failed to revoke sessions", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, MessageResponse{"logged out from all devices"})
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	DeviceName string    `json:"device_name"`
+	IP         string    `json:"ip"`
+	LastSeen   time.Time `json:"last_seen"`
+	IssuedAt   time.Time `json:"issued_at"`
+}
+
+func (server *Server) ListSessions(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	sessions, err := server.queries.ListSessions(ctx, claims.ID)
+	if err != nil {
+		server.logger.Error("GET /api/me/sessions: failed to list sessions", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	resp := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		resp[i] = SessionResponse{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			IP:         session.IP,
+			LastSeen:   session.LastSeen,
+			IssuedAt:   session.IssuedAt,
+		}
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// Revoke a single session. The refresh token of the session is deleted so it can no longer be refreshed,
+// and access tokens already issued in this session are rejected from now on
+func (server *Server) DeleteSession(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	err := server.queries.DeleteSession(ctx, claims.ID, ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, db.ErrSessionNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{"session not found"})
+			return
+		}
+		server.logger.Error("DELETE /api/me/sessions/:id: failed to delete session", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, MessageResponse{"session revoked"})
+}
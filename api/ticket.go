@@ -0,0 +1,203 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Add a ticket tier to an event. The tier follows the event status, so a tier added to a published event
+// is on sale immediately
+func (server *Server) AddTicketTier(ctx *gin.Context) {
+	var req TicketTierRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/events/:id/tickets: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	var ticket db.Ticket
+	_, ok := server.mutateEvent(ctx, "POST /api/events/:id/tickets", func(tx *gorm.DB, event *db.Event) error {
+		if event.Status == db.Canceled {
+			return ConflictError{"cannot add ticket tier to a canceled event"}
+		}
+		if err := checkDuplicateRank(tx, event.ID, 0, req.Rank); err != nil {
+			return err
+		}
+
+		ticket = db.Ticket{
+			EventID:   event.ID,
+			Rank:      req.Rank,
+			Total:     req.Total,
+			Available: req.Total,
+			Price:     req.Price,
+			Status:    event.Status,
+		}
+		return tx.Create(&ticket).Error
+	})
+	if ok {
+		ctx.JSON(http.StatusCreated, NewTicketResponse(ticket))
+	}
+}
+
+type UpdateTicketTierRequest struct {
+	Rank  *string  `json:"rank" binding:"omitempty,min=1,max=64"`
+	Total *uint    `json:"total" binding:"omitempty,min=1"`
+	Price *float64 `json:"price" binding:"omitempty,min=0"`
+
+	// Changing the price after tickets have been sold must be confirmed explicitly,
+	// since buyers have paid a different price for the same tier
+	Force bool `json:"force"`
+}
+
+// Resize, reprice or rename a ticket tier. The total cannot be less than the number of sold tickets
+func (server *Server) UpdateTicketTier(ctx *gin.Context) {
+	var req UpdateTicketTierRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("PUT /api/events/:id/tickets/:ticket_id: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	ticket, ok := server.mutateTicket(ctx, "PUT /api/events/:id/tickets/:ticket_id", func(tx *gorm.DB, event *db.Event, ticket *db.Ticket) error {
+		if ticket.Status == db.Canceled {
+			return ConflictError{"retired ticket tier cannot be edited"}
+		}
+
+		if req.Rank != nil && *req.Rank != ticket.Rank {
+			if err := checkDuplicateRank(tx, event.ID, ticket.ID, *req.Rank); err != nil {
+				return err
+			}
+			ticket.Rank = *req.Rank
+		}
+		if req.Total != nil && *req.Total != ticket.Total {
+			// The total of a seated tier is the number of its seats, it can only change with the seat map
+			var seats int64
+			if err := tx.Model(&db.EventSeat{}).Where("ticket_id = ?", ticket.ID).Count(&seats).Error; err != nil {
+				return err
+			}
+			if seats > 0 {
+				return ConflictError{"total of a seated tier follows its seat map"}
+			}
+
+			if err := ticket.Resize(*req.Total); err != nil {
+				if errors.Is(err, db.ErrTierBelowSold) {
+					return ConflictError{err.Error()}
+				}
+				return err
+			}
+		}
+		if req.Price != nil && *req.Price != ticket.Price {
+			if ticket.Sold() > 0 && !req.Force {
+				return ConflictError{"tickets of this tier have been sold, set force to change the price"}
+			}
+			ticket.Price = *req.Price
+		}
+
+		return tx.Save(ticket).Error
+	})
+	if ok {
+		server.invalidateTicketStock(ctx, "PUT /api/events/:id/tickets/:ticket_id", ticket.ID)
+		if req.Total != nil {
+			server.offerWaitlist(ctx, "PUT /api/events/:id/tickets/:ticket_id", ticket.ID)
+		}
+		ctx.JSON(http.StatusOK, NewTicketResponse(ticket))
+	}
+}
+
+// Retire a ticket tier. A draft tier without sales is deleted, otherwise it is canceled so it stops selling
+// while the sold tickets stay valid
+func (server *Server) RetireTicketTier(ctx *gin.Context) {
+	ticket, ok := server.mutateTicket(ctx, "DELETE /api/events/:id/tickets/:ticket_id", func(tx *gorm.DB, event *db.Event, ticket *db.Ticket) error {
+		if ticket.Status == db.Canceled {
+			return ConflictError{"ticket tier has already been retired"}
+		}
+		if event.Status == db.Draft && ticket.Sold() == 0 {
+			return tx.Delete(ticket).Error
+		}
+
+		// A published event must keep something to sell
+		if event.Status == db.Published {
+			var tiers int64
+			result := tx.
+				Model(&db.Ticket{}).
+				Where("event_id = ? AND status <> ? AND id <> ?", event.ID, db.Canceled, ticket.ID).
+				Count(&tiers)
+			if result.Error != nil {
+				return result.Error
+			}
+			if tiers == 0 {
+				return ConflictError{"cannot retire the last ticket tier of a published event, cancel the event instead"}
+			}
+		}
+
+		ticket.Status = db.Canceled
+		return tx.Save(ticket).Error
+	})
+	if ok {
+		server.invalidateTicketStock(ctx, "DELETE /api/events/:id/tickets/:ticket_id", ticket.ID)
+		ctx.JSON(http.StatusOK, NewTicketResponse(ticket))
+	}
+}
+
+// Helper function: check that no other active tier of the event has the same rank
+func checkDuplicateRank(tx *gorm.DB, eventID, ticketID uint, rank string) error {
+	var count int64
+	result := tx.
+		Model(&db.Ticket{}).
+		Where("event_id = ? AND id <> ? AND status <> ? AND rank = ?", eventID, ticketID, db.Canceled, rank).
+		Count(&count)
+	if result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return ConflictError{"ticket tier with this rank already exists"}
+	}
+	return nil
+}
+
+// Helper method: drop the cached stock of a tier after its total has changed, so bookings see the new stock
+func (server *Server) invalidateTicketStock(ctx *gin.Context, route string, ticketID uint) {
+	if err := server.queries.InvalidateTicketStock(ctx, ticketID); err != nil {
+		server.logger.Warn(route+": failed to invalidate ticket stock", "error", err)
+	}
+}
+
+// Helper method: lock the event and the ticket tier in the path, then apply the mutation in a transaction.
+// If failed, the response is written and false is returned
+func (server *Server) mutateTicket(
+	ctx *gin.Context,
+	route string,
+	mutate func(tx *gorm.DB, event *db.Event, ticket *db.Ticket) error,
+) (db.Ticket, bool) {
+	var ticket db.Ticket
+	ticketID, err := strconv.ParseUint(ctx.Param("ticket_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid ticket ID"})
+		return ticket, false
+	}
+
+	_, ok := server.mutateEvent(ctx, route, func(tx *gorm.DB, event *db.Event) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("event_id = ?", event.ID).
+			First(&ticket, ticketID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return NotFoundError{"ticket tier"}
+			}
+			return err
+		}
+		if event.Status == db.Canceled {
+			return ConflictError{"tickets of a canceled event cannot be changed"}
+		}
+
+		return mutate(tx, event, &ticket)
+	})
+	return ticket, ok
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/worker"
+	"github.com/gin-gonic/gin"
+)
+
+// Response of a new transfer, the same whether or not the recipient exists
+const transferOfferedMessage = "the ticket has been offered to the recipient, if they have an active account"
+
+type CreateTransferRequest struct {
+	// Username or email of the recipient
+	Recipient string `json:"recipient" binding:"required,max=255"`
+}
+
+type TransferResponse struct {
+	ID          uint              `json:"id"`
+	BookingID   uint              `json:"booking_id"`
+	SenderID    uint              `json:"sender_id"`
+	Sender      string            `json:"sender"`
+	RecipientID uint              `json:"recipient_id"`
+	Recipient   string            `json:"recipient"`
+	Status      db.TransferStatus `json:"status"`
+	CreatedAt   time.Time         `json:"created_at"`
+	RespondedAt *time.Time        `json:"responded_at,omitempty"`
+}
+
+func NewTransferResponse(transfer db.TicketTransfer) TransferResponse {
+	resp := TransferResponse{
+		ID:          transfer.ID,
+		BookingID:   transfer.BookingID,
+		SenderID:    transfer.SenderID,
+		Sender:      transfer.Sender.Username,
+		RecipientID: transfer.RecipientID,
+		Recipient:   transfer.Recipient.Username,
+		Status:      transfer.Status,
+		CreatedAt:   transfer.CreatedAt,
+	}
+	if transfer.RespondedAt.Valid {
+		resp.RespondedAt = &transfer.RespondedAt.Time
+	}
+	return resp
+}
+
+// Offer a paid booking of the current user to another account. The booking moves once the recipient accepts.
+// The response is the same whether or not the recipient has an active account, so accounts cannot be found out
+// by offering them tickets. The transfer can be followed with GET /api/me/transfers
+func (server *Server) CreateTransfer(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req CreateTransferRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/bookings/:id/transfer: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid booking ID"})
+		return
+	}
+
+	var recipient db.Account
+	result := server.queries.DB.
+		Where("username = ? OR email = ?", req.Recipient, req.Recipient).
+		Limit(1).
+		Find(&recipient)
+	if result.Error != nil {
+		server.logger.Error("POST /api/bookings/:id/transfer: failed to find recipient", "error", result.Error)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	// Nobody can accept the ticket, but the booking is still checked so the errors are the same
+	if result.RowsAffected == 0 || recipient.Status != db.Active {
+		err := server.queries.CheckTransferable(ctx, uint(id), claims.ID, server.config.TransferCutoff)
+		if err != nil {
+			server.writeTransferError(ctx, "POST /api/bookings/:id/transfer", "booking", err)
+			return
+		}
+		ctx.JSON(http.StatusAccepted, MessageResponse{transferOfferedMessage})
+		return
+	}
+
+	transfer, err := server.queries.CreateTransfer(ctx, uint(id), claims.ID, recipient.ID, server.config.TransferCutoff)
+	if err != nil {
+		server.writeTransferError(ctx, "POST /api/bookings/:id/transfer", "booking", err)
+		return
+	}
+
+	ticket := transfer.Booking.Ticket
+	server.notifyTransfer(ctx, "POST /api/bookings/:id/transfer", transfer.RecipientID, "Ticket transfer",
+		fmt.Sprintf("%s wants to send you a %s ticket for %s", transfer.Sender.Username, ticket.Rank, ticket.Event.Name))
+	ctx.JSON(http.StatusAccepted, MessageResponse{transferOfferedMessage})
+}
+
+// Accept a transfer sent to the current user. The booking becomes theirs with a new QR code,
+// and the code held by the sender stops working
+func (server *Server) AcceptTransfer(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid transfer ID"})
+		return
+	}
+
+	transfer, err := server.queries.AcceptTransfer(ctx, uint(id), claims.ID, server.config.TransferCutoff)
+	if err != nil {
+		server.writeTransferError(ctx, "POST /api/transfers/:id/accept", "transfer", err)
+		return
+	}
+
+	event := transfer.Booking.Ticket.Event
+	server.notifyTransfer(ctx, "POST /api/transfers/:id/accept", transfer.SenderID, "Ticket transferred",
+		fmt.Sprintf("%s has accepted your ticket for %s, your QR code for it is no longer valid",
+			transfer.Recipient.Username, event.Name))
+	server.notifyTransfer(ctx, "POST /api/transfers/:id/accept", transfer.RecipientID, "Ticket received",
+		fmt.Sprintf("Your ticket for %s from %s is ready", event.Name, transfer.Sender.Username))
+	ctx.JSON(http.StatusOK, NewTransferResponse(transfer))
+}
+
+// Decline a transfer sent to the current user. The booking stays with the sender
+func (server *Server) DeclineTransfer(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid transfer ID"})
+		return
+	}
+
+	transfer, err := server.queries.DeclineTransfer(ctx, uint(id), claims.ID)
+	if err != nil {
+		server.writeTransferError(ctx, "POST /api/transfers/:id/decline", "transfer", err)
+		return
+	}
+
+	server.notifyTransfer(ctx, "POST /api/transfers/:id/decline", transfer.SenderID, "Ticket transfer declined",
+		fmt.Sprintf("%s has declined your ticket for %s", transfer.Recipient.Username, transfer.Booking.Ticket.Event.Name))
+	ctx.JSON(http.StatusOK, NewTransferResponse(transfer))
+}
+
+// Cancel a transfer sent by the current user before the recipient accepts it
+func (server *Server) CancelTransfer(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid transfer ID"})
+		return
+	}
+
+	transfer, err := server.queries.CancelTransfer(ctx, uint(id), claims.ID)
+	if err != nil {
+		server.writeTransferError(ctx, "DELETE /api/transfers/:id", "transfer", err)
+		return
+	}
+
+	server.notifyTransfer(ctx, "DELETE /api/transfers/:id", transfer.RecipientID, "Ticket transfer canceled",
+		fmt.Sprintf("%s has canceled the ticket for %s they sent you", transfer.Sender.Username, transfer.Booking.Ticket.Event.Name))
+	ctx.JSON(http.StatusOK, NewTransferResponse(transfer))
+}
+
+type ListMyTransfersQuery struct {
+	PageQuery
+
+	// Transfers sent to or by the current user, both if empty
+	Direction string            `form:"direction" binding:"omitempty,oneof=incoming outgoing"`
+	Status    db.TransferStatus `form:"status" binding:"omitempty,oneof=pending accepted declined canceled"`
+}
+
+// List the transfers sent to or by the current user, newest first
+func (server *Server) ListMyTransfers(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var query ListMyTransfersQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/me/transfers: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	tx := server.queries.DB.Model(&db.TicketTransfer{})
+	switch query.Direction {
+	case "incoming":
+		tx = tx.Where("recipient_id = ?", claims.ID)
+	case "outgoing":
+		tx = tx.Where("sender_id = ?", claims.ID)
+	default:
+		tx = tx.Where("recipient_id = ? OR sender_id = ?", claims.ID, claims.ID)
+	}
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/me/transfers: failed to count transfers", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var transfers []db.TicketTransfer
+	err := tx.
+		Preload("Sender").
+		Preload("Recipient").
+		Order("id DESC").
+		Offset(offset).
+		Limit(query.PageSize).
+		Find(&transfers).Error
+	if err != nil {
+		server.logger.Error("GET /api/me/transfers: failed to list transfers", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	items := make([]TransferResponse, len(transfers))
+	for i, transfer := range transfers {
+		items[i] = NewTransferResponse(transfer)
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[TransferResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
+
+// Helper method: write the response of a failed transfer query
+func (server *Server) writeTransferError(ctx *gin.Context, route, resource string, err error) {
+	switch {
+	case errors.Is(err, db.ErrTransferToSelf):
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{err.Error()})
+	case errors.Is(err, db.ErrTransferClosed), errors.Is(err, db.ErrTransferPending),
+		errors.Is(err, db.ErrTransferNotPending), errors.Is(err, db.ErrBookingNotValid),
+		errors.Is(err, db.ErrRefundRequested), errors.Is(err, db.ErrBookingListed):
+		ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+	default:
+		server.writeError(ctx, route, resource, err)
+	}
+}
+
+// Helper method: notify a party of a transfer. The transfer has already been done, so a failure is only logged
+func (server *Server) notifyTransfer(ctx *gin.Context, route string, receiverID uint, title, content string) {
+	err := server.distributor.DistributeTask(ctx, worker.SendNotification, worker.SendNotificationPayload{
+		ReceiverID: receiverID,
+		Title:      title,
+		Content:    content,
+	})
+	if err != nil {
+		server.logger.Warn(route+": failed to send notification", "error", err)
+	}
+}
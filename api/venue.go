@@ -0,0 +1,318 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Layout of a venue to import. Seats of a row are numbered from 1
+type VenueLayoutRequest struct {
+	Name     string          `json:"name" binding:"required,max=200"`
+	Address  string          `json:"address" binding:"required,max=500"`
+	Sections []SectionLayout `json:"sections" binding:"required,min=1,max=50,dive"`
+}
+
+type SectionLayout struct {
+	Name     string      `json:"name" binding:"required,max=64"`
+	Priority int         `json:"priority"`
+	Rows     []RowLayout `json:"rows" binding:"required,min=1,max=200,dive"`
+}
+
+type RowLayout struct {
+	Label string `json:"label" binding:"required,max=8"`
+	Seats int    `json:"seats" binding:"required,min=1,max=500"`
+}
+
+type SeatResponse struct {
+	ID     uint   `json:"id"`
+	Row    string `json:"row"`
+	Number int    `json:"number"`
+	Label  string `json:"label"`
+}
+
+type SectionResponse struct {
+	ID       uint           `json:"id"`
+	Name     string         `json:"name"`
+	Priority int            `json:"priority"`
+	Seats    []SeatResponse `json:"seats"`
+}
+
+type VenueResponse struct {
+	ID        uint              `json:"id"`
+	CreatorID uint              `json:"creator_id"`
+	Name      string            `json:"name"`
+	Address   string            `json:"address"`
+	Sections  []SectionResponse `json:"sections"`
+}
+
+func NewVenueResponse(venue db.Venue) VenueResponse {
+	resp := VenueResponse{
+		ID:        venue.ID,
+		CreatorID: venue.CreatorID,
+		Name:      venue.Name,
+		Address:   venue.Address,
+		Sections:  make([]SectionResponse, len(venue.Sections)),
+	}
+	for i, section := range venue.Sections {
+		seats := make([]SeatResponse, len(section.Seats))
+		for j, seat := range section.Seats {
+			seats[j] = SeatResponse{ID: seat.ID, Row: seat.Row, Number: seat.Number, Label: seat.Label}
+		}
+		resp.Sections[i] = SectionResponse{
+			ID:       section.ID,
+			Name:     section.Name,
+			Priority: section.Priority,
+			Seats:    seats,
+		}
+	}
+	return resp
+}
+
+// Import a venue layout, which can then be used as the seat map of events held there
+func (server *Server) CreateVenue(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req VenueLayoutRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/venues: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	venue := db.Venue{
+		CreatorID: claims.ID,
+		Name:      req.Name,
+		Address:   req.Address,
+		Sections:  make([]db.Section, len(req.Sections)),
+	}
+	names := map[string]bool{}
+	for i, section := range req.Sections {
+		if names[section.Name] {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{fmt.Sprintf("duplicate section %q", section.Name)})
+			return
+		}
+		names[section.Name] = true
+
+		rows := map[string]bool{}
+		venue.Sections[i] = db.Section{Name: section.Name, Priority: section.Priority}
+		for rowIndex, row := range section.Rows {
+			if rows[row.Label] {
+				ctx.JSON(http.StatusBadRequest, ErrorResponse{fmt.Sprintf("duplicate row %q in section %q", row.Label, section.Name)})
+				return
+			}
+			rows[row.Label] = true
+
+			for number := 1; number <= row.Seats; number++ {
+				venue.Sections[i].Seats = append(venue.Sections[i].Seats, db.Seat{
+					Row:      row.Label,
+					RowIndex: rowIndex,
+					Number:   number,
+					Label:    fmt.Sprintf("%s %s%d", section.Name, row.Label, number),
+				})
+			}
+		}
+	}
+
+	// Sections and seats are created with the venue, in the same transaction
+	if err := server.queries.DB.Session(&gorm.Session{CreateBatchSize: 500}).Create(&venue).Error; err != nil {
+		server.logger.Error("POST /api/venues: failed to create venue", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, NewVenueResponse(venue))
+}
+
+// Get a venue layout with all of its seats
+func (server *Server) GetVenue(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid venue ID"})
+		return
+	}
+
+	var venue db.Venue
+	err = server.queries.DB.
+		Preload("Sections", func(tx *gorm.DB) *gorm.DB { return tx.Order("priority, id") }).
+		Preload("Sections.Seats", func(tx *gorm.DB) *gorm.DB { return tx.Order("row_index, number") }).
+		First(&venue, id).Error
+	if err != nil {
+		server.writeError(ctx, "GET /api/venues/:id", "venue", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewVenueResponse(venue))
+}
+
+type AssignSeatsRequest struct {
+	VenueID  uint                `json:"venue_id" binding:"required"`
+	Sections []SectionAssignment `json:"sections" binding:"required,min=1,dive"`
+}
+
+// Put all seats of a section on sale under a ticket tier
+type SectionAssignment struct {
+	SectionID uint `json:"section_id" binding:"required"`
+	TicketID  uint `json:"ticket_id" binding:"required"`
+}
+
+// Set the seat map of a draft event, replacing the previous one. The total of each seated tier becomes
+// the number of its seats, and every tier seated by the previous map must be seated by the new one
+func (server *Server) AssignEventSeats(ctx *gin.Context) {
+	var req AssignSeatsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("PUT /api/events/:id/seats: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	counts := map[uint]uint{}
+	event, ok := server.mutateEvent(ctx, "PUT /api/events/:id/seats", func(tx *gorm.DB, event *db.Event) error {
+		// Seats may already be held once tickets are on sale
+		if event.Status != db.Draft {
+			return ConflictError{"seat map can only be changed before the event is published"}
+		}
+
+		var venue db.Venue
+		if err := tx.Preload("Sections.Seats").First(&venue, req.VenueID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return NotFoundError{"venue"}
+			}
+			return err
+		}
+		sections := map[uint]db.Section{}
+		for _, section := range venue.Sections {
+			sections[section.ID] = section
+		}
+
+		var seats []db.EventSeat
+		assigned := map[uint]bool{}
+		for _, assignment := range req.Sections {
+			section, ok := sections[assignment.SectionID]
+			if !ok {
+				return NotFoundError{"section"}
+			}
+			if assigned[section.ID] {
+				return ConflictError{fmt.Sprintf("section %s is assigned more than once", section.Name)}
+			}
+			assigned[section.ID] = true
+
+			for _, seat := range section.Seats {
+				seats = append(seats, db.EventSeat{EventID: event.ID, SeatID: seat.ID, TicketID: assignment.TicketID})
+			}
+			counts[assignment.TicketID] += uint(len(section.Seats))
+		}
+
+		var tickets []db.Ticket
+		result := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("event_id = ? AND status <> ?", event.ID, db.Canceled).
+			Find(&tickets)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// A tier left out of the new map would keep the total of its old seats, with no seat to sell
+		var seatedIDs []uint
+		result = tx.
+			Model(&db.EventSeat{}).
+			Distinct("ticket_id").
+			Where("event_id = ?", event.ID).
+			Pluck("ticket_id", &seatedIDs)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		found := 0
+		for _, ticket := range tickets {
+			count, ok := counts[ticket.ID]
+			if !ok {
+				if slices.Contains(seatedIDs, ticket.ID) {
+					return ConflictError{fmt.Sprintf("tier %s is seated, assign it a section of the new map", ticket.Rank)}
+				}
+				continue
+			}
+			found++
+			if err := ticket.Resize(count); err != nil {
+				return ConflictError{err.Error()}
+			}
+			if err := tx.Save(&ticket).Error; err != nil {
+				return err
+			}
+		}
+		if found != len(counts) {
+			return NotFoundError{"ticket"}
+		}
+
+		// Replace the previous seat map. Draft events have no bookings, so no seat is held
+		if err := tx.Unscoped().Where("event_id = ?", event.ID).Delete(&db.EventSeat{}).Error; err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(&seats, 500).Error; err != nil {
+			return err
+		}
+
+		event.VenueID = &venue.ID
+		return tx.Save(event).Error
+	})
+	if !ok {
+		return
+	}
+
+	for ticketID := range counts {
+		server.invalidateTicketStock(ctx, "PUT /api/events/:id/seats", ticketID)
+	}
+	server.writeEventSeats(ctx, "PUT /api/events/:id/seats", event.ID)
+}
+
+type EventSeatResponse struct {
+	SeatID    uint   `json:"seat_id"`
+	TicketID  uint   `json:"ticket_id"`
+	Section   string `json:"section"`
+	Row       string `json:"row"`
+	Number    int    `json:"number"`
+	Label     string `json:"label"`
+	Available bool   `json:"available"`
+}
+
+// Get the seat map of an event with the live availability of each seat
+func (server *Server) ListEventSeats(ctx *gin.Context) {
+	var event db.Event
+	result := server.queries.DB.
+		Where("id = ? AND status <> ?", ctx.Param("id"), db.Draft).
+		First(&event)
+	if result.Error != nil {
+		server.writeError(ctx, "GET /api/events/:id/seats", "event", result.Error)
+		return
+	}
+
+	server.writeEventSeats(ctx, "GET /api/events/:id/seats", event.ID)
+}
+
+// Helper method: write the seat map of an event as the response
+func (server *Server) writeEventSeats(ctx *gin.Context, route string, eventID uint) {
+	resp := []EventSeatResponse{}
+	err := server.queries.DB.
+		Model(&db.EventSeat{}).
+		Select(`event_seats.seat_id, event_seats.ticket_id, sections.name AS section, seats.row, seats.number,
+			seats.label, event_seats.booking_id IS NULL AS available`).
+		Joins("JOIN seats ON seats.id = event_seats.seat_id").
+		Joins("JOIN sections ON sections.id = seats.section_id").
+		Where("event_seats.event_id = ?", eventID).
+		Order("sections.priority, sections.id, seats.row_index, seats.number").
+		Scan(&resp).Error
+	if err != nil {
+		server.logger.Error(route+": failed to get seats", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/worker"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+)
+
+type JoinWaitlistRequest struct {
+	TicketID uint `json:"ticket_id" binding:"required"`
+	Quantity uint `json:"quantity" binding:"required,min=1,max=10"`
+}
+
+type WaitlistEntryResponse struct {
+	ID        uint              `json:"id"`
+	TicketID  uint              `json:"ticket_id"`
+	Quantity  uint              `json:"quantity"`
+	Status    db.WaitlistStatus `json:"status"`
+	CreatedAt time.Time         `json:"created_at"`
+
+	// Place in the queue starting from 1, only while waiting
+	Position int64 `json:"position,omitempty"`
+
+	// Until when the offered tickets are held, only while offered
+	OfferExpiresAt *time.Time `json:"offer_expires_at,omitempty"`
+}
+
+func NewWaitlistEntryResponse(entry db.WaitlistEntry, position int64) WaitlistEntryResponse {
+	resp := WaitlistEntryResponse{
+		ID:        entry.ID,
+		TicketID:  entry.TicketID,
+		Quantity:  entry.Quantity,
+		Status:    entry.Status,
+		CreatedAt: entry.CreatedAt,
+		Position:  position,
+	}
+	if entry.Status == db.WaitlistOffered && entry.OfferExpiresAt.Valid {
+		resp.OfferExpiresAt = &entry.OfferExpiresAt.Time
+	}
+	return resp
+}
+
+// Join the waitlist of a sold out ticket tier. When tickets are given back, they are offered to the people
+// waiting in the order they joined, and each offer is held for a limited time
+func (server *Server) JoinWaitlist(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var req JoinWaitlistRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		server.logger.Warn("POST /api/waitlist: failed to get request body", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid request body"})
+		return
+	}
+
+	entry, err := server.queries.JoinWaitlist(ctx, claims.ID, req.TicketID, req.Quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrWaitlistNotNeeded), errors.Is(err, db.ErrAlreadyWaiting), errors.Is(err, db.ErrTicketNotOnSale):
+			ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+		default:
+			server.writeError(ctx, "POST /api/waitlist", "ticket", err)
+		}
+		return
+	}
+
+	position, err := server.queries.WaitlistPosition(ctx, entry)
+	if err != nil {
+		server.logger.Warn("POST /api/waitlist: failed to get queue position", "error", err)
+	}
+	ctx.JSON(http.StatusCreated, NewWaitlistEntryResponse(entry, position))
+}
+
+// Leave a waitlist. If the tickets have already been offered, they are passed on to the next person
+func (server *Server) LeaveWaitlist(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid waitlist entry ID"})
+		return
+	}
+
+	entry, err := server.queries.LeaveWaitlist(ctx, uint(id), claims.ID)
+	if err != nil && entry.ID == 0 {
+		switch {
+		case errors.Is(err, db.ErrOfferUnavailable):
+			ctx.JSON(http.StatusConflict, ErrorResponse{"waitlist entry is no longer active"})
+		default:
+			server.writeError(ctx, "DELETE /api/waitlist/:id", "waitlist entry", err)
+		}
+		return
+	}
+	if err != nil {
+		server.logger.Warn("DELETE /api/waitlist/:id: failed to invalidate ticket stock", "error", err)
+	}
+
+	// The people behind may now fit in what is left
+	server.offerWaitlist(ctx, "DELETE /api/waitlist/:id", entry.TicketID)
+	ctx.JSON(http.StatusOK, NewWaitlistEntryResponse(entry, 0))
+}
+
+// Claim the tickets offered to the current user. They become pending bookings, which must be paid before
+// their hold expires like any other booking
+func (server *Server) ClaimWaitlistOffer(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"invalid waitlist entry ID"})
+		return
+	}
+
+	_, bookings, err := server.queries.ClaimWaitlistOffer(ctx, uint(id), claims.ID, server.config.BookingHoldDuration)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrOfferUnavailable):
+			ctx.JSON(http.StatusConflict, ErrorResponse{err.Error()})
+		default:
+			server.writeError(ctx, "POST /api/waitlist/:id/claim", "waitlist entry", err)
+		}
+		return
+	}
+
+	// Release the bookings once the hold expires. If this fails, the cron sweeper will release them instead
+	ids := make([]uint, len(bookings))
+	resp := make([]BookingResponse, len(bookings))
+	for i, booking := range bookings {
+		ids[i] = booking.ID
+		resp[i] = NewBookingResponse(booking)
+	}
+	err = server.distributor.DistributeTask(ctx, worker.ReleaseBooking, worker.ReleaseBookingPayload{BookingIDs: ids},
+		asynq.ProcessIn(server.config.BookingHoldDuration))
+	if err != nil {
+		server.logger.Warn("POST /api/waitlist/:id/claim: failed to schedule booking release", "error", err)
+	}
+	ctx.JSON(http.StatusCreated, resp)
+}
+
+type ListMyWaitlistQuery struct {
+	PageQuery
+
+	Status db.WaitlistStatus `form:"status" binding:"omitempty,oneof=waiting offered claimed expired left"`
+}
+
+// List the waitlist entries of the current user, newest first, with their place in the queue
+func (server *Server) ListMyWaitlist(ctx *gin.Context) {
+	claims := getClaims(ctx)
+
+	var query ListMyWaitlistQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		server.logger.Warn("GET /api/me/waitlist: failed to get query parameters", "error", err)
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{"Invalid query parameters"})
+		return
+	}
+	offset := query.Offset()
+
+	tx := server.queries.DB.Model(&db.WaitlistEntry{}).Where("account_id = ?", claims.ID)
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		server.logger.Error("GET /api/me/waitlist: failed to count waitlist entries", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+	var entries []db.WaitlistEntry
+	if err := tx.Order("id DESC").Offset(offset).Limit(query.PageSize).Find(&entries).Error; err != nil {
+		server.logger.Error("GET /api/me/waitlist: failed to list waitlist entries", "error", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+		return
+	}
+
+	items := make([]WaitlistEntryResponse, len(entries))
+	for i, entry := range entries {
+		position, err := server.queries.WaitlistPosition(ctx, entry)
+		if err != nil {
+			server.logger.Error("GET /api/me/waitlist: failed to get queue position", "error", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{"Internal server error"})
+			return
+		}
+		items[i] = NewWaitlistEntryResponse(entry, position)
+	}
+
+	ctx.JSON(http.StatusOK, PageResponse[WaitlistEntryResponse]{
+		Items:    items,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+}
+
+// Helper method: offer tickets given back to tiers to the people waiting for them. If this fails,
+// the cron sweeper will offer them instead
+func (server *Server) offerWaitlist(ctx *gin.Context, route string, ticketIDs ...uint) {
+	if len(ticketIDs) == 0 {
+		return
+	}
+	err := server.distributor.DistributeTask(ctx, worker.OfferWaitlist, worker.OfferWaitlistPayload{TicketIDs: ticketIDs})
+	if err != nil {
+		server.logger.Warn(route+": failed to offer tickets to the waitlist", "error", err)
+	}
+}
+
+// Helper function: get the tiers of bookings
+func bookingTickets(bookings []db.Booking) []uint {
+	ticketIDs := make([]uint, len(bookings))
+	for i, booking := range bookings {
+		ticketIDs[i] = booking.TicketID
+	}
+	return ticketIDs
+}
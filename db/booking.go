@@ -0,0 +1,266 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSoldOut         = errors.New("not enough tickets available")
+	ErrTicketNotOnSale = errors.New("ticket is not on sale")
+)
+
+// The cached stock of a tier expires so that any drift from the database heals by itself
+const ticketStockTTL = time.Minute * 10
+
+// Cache key of the remaining stock of a ticket tier
+func TicketStockKey(ticketID uint) string {
+	return fmt.Sprintf("ticket-stock:%d", ticketID)
+}
+
+// Decrement the cached stock only if there are enough tickets left, in a single step.
+// Return -1 if the stock is not cached, -2 if there are not enough tickets, or the remaining stock
+var reserveStockScript = redis.NewScript(`
+local stock = redis.call('GET', KEYS[1])
+if not stock then
+	return -1
+end
+local quantity = tonumber(ARGV[1])
+if tonumber(stock) < quantity then
+	return -2
+end
+return redis.call('DECRBY', KEYS[1], quantity)
+`)
+
+// Give tickets back to the cached stock. If the stock is not cached, there is nothing to do since it will be
+// loaded from the database next time
+var releaseStockScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.call('INCRBY', KEYS[1], ARGV[1])
+end
+return -1
+`)
+
+// Helper method: reserve tickets from the cached stock. The cache only guards the database from the rush,
+// the database is still the source of truth. Return false if the stock cannot be cached
+func (queries *Queries) reserveStock(ctx context.Context, ticketID, quantity uint) (bool, error) {
+	key := TicketStockKey(ticketID)
+
+	// Run at most twice: the second run happens right after loading the stock
+	for range 2 {
+		res, err := reserveStockScript.Run(ctx, queries.Cache, []string{key}, quantity).Int64()
+		if err != nil {
+			return false, err
+		}
+
+		switch res {
+		case -1:
+			var available uint
+			result := queries.DB.WithContext(ctx).
+				Model(&Ticket{}).
+				Select("available").
+				Where("id = ?", ticketID).
+				Scan(&available)
+			if result.Error != nil {
+				return false, result.Error
+			}
+			if result.RowsAffected == 0 {
+				return false, gorm.ErrRecordNotFound
+			}
+
+			// Other requests may have loaded it first, keep theirs since they may have reserved from it
+			if err := queries.Cache.SetNX(ctx, key, available, ticketStockTTL).Err(); err != nil {
+				return false, err
+			}
+		case -2:
+			return false, ErrSoldOut
+		default:
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Helper method: give tickets back to the cached stock
+func (queries *Queries) releaseStock(ctx context.Context, ticketID, quantity uint) error {
+	return releaseStockScript.Run(ctx, queries.Cache, []string{TicketStockKey(ticketID)}, quantity).Err()
+}
+
+// Remove the cached stock of a tier. It must be called after the total of a tier is changed outside of booking
+func (queries *Queries) InvalidateTicketStock(ctx context.Context, ticketID uint) error {
+	return queries.Cache.Del(ctx, TicketStockKey(ticketID)).Err()
+}
+
+// Reserve tickets of a tier for an account, creating one pending booking per ticket that holds it until
+// the hold expires. If the tier has a seat map, each booking also holds a seat: the given seats, or the best
+// available ones if none are given. Return ErrSoldOut if there are not enough tickets left, ErrTicketNotOnSale
+// if the tier is not published or the event has started, or ErrSeatUnavailable if a given seat has been taken
+func (queries *Queries) ReserveTickets(
+	ctx context.Context,
+	accountID, ticketID, quantity uint,
+	seatIDs []uint,
+	hold time.Duration,
+) ([]Booking, error) {
+	// Reject early from the cache if sold out. If the cache is unavailable, the database alone is enough
+	cached, err := queries.reserveStock(ctx, ticketID, quantity)
+	if err != nil && (errors.Is(err, ErrSoldOut) || errors.Is(err, gorm.ErrRecordNotFound)) {
+		return nil, err
+	}
+
+	var bookings []Booking
+	err = queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		bookings, err = reserveTickets(tx, accountID, ticketID, quantity, seatIDs, hold, nil)
+		return err
+	})
+	if err != nil {
+		// Give back what was taken from the cache
+		if cached {
+			if releaseErr := queries.releaseStock(ctx, ticketID, quantity); releaseErr != nil {
+				return nil, errors.Join(err, releaseErr)
+			}
+		}
+		return nil, err
+	}
+
+	return bookings, nil
+}
+
+// Helper function: reserve tickets of a tier within a transaction, after they have been taken from the cached stock
+func reserveTickets(
+	tx *gorm.DB,
+	accountID, ticketID, quantity uint,
+	seatIDs []uint,
+	hold time.Duration,
+	orderID *uint,
+) ([]Booking, error) {
+	// The conditional update only succeeds if enough tickets are left, no matter how many requests run at once.
+	// Returned tickets are kept while they can fill the offer of someone waiting for the tier
+	now := time.Now()
+	result := tx.
+		Model(&Ticket{}).
+		Where("id = ? AND status = ? AND available >= ?", ticketID, Published, quantity).
+		Where("EXISTS (SELECT 1 FROM events WHERE events.id = tickets.event_id AND events.start_time > ?)", now).
+		Where("NOT "+waitlistOfferDue, WaitlistWaiting).
+		Update("available", gorm.Expr("available - ?", quantity))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ticketUnavailableError(tx, ticketID, now)
+	}
+
+	return createBookings(tx, accountID, ticketID, quantity, seatIDs, hold, orderID)
+}
+
+// Helper function: create pending bookings for tickets that have already been taken from the tier
+func createBookings(
+	tx *gorm.DB,
+	accountID, ticketID, quantity uint,
+	seatIDs []uint,
+	hold time.Duration,
+	orderID *uint,
+) ([]Booking, error) {
+	bookings := make([]Booking, quantity)
+	for i := range bookings {
+		bookings[i] = Booking{
+			AccountID:     accountID,
+			TicketID:      ticketID,
+			OrderID:       orderID,
+			Status:        Pending,
+			HoldExpiresAt: sql.NullTime{Time: time.Now().Add(hold), Valid: true},
+		}
+	}
+	if err := tx.Create(&bookings).Error; err != nil {
+		return nil, err
+	}
+	return bookings, assignSeats(tx, ticketID, bookings, seatIDs)
+}
+
+// Helper function: find out why tickets of a tier cannot be reserved
+func ticketUnavailableError(tx *gorm.DB, ticketID uint, now time.Time) error {
+	var ticket Ticket
+	if err := tx.Preload("Event").First(&ticket, ticketID).Error; err != nil {
+		return err
+	}
+	if ticket.Status != Published || !ticket.Event.StartTime.After(now) {
+		return ErrTicketNotOnSale
+	}
+	return ErrSoldOut
+}
+
+// Release pending bookings and return their tickets to the tiers. Bookings that are no longer pending are skipped,
+// so it is safe to release the same booking more than once. Return the bookings that have been released,
+// even if the error is not nil, since the error then only means the cached stock could not be updated
+func (queries *Queries) ReleaseBookings(ctx context.Context, ids []uint) ([]Booking, error) {
+	var released []Booking
+	if len(ids) == 0 {
+		return released, nil
+	}
+
+	counts := map[uint]uint{}
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Model(&released).
+			Clauses(clause.Returning{}).
+			Where("id IN ? AND status = ?", ids, Pending).
+			Update("status", Released)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		releasedIDs := make([]uint, len(released))
+		for i, booking := range released {
+			counts[booking.TicketID]++
+			releasedIDs[i] = booking.ID
+		}
+		if err := FreeSeats(tx, releasedIDs); err != nil {
+			return err
+		}
+
+		// Bookings of an order are released together, so the order is over once any of them is released
+		result = tx.
+			Model(&Order{}).
+			Where("status = ? AND id IN (SELECT order_id FROM bookings WHERE id IN ?)", OrderPending, releasedIDs).
+			Update("status", OrderReleased)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return returnTicketsInOrder(tx, counts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The database has been updated, a failure here only makes the cache undercount until it expires
+	var errs []error
+	for ticketID, count := range counts {
+		if err := queries.releaseStock(ctx, ticketID, count); err != nil {
+			errs = append(errs, queries.InvalidateTicketStock(ctx, ticketID))
+		}
+	}
+
+	return released, errors.Join(errs...)
+}
+
+// Release all pending bookings whose hold has expired before the given time
+func (queries *Queries) ReleaseExpiredBookings(ctx context.Context, now time.Time) ([]Booking, error) {
+	var ids []uint
+	result := queries.DB.WithContext(ctx).
+		Model(&Booking{}).
+		Where("status = ? AND hold_expires_at <= ?", Pending, now).
+		Pluck("id", &ids)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return queries.ReleaseBookings(ctx, ids)
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Helper function: create a published event with a single tier
+func createTestTier(t *testing.T, total uint) (Account, Ticket) {
+	suffix := time.Now().UnixNano()
+	account := Account{
+		Username: fmt.Sprintf("booking-test-%d", suffix),
+		Email:    fmt.Sprintf("booking-test-%d@example.com", suffix),
+		Status:   Active,
+		Role:     User,
+	}
+	require.NoError(t, queries.DB.Create(&account).Error)
+
+	event := Event{
+		HostID:      account.ID,
+		Name:        "Booking test",
+		Description: "Booking test",
+		Location:    "Booking test",
+		StartTime:   time.Now().Add(time.Hour * 24),
+		EndTime:     time.Now().Add(time.Hour * 26),
+		Status:      Published,
+	}
+	require.NoError(t, queries.DB.Create(&event).Error)
+
+	ticket := Ticket{
+		EventID:   event.ID,
+		Rank:      "standard",
+		Total:     total,
+		Available: total,
+		Price:     10,
+		Status:    Published,
+	}
+	require.NoError(t, queries.DB.Create(&ticket).Error)
+
+	return account, ticket
+}
+
+func TestReserveTicketsNoOversell(t *testing.T) {
+	connectStores(t)
+
+	// Keep the pool below the default Postgres connection limit
+	sqlDB, err := queries.DB.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(50)
+
+	const (
+		total    = 500
+		requests = 3000
+	)
+	account, ticket := createTestTier(t, total)
+
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		success int
+		soldOut int
+		others  []error
+	)
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, nil, time.Minute)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch {
+			case err == nil:
+				success++
+			case errors.Is(err, ErrSoldOut):
+				soldOut++
+			default:
+				others = append(others, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Exactly the total has been sold, no more and no less
+	require.Empty(t, others)
+	require.Equal(t, total, success)
+	require.Equal(t, requests-total, soldOut)
+
+	require.NoError(t, queries.DB.First(&ticket, ticket.ID).Error)
+	require.Equal(t, uint(0), ticket.Available)
+
+	var pending int64
+	require.NoError(t, queries.DB.Model(&Booking{}).Where("ticket_id = ? AND status = ?", ticket.ID, Pending).Count(&pending).Error)
+	require.Equal(t, int64(total), pending)
+
+	stock, err := queries.Cache.Get(t.Context(), TicketStockKey(ticket.ID)).Int()
+	require.NoError(t, err)
+	require.Equal(t, 0, stock)
+}
+
+func TestReleaseBookings(t *testing.T) {
+	connectStores(t)
+
+	account, ticket := createTestTier(t, 5)
+	bookings, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 5, nil, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, bookings, 5)
+
+	_, err = queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, nil, time.Minute)
+	require.ErrorIs(t, err, ErrSoldOut)
+
+	// Release two bookings, twice. The second time does nothing
+	ids := []uint{bookings[0].ID, bookings[1].ID}
+	released, err := queries.ReleaseBookings(t.Context(), ids)
+	require.NoError(t, err)
+	require.Len(t, released, 2)
+	released, err = queries.ReleaseBookings(t.Context(), ids)
+	require.NoError(t, err)
+	require.Empty(t, released)
+
+	require.NoError(t, queries.DB.First(&ticket, ticket.ID).Error)
+	require.Equal(t, uint(2), ticket.Available)
+	stock, err := queries.Cache.Get(t.Context(), TicketStockKey(ticket.ID)).Int()
+	require.NoError(t, err)
+	require.Equal(t, 2, stock)
+
+	// Expired holds are released by time
+	released, err = queries.ReleaseExpiredBookings(t.Context(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(released), 3)
+
+	require.NoError(t, queries.DB.First(&ticket, ticket.ID).Error)
+	require.Equal(t, uint(5), ticket.Available)
+}
+
+func TestReleaseExpiredBookings(t *testing.T) {
+	connectStores(t)
+
+	account, ticket := createTestTier(t, 5)
+	expired, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 2, nil, -time.Minute)
+	require.NoError(t, err)
+	held, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, nil, time.Hour)
+	require.NoError(t, err)
+	paid, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, nil, -time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, queries.DB.Model(&paid[0]).Update("status", Valid).Error)
+
+	// Only pending bookings whose hold has expired are released. Other tests may leave expired holds behind,
+	// so only the bookings of this tier are checked
+	sweep := func() []uint {
+		released, err := queries.ReleaseExpiredBookings(t.Context(), time.Now())
+		require.NoError(t, err)
+		var ids []uint
+		for _, booking := range released {
+			if booking.TicketID == ticket.ID {
+				ids = append(ids, booking.ID)
+			}
+		}
+		return ids
+	}
+	require.ElementsMatch(t, []uint{expired[0].ID, expired[1].ID}, sweep())
+	require.Empty(t, sweep())
+
+	var booking Booking
+	require.NoError(t, queries.DB.First(&booking, held[0].ID).Error)
+	require.Equal(t, Pending, booking.Status)
+	require.NoError(t, queries.DB.First(&booking, paid[0].ID).Error)
+	require.Equal(t, Valid, booking.Status)
+
+	// The released tickets are back in stock
+	require.NoError(t, queries.DB.First(&ticket, ticket.ID).Error)
+	require.Equal(t, uint(3), ticket.Available)
+	stock, err := queries.Cache.Get(t.Context(), TicketStockKey(ticket.ID)).Int()
+	require.NoError(t, err)
+	require.Equal(t, 3, stock)
+}
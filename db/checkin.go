@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTicketCodeRevoked = errors.New("ticket code has been replaced by a newer one")
+	ErrBookingNotValid   = errors.New("booking is not a paid ticket")
+)
+
+// Returned when a ticket is scanned again, with who scanned it first and when
+type AlreadyCheckedInError struct {
+	By uint
+	At time.Time
+}
+
+func (err *AlreadyCheckedInError) Error() string {
+	return fmt.Sprintf("ticket has already been checked in at %s", err.At.Format(time.RFC3339))
+}
+
+// Check in a ticket of an event, moving its booking from valid to used. The update is conditional,
+// so when the same ticket is scanned at two doors at once only one of them succeeds.
+// Return gorm.ErrRecordNotFound if the booking is not a ticket of the event, ErrTicketCodeRevoked if the code
+// version is outdated, *AlreadyCheckedInError if it has been checked in, or ErrBookingNotValid otherwise
+func (queries *Queries) CheckIn(ctx context.Context, bookingID, eventID, version, staffID uint) (Booking, error) {
+	var booking Booking
+	result := queries.DB.WithContext(ctx).
+		Model(&booking).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND status = ? AND ticket_version = ?", bookingID, Valid, version).
+		Where("EXISTS (SELECT 1 FROM tickets WHERE tickets.id = bookings.ticket_id AND tickets.event_id = ?)", eventID).
+		Updates(map[string]any{
+			"status":        Used,
+			"checked_in_at": sql.NullTime{Time: time.Now(), Valid: true},
+			"checked_in_by": staffID,
+		})
+	if result.Error != nil {
+		return booking, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return booking, nil
+	}
+
+	// Find out why the ticket cannot be checked in
+	err := queries.DB.WithContext(ctx).
+		Joins("JOIN tickets ON tickets.id = bookings.ticket_id").
+		Where("tickets.event_id = ?", eventID).
+		First(&booking, bookingID).Error
+	switch {
+	case err != nil:
+		return booking, err
+	case booking.TicketVersion != version:
+		return booking, ErrTicketCodeRevoked
+	case booking.Status == Used && booking.CheckedInAt.Valid && booking.CheckedInBy != nil:
+		return booking, &AlreadyCheckedInError{By: *booking.CheckedInBy, At: booking.CheckedInAt.Time}
+	default:
+		return booking, ErrBookingNotValid
+	}
+}
+
+// Count the checked in tickets and all paid tickets of an event
+func (queries *Queries) EventAttendance(ctx context.Context, eventID uint) (checkedIn, total int64, err error) {
+	var counts struct {
+		CheckedIn int64
+		Total     int64
+	}
+	err = queries.DB.WithContext(ctx).
+		Model(&Booking{}).
+		Select("COUNT(*) FILTER (WHERE bookings.status = ?) AS checked_in, COUNT(*) AS total", Used).
+		Joins("JOIN tickets ON tickets.id = bookings.ticket_id").
+		Where("tickets.event_id = ? AND bookings.status IN ?", eventID, []TicketStatus{Valid, Used}).
+		Scan(&counts).Error
+	return counts.CheckedIn, counts.Total, err
+}
+
+// Check if an account has been assigned to check tickets in at an event
+func (queries *Queries) IsEventStaff(ctx context.Context, eventID, accountID uint) (bool, error) {
+	var count int64
+	err := queries.DB.WithContext(ctx).
+		Model(&EventStaff{}).
+		Where("event_id = ? AND account_id = ?", eventID, accountID).
+		Count(&count).Error
+	return count > 0, err
+}
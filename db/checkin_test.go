@@ -0,0 +1,90 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Helper function: create a published event with a paid booking of it. The account holding the booking
+// also hosts the event, so it can scan its own ticket
+func createCheckInBooking(t *testing.T) (Account, Event, Booking) {
+	suffix := time.Now().UnixNano()
+	account := Account{
+		Username: fmt.Sprintf("checkin-test-%d", suffix),
+		Email:    fmt.Sprintf("checkin-test-%d@example.com", suffix),
+		Status:   Active,
+		Role:     User,
+	}
+	require.NoError(t, queries.DB.Create(&account).Error)
+
+	event := Event{
+		HostID:      account.ID,
+		Name:        "Check-in test",
+		Description: "Check-in test",
+		Location:    "Check-in test",
+		StartTime:   time.Now().Add(time.Hour * 24),
+		EndTime:     time.Now().Add(time.Hour * 26),
+		Status:      Published,
+	}
+	require.NoError(t, queries.DB.Create(&event).Error)
+
+	ticket := Ticket{EventID: event.ID, Rank: "standard", Total: 10, Available: 9, Price: 10, Status: Published}
+	require.NoError(t, queries.DB.Create(&ticket).Error)
+
+	booking := Booking{AccountID: account.ID, TicketID: ticket.ID, Status: Valid, TicketVersion: 1}
+	require.NoError(t, queries.DB.Create(&booking).Error)
+	return account, event, booking
+}
+
+func TestCheckIn(t *testing.T) {
+	connectStores(t)
+
+	account, event, booking := createCheckInBooking(t)
+
+	// Outdated code or wrong event should not pass
+	_, err := queries.CheckIn(t.Context(), booking.ID, event.ID, 0, account.ID)
+	require.ErrorIs(t, err, ErrTicketCodeRevoked)
+	_, err = queries.CheckIn(t.Context(), booking.ID, event.ID+1, 1, account.ID)
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Scan at many doors at once, only one should succeed
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  []error
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := queries.CheckIn(t.Context(), booking.ID, event.ID, 1, account.ID)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			errs = append(errs, err)
+		}()
+	}
+	wg.Wait()
+
+	success := 0
+	for _, err := range errs {
+		if err == nil {
+			success++
+			continue
+		}
+		var checkedIn *AlreadyCheckedInError
+		require.ErrorAs(t, err, &checkedIn)
+		require.Equal(t, account.ID, checkedIn.By)
+	}
+	require.Equal(t, 1, success)
+
+	checkedIn, total, err := queries.EventAttendance(t.Context(), event.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), checkedIn)
+	require.Equal(t, int64(1), total)
+}
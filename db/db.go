@@ -23,7 +23,8 @@ func NewQueries() *Queries {
 
 // Connect to Postgres
 func (queries *Queries) ConnectDB(connStr string) error {
-	conn, err := gorm.Open(postgres.Open(connStr))
+	// Translate the errors of the driver, so a unique violation can be checked with gorm.ErrDuplicatedKey
+	conn, err := gorm.Open(postgres.Open(connStr), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return err
 	}
@@ -34,7 +35,35 @@ func (queries *Queries) ConnectDB(connStr string) error {
 
 // Run postgres database auto migration
 func (queries *Queries) AutoMigration() error {
-	return queries.DB.AutoMigrate(&Account{}, &Membership{}, &Event{}, &Ticket{}, &Booking{})
+	err := queries.DB.AutoMigrate(
+		&Account{},
+		&Membership{},
+		&Venue{},
+		&Section{},
+		&Seat{},
+		&Event{},
+		&Ticket{},
+		&PromoCode{},
+		&Payment{},
+		&Order{},
+		&Booking{},
+		&EventSeat{},
+		&AuditLog{},
+		&WebhookEvent{},
+		&RefundRequest{},
+		&LedgerEntry{},
+		&EventCancellation{},
+		&EventStaff{},
+		&WaitlistEntry{},
+		&TicketTransfer{},
+		&ResaleListing{},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Indexes that GORM tags cannot express
+	return queries.createEventSearchIndex()
 }
 
 // Connect to Redis
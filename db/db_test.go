@@ -23,6 +23,17 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
+// Helper function: connect to the database and cache, skip the test if they are not configured
+func connectStores(t *testing.T) {
+	if dbConn == "" || redisConn == "" {
+		t.Skip("DB_CONN and REDIS_ADDRESS are required")
+	}
+
+	require.NoError(t, queries.ConnectDB(dbConn))
+	require.NoError(t, queries.AutoMigration())
+	require.NoError(t, queries.ConnectRedis(&redis.Options{Addr: redisConn}))
+}
+
 func TestDB(t *testing.T) {
 	// Test connection
 	err := queries.ConnectDB(dbConn)
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// The event state machine: which status an event can move to from its current status.
+// A canceled event is final, it can never be published again
+var eventTransitions = map[EventStatus][]EventStatus{
+	Draft:     {Published, Canceled},
+	Published: {Canceled},
+	Canceled:  {},
+}
+
+// Check if an event can move from this status to the next status
+func (status EventStatus) CanTransitionTo(next EventStatus) bool {
+	return slices.Contains(eventTransitions[status], next)
+}
+
+type EventSort string
+
+const (
+	SortStartTime     EventSort = "start_time"
+	SortStartTimeDesc EventSort = "-start_time"
+	SortPrice         EventSort = "price"
+	SortPriceDesc     EventSort = "-price"
+	SortNewest        EventSort = "newest"
+)
+
+// Position of the last item of a page, the next page starts right after it.
+// Only the field matching the sort order is set, ID breaks the tie between equal values
+type EventCursor struct {
+	Time  time.Time `json:"t,omitzero"`
+	Price float64   `json:"p,omitempty"`
+	ID    uint      `json:"id"`
+}
+
+type EventSearchParams struct {
+	// Full-text search over name, description and location
+	Query string
+
+	// Filter by start time range, host and price range (any published tier in range)
+	From     *time.Time
+	To       *time.Time
+	HostID   uint
+	MinPrice *float64
+	MaxPrice *float64
+
+	// Only events that still have tickets to sell
+	Available bool
+
+	Sort   EventSort
+	Limit  int
+	Cursor *EventCursor
+}
+
+// An event found by search, along with the lowest price of its published tiers
+type EventSearchResult struct {
+	Event
+	MinPrice float64 `json:"min_price"`
+}
+
+// The expression to search events. The GIN index created in migration uses the same expression,
+// so the search must use it exactly for Postgres to pick the index.
+// We use the simple configuration since event content is mostly Vietnamese, which Postgres has no stemmer for
+const eventSearchVector = "to_tsvector('simple', name || ' ' || description || ' ' || location)"
+
+// Create the index for event full-text search
+func (queries *Queries) createEventSearchIndex() error {
+	return queries.DB.
+		Exec("CREATE INDEX IF NOT EXISTS idx_events_search ON events USING GIN (" + eventSearchVector + ")").
+		Error
+}
+
+// Search published events. Return one page of events and the cursor of the next page (nil if this is the last page)
+func (queries *Queries) SearchEvents(ctx context.Context, params EventSearchParams) ([]EventSearchResult, *EventCursor, error) {
+	// Only count published tiers of the event
+	const publishedTiers = "FROM tickets t WHERE t.event_id = events.id AND t.status = 'published' AND t.deleted_at IS NULL"
+
+	// Inner query: filter published events and compute their lowest price
+	inner := queries.DB.
+		Table("events").
+		Select("events.*, COALESCE((SELECT MIN(t.price) "+publishedTiers+"), 0) AS min_price").
+		Where("events.deleted_at IS NULL AND events.status = ?", Published)
+	if params.Query != "" {
+		inner = inner.Where(eventSearchVector+" @@ websearch_to_tsquery('simple', ?)", params.Query)
+	}
+	if params.From != nil {
+		inner = inner.Where("events.start_time >= ?", *params.From)
+	}
+	if params.To != nil {
+		inner = inner.Where("events.start_time <= ?", *params.To)
+	}
+	if params.HostID != 0 {
+		inner = inner.Where("events.host_id = ?", params.HostID)
+	}
+	if params.MinPrice != nil || params.MaxPrice != nil {
+		minPrice, maxPrice := 0.0, math.MaxFloat64
+		if params.MinPrice != nil {
+			minPrice = *params.MinPrice
+		}
+		if params.MaxPrice != nil {
+			maxPrice = *params.MaxPrice
+		}
+		inner = inner.Where("EXISTS (SELECT 1 "+publishedTiers+" AND t.price BETWEEN ? AND ?)", minPrice, maxPrice)
+	}
+	if params.Available {
+		inner = inner.Where("EXISTS (SELECT 1 " + publishedTiers + " AND t.available > 0)")
+	}
+
+	// Outer query: keyset pagination over the sort column, so pages stay stable while new events are published
+	var column, direction string
+	switch params.Sort {
+	case SortStartTimeDesc:
+		column, direction = "start_time", "DESC"
+	case SortPrice:
+		column, direction = "min_price", "ASC"
+	case SortPriceDesc:
+		column, direction = "min_price", "DESC"
+	case SortNewest:
+		column, direction = "created_at", "DESC"
+	default:
+		column, direction = "start_time", "ASC"
+	}
+
+	outer := queries.DB.WithContext(ctx).Table("(?) AS e", inner)
+	if params.Cursor != nil {
+		operator := ">"
+		if direction == "DESC" {
+			operator = "<"
+		}
+		var value any = params.Cursor.Time
+		if column == "min_price" {
+			value = params.Cursor.Price
+		}
+		outer = outer.Where(fmt.Sprintf("(e.%s, e.id) %s (?, ?)", column, operator), value, params.Cursor.ID)
+	}
+
+	// Get one more item to know if there is a next page
+	var results []EventSearchResult
+	err := outer.
+		Order(fmt.Sprintf("e.%s %s, e.id %s", column, direction, direction)).
+		Limit(params.Limit + 1).
+		Scan(&results).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(results) <= params.Limit {
+		return results, nil, nil
+	}
+
+	results = results[:params.Limit]
+	last := results[len(results)-1]
+	cursor := &EventCursor{ID: last.ID}
+	switch column {
+	case "start_time":
+		cursor.Time = last.StartTime
+	case "created_at":
+		cursor.Time = last.CreatedAt
+	case "min_price":
+		cursor.Price = last.MinPrice
+	}
+
+	return results, cursor, nil
+}
+
+// Cache key of the event listing version. Every cached listing page has the version in its key,
+// so bumping the version invalidates all cached pages at once
+const eventListVersionKey = "events:list:version"
+
+// Get the current version of event listing cache
+func (queries *Queries) EventListVersion(ctx context.Context) string {
+	version, err := queries.Cache.Get(ctx, eventListVersionKey).Result()
+	if err != nil {
+		return "0"
+	}
+	return version
+}
+
+// Invalidate all cached event listing pages. Call it whenever an event or ticket tier changes
+func (queries *Queries) InvalidateEventList(ctx context.Context) error {
+	return queries.Cache.Incr(ctx, eventListVersionKey).Err()
+}
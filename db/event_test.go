@@ -0,0 +1,105 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventTransition(t *testing.T) {
+	// Draft can be published or canceled
+	require.True(t, Draft.CanTransitionTo(Published))
+	require.True(t, Draft.CanTransitionTo(Canceled))
+
+	// Published can only be canceled
+	require.True(t, Published.CanTransitionTo(Canceled))
+	require.False(t, Published.CanTransitionTo(Draft))
+
+	// Canceled is final
+	require.False(t, Canceled.CanTransitionTo(Published))
+	require.False(t, Canceled.CanTransitionTo(Draft))
+
+	// Unknown status cannot go anywhere
+	require.False(t, EventStatus("unknown").CanTransitionTo(Published))
+}
+
+func TestSearchEvents(t *testing.T) {
+	connectStores(t)
+
+	host := createTestAccount(t)
+	keyword := fmt.Sprintf("searchtest%d", time.Now().UnixNano())
+	createEvent := func(days int, status EventStatus, description string, price float64, available uint) Event {
+		event := Event{
+			HostID:      host.ID,
+			Name:        "Search test",
+			Description: description,
+			Location:    "Search test",
+			StartTime:   time.Now().Add(time.Duration(days) * 24 * time.Hour),
+			EndTime:     time.Now().Add(time.Duration(days)*24*time.Hour + 2*time.Hour),
+			Status:      status,
+		}
+		require.NoError(t, queries.DB.Create(&event).Error)
+		ticket := Ticket{
+			EventID:   event.ID,
+			Rank:      "standard",
+			Total:     5,
+			Available: available,
+			Price:     price,
+			Status:    Published,
+		}
+		require.NoError(t, queries.DB.Create(&ticket).Error)
+		return event
+	}
+	first := createEvent(1, Published, "Search test", 10, 5)
+	second := createEvent(2, Published, keyword, 30, 0)
+	third := createEvent(3, Published, "Search test", 20, 5)
+	createEvent(4, Draft, keyword, 10, 5)
+
+	search := func(params EventSearchParams) ([]uint, *EventCursor) {
+		params.HostID = host.ID
+		results, cursor, err := queries.SearchEvents(t.Context(), params)
+		require.NoError(t, err)
+		ids := make([]uint, len(results))
+		for i, result := range results {
+			ids[i] = result.ID
+		}
+		return ids, cursor
+	}
+
+	// Pages follow each other by cursor, drafts are never listed
+	ids, cursor := search(EventSearchParams{Limit: 2})
+	require.Equal(t, []uint{first.ID, second.ID}, ids)
+	require.NotNil(t, cursor)
+	ids, cursor = search(EventSearchParams{Limit: 2, Cursor: cursor})
+	require.Equal(t, []uint{third.ID}, ids)
+	require.Nil(t, cursor)
+
+	// The price cursor keeps its place too, from the most expensive lowest price down
+	var pages []uint
+	cursor = nil
+	for {
+		ids, cursor = search(EventSearchParams{Sort: SortPriceDesc, Limit: 1, Cursor: cursor})
+		pages = append(pages, ids...)
+		if cursor == nil {
+			break
+		}
+	}
+	require.Equal(t, []uint{second.ID, third.ID, first.ID}, pages)
+
+	// Filters
+	ids, _ = search(EventSearchParams{Query: keyword, Limit: 10})
+	require.Equal(t, []uint{second.ID}, ids)
+
+	minPrice, maxPrice := 15.0, 25.0
+	ids, _ = search(EventSearchParams{MinPrice: &minPrice, MaxPrice: &maxPrice, Limit: 10})
+	require.Equal(t, []uint{third.ID}, ids)
+
+	ids, _ = search(EventSearchParams{Available: true, Limit: 10})
+	require.Equal(t, []uint{first.ID, third.ID}, ids)
+
+	from, to := time.Now().Add(36*time.Hour), time.Now().Add(60*time.Hour)
+	ids, _ = search(EventSearchParams{From: &from, To: &to, Limit: 10})
+	require.Equal(t, []uint{second.ID}, ids)
+}
@@ -17,6 +17,22 @@ type EventStatus string
 
 type TicketStatus string
 
+type PaymentStatus string
+
+type RefundStatus string
+
+type LedgerType string
+
+type CancellationStatus string
+
+type OrderStatus string
+
+type WaitlistStatus string
+
+type TransferStatus string
+
+type ListingStatus string
+
 const (
 	Inactive AccountStatus = "inactive"
 	Active   AccountStatus = "active"
@@ -34,11 +50,51 @@ const (
 	Published EventStatus = "published"
 	Canceled  EventStatus = "canceled"
 
-	Pending TicketStatus = "pending"
-	Valid   TicketStatus = "valid"
-	Used    TicketStatus = "used"
-	Expired TicketStatus = "expired"
-	Refund  TicketStatus = "refund"
+	Pending   TicketStatus = "pending"
+	Valid     TicketStatus = "valid"
+	Used      TicketStatus = "used"
+	Expired   TicketStatus = "expired"
+	Refunding TicketStatus = "refunding"
+	Refund    TicketStatus = "refund"
+	Released  TicketStatus = "released"
+
+	PaymentPending   PaymentStatus = "pending"
+	PaymentSucceeded PaymentStatus = "succeeded"
+	PaymentFailed    PaymentStatus = "failed"
+	PaymentCanceled  PaymentStatus = "canceled"
+
+	RefundRequested RefundStatus = "requested"
+	RefundRefunding RefundStatus = "refunding"
+	RefundRejected  RefundStatus = "rejected"
+	RefundCompleted RefundStatus = "refunded"
+
+	LedgerCharge LedgerType = "charge"
+	LedgerRefund LedgerType = "refund"
+	LedgerResale LedgerType = "resale"
+
+	CancellationRunning   CancellationStatus = "running"
+	CancellationCompleted CancellationStatus = "completed"
+
+	OrderPending  OrderStatus = "pending"
+	OrderPaid     OrderStatus = "paid"
+	OrderFailed   OrderStatus = "failed"
+	OrderReleased OrderStatus = "released"
+
+	WaitlistWaiting WaitlistStatus = "waiting"
+	WaitlistOffered WaitlistStatus = "offered"
+	WaitlistClaimed WaitlistStatus = "claimed"
+	WaitlistExpired WaitlistStatus = "expired"
+	WaitlistLeft    WaitlistStatus = "left"
+
+	TransferPending  TransferStatus = "pending"
+	TransferAccepted TransferStatus = "accepted"
+	TransferDeclined TransferStatus = "declined"
+	TransferCanceled TransferStatus = "canceled"
+
+	ListingActive   ListingStatus = "active"
+	ListingReserved ListingStatus = "reserved"
+	ListingSold     ListingStatus = "sold"
+	ListingCanceled ListingStatus = "canceled"
 )
 
 type Account struct {
@@ -83,6 +139,9 @@ type Membership struct {
 
 	// The minimum point to be at this tier
 	BasePoint uint `json:"base_point" gorm:"not null"`
+
+	// Discount in percent applied to every payment of members at this tier
+	Discount float64 `json:"discount" gorm:"not null;default:0;check:chk_memberships_discount,discount >= 0 AND discount <= 100"`
 }
 
 type Event struct {
@@ -100,6 +159,15 @@ type Event struct {
 	EndTime      time.Time      `json:"end_time" gorm:"not null"`
 	PreviewImage sql.NullString `json:"preview_image"`
 	Status       EventStatus    `json:"status" gorm:"not null"`
+
+	// The seat map of the event, if its tickets are seated
+	VenueID *uint `json:"venue_id"`
+
+	// The organiser can stop attendees from passing their tickets to other accounts
+	TransfersDisabled bool `json:"transfers_disabled" gorm:"not null;default:false"`
+
+	// Tickets can be resold for at most this percentage of their face price. Zero closes the resale market
+	ResaleCapPercent uint `json:"resale_cap_percent" gorm:"not null;default:110"`
 }
 
 type Ticket struct {
@@ -113,13 +181,13 @@ type Ticket struct {
 	Rank string `json:"rank" gorm:"not null"`
 
 	// The total ticket that host issues
-	Total uint `json:"total" gorm:"total"`
+	Total uint `json:"total" gorm:"not null;check:chk_tickets_total,total > 0"`
 
-	// The remaining tickets
-	Available uint `json:"available" gorm:"not null"`
+	// The remaining tickets. The constraints guard the inventory against any bug in the booking flow
+	Available uint `json:"available" gorm:"not null;check:chk_tickets_available,available >= 0 AND available <= total"`
 
 	// The price of the ticket (without applying memebership discount)
-	Price float64 `json:"price" gorm:"not null"`
+	Price float64 `json:"price" gorm:"not null;check:chk_tickets_price,price >= 0"`
 
 	// This is the global status of all tickets of a type belong to a specific event
 	// So its status would be similar to event status (draft, published, canceled).
@@ -138,11 +206,317 @@ type Booking struct {
 	TicketID uint   `json:"ticket_id" gorm:"not null"`
 	Ticket   Ticket `json:"ticket" gorm:"foreignKey:TicketID"`
 
-	// Seat number. Since the event location is not managed by the system,
-	// there is no constraint that can be applied to seat number
+	// Seat label, copied from the seat map when the tier has one. The seat itself is held in EventSeat,
+	// which makes sure the same seat cannot be sold twice. Tiers without a seat map leave it empty
 	SeatNumber string `json:"seat_number" gorm:"not null"`
 
+	// Version of the e-ticket code. It is bumped whenever the code must change, so that old codes stop working
+	TicketVersion uint `json:"ticket_version" gorm:"not null;default:1"`
+
 	// Ticket status: pending (has booked, but not pay), valid (has payed, has not used),
-	// used, expired (valid, not used even after event ended), refund (event canceled -> ticket is refund)
+	// used, expired (valid, not used even after event ended), refunding (event canceled, the refund is being paid out),
+	// refund (event canceled -> ticket is refund),
+	// released (not paid in time, the ticket is returned to the tier)
 	Status TicketStatus `json:"status" gorm:"not null"`
+
+	// A pending booking holds its ticket until this time. After that, it is released if it's still not paid
+	HoldExpiresAt sql.NullTime `json:"hold_expires_at" gorm:"index"`
+
+	// The payment of this booking. It is null until the user starts paying
+	PaymentID *uint `json:"payment_id" gorm:"index"`
+
+	// The order this booking has been bought in, if any. Bookings of an order are paid and released together
+	OrderID *uint `json:"order_id" gorm:"index"`
+
+	// The share of the order total charged for this booking, in cents. Bookings paid without an order
+	// leave it zero and share their payment equally
+	Amount int64 `json:"amount" gorm:"not null;default:0"`
+
+	// Set once the cancellation of the event has handled this booking, so its progress counts each booking once
+	CancellationHandled bool `json:"cancellation_handled" gorm:"not null;default:false"`
+
+	// The entry of this booking in the Google Calendar of the buyer, if it has been added
+	CalendarEventID sql.NullString `json:"calendar_event_id"`
+
+	// When and by which staff the ticket has been scanned at the door
+	CheckedInAt sql.NullTime `json:"checked_in_at"`
+	CheckedInBy *uint        `json:"checked_in_by"`
+}
+
+// A venue layout, made of sections of rows of seats. It can be reused by the events held there
+type Venue struct {
+	gorm.Model
+
+	// The organiser who imported the layout
+	CreatorID uint    `json:"creator_id" gorm:"not null"`
+	Creator   Account `json:"creator" gorm:"foreignKey:CreatorID"`
+
+	Name     string    `json:"name" gorm:"not null"`
+	Address  string    `json:"address" gorm:"not null"`
+	Sections []Section `json:"sections" gorm:"foreignKey:VenueID"`
+}
+
+type Section struct {
+	gorm.Model
+
+	VenueID uint   `json:"venue_id" gorm:"not null;uniqueIndex:idx_section_name"`
+	Name    string `json:"name" gorm:"not null;uniqueIndex:idx_section_name"`
+
+	// Sections with a lower priority are offered first when seats are assigned automatically
+	Priority int    `json:"priority" gorm:"not null"`
+	Seats    []Seat `json:"seats" gorm:"foreignKey:SectionID"`
+}
+
+type Seat struct {
+	gorm.Model
+
+	SectionID uint    `json:"section_id" gorm:"not null;uniqueIndex:idx_seat_position"`
+	Section   Section `json:"section" gorm:"foreignKey:SectionID"`
+
+	// Position of the seat: rows are ordered from the stage, seats are numbered within their row
+	Row      string `json:"row" gorm:"not null;uniqueIndex:idx_seat_position"`
+	RowIndex int    `json:"row_index" gorm:"not null"`
+	Number   int    `json:"number" gorm:"not null;uniqueIndex:idx_seat_position"`
+
+	// Printed on the ticket, like "Floor A12"
+	Label string `json:"label" gorm:"not null"`
+}
+
+// A seat of the venue put on sale for an event, under a ticket tier. The unique indexes make sure a seat
+// is sold at most once for each event, and a booking holds at most one seat
+type EventSeat struct {
+	gorm.Model
+
+	EventID uint `json:"event_id" gorm:"not null;uniqueIndex:idx_event_seat"`
+	SeatID  uint `json:"seat_id" gorm:"not null;uniqueIndex:idx_event_seat"`
+	Seat    Seat `json:"seat" gorm:"foreignKey:SeatID"`
+
+	TicketID uint   `json:"ticket_id" gorm:"not null;index"`
+	Ticket   Ticket `json:"ticket" gorm:"foreignKey:TicketID"`
+
+	// The booking holding the seat, null if the seat is available
+	BookingID *uint `json:"booking_id" gorm:"uniqueIndex"`
+}
+
+// A staff account assigned by the organiser to check tickets in at the door of an event
+type EventStaff struct {
+	gorm.Model
+
+	EventID uint  `json:"event_id" gorm:"not null;uniqueIndex:idx_event_staff"`
+	Event   Event `json:"event" gorm:"foreignKey:EventID"`
+
+	AccountID uint    `json:"account_id" gorm:"not null;uniqueIndex:idx_event_staff"`
+	Account   Account `json:"account" gorm:"foreignKey:AccountID"`
+}
+
+type PromoCode struct {
+	gorm.Model
+
+	// The code that users enter at checkout
+	Code string `json:"code" gorm:"not null;uniqueIndex"`
+
+	// Creator of the promo code
+	CreatorID uint    `json:"creator_id" gorm:"not null"`
+	Creator   Account `json:"creator" gorm:"foreignKey:CreatorID"`
+
+	// If set, the code can only be used for tickets of this event
+	EventID *uint `json:"event_id"`
+
+	// Discount in percent, applied after the membership discount
+	Discount float64 `json:"discount" gorm:"not null;check:chk_promo_codes_discount,discount > 0 AND discount <= 100"`
+
+	// How many payments can use this code, 0 means unlimited
+	MaxUses uint `json:"max_uses" gorm:"not null"`
+	Used    uint `json:"used" gorm:"not null"`
+
+	ExpiresAt sql.NullTime `json:"expires_at"`
+}
+
+type Payment struct {
+	gorm.Model
+
+	// The account who pays
+	AccountID uint    `json:"account_id" gorm:"not null;index"`
+	Account   Account `json:"account" gorm:"foreignKey:AccountID"`
+
+	// The payment intent at the payment provider
+	IntentID string `json:"intent_id" gorm:"not null;uniqueIndex"`
+
+	// The amount is counted in the smallest currency unit (cents), computed by the server
+	Amount   int64         `json:"amount" gorm:"not null"`
+	Currency string        `json:"currency" gorm:"not null"`
+	Status   PaymentStatus `json:"status" gorm:"not null"`
+
+	// The promo code applied to this payment, if any
+	PromoCodeID *uint      `json:"promo_code_id"`
+	PromoCode   *PromoCode `json:"promo_code" gorm:"foreignKey:PromoCodeID"`
+}
+
+// A checkout of several bookings, possibly of different tiers and events, paid with a single payment
+type Order struct {
+	gorm.Model
+
+	// The buyer
+	AccountID uint    `json:"account_id" gorm:"not null;index"`
+	Account   Account `json:"account" gorm:"foreignKey:AccountID"`
+
+	// Total after discounts, in the smallest currency unit (cents)
+	Amount   int64       `json:"amount" gorm:"not null"`
+	Currency string      `json:"currency" gorm:"not null"`
+	Status   OrderStatus `json:"status" gorm:"not null;index"`
+
+	// The payment of the order, which owns the payment intent
+	PaymentID *uint    `json:"payment_id" gorm:"index"`
+	Payment   *Payment `json:"payment" gorm:"foreignKey:PaymentID"`
+
+	Bookings []Booking `json:"bookings" gorm:"foreignKey:OrderID"`
+}
+
+// A place in the waitlist of a sold-out tier. When tickets come back, they are offered to the entries in order,
+// and held for the offered entry until the offer expires
+type WaitlistEntry struct {
+	gorm.Model
+
+	// An account can only wait once for each tier
+	TicketID  uint    `json:"ticket_id" gorm:"not null;uniqueIndex:idx_waitlist_active,where:status = 'waiting' OR status = 'offered'"`
+	Ticket    Ticket  `json:"ticket" gorm:"foreignKey:TicketID"`
+	AccountID uint    `json:"account_id" gorm:"not null;uniqueIndex:idx_waitlist_active;index"`
+	Account   Account `json:"account" gorm:"foreignKey:AccountID"`
+
+	// How many tickets are wanted. The offer is only made once that many tickets are available,
+	// until then smaller entries behind may be offered first
+	Quantity uint `json:"quantity" gorm:"not null;check:chk_waitlist_entries_quantity,quantity > 0"`
+
+	Status         WaitlistStatus `json:"status" gorm:"not null;index"`
+	OfferExpiresAt sql.NullTime   `json:"offer_expires_at"`
+}
+
+// A paid booking passed from its owner to another account. The booking changes hands once the recipient accepts
+type TicketTransfer struct {
+	gorm.Model
+
+	// A booking can only have one pending transfer at a time
+	BookingID   uint    `json:"booking_id" gorm:"not null;index;uniqueIndex:idx_transfer_pending,where:status = 'pending'"`
+	Booking     Booking `json:"booking" gorm:"foreignKey:BookingID"`
+	SenderID    uint    `json:"sender_id" gorm:"not null;index"`
+	Sender      Account `json:"sender" gorm:"foreignKey:SenderID"`
+	RecipientID uint    `json:"recipient_id" gorm:"not null;index"`
+	Recipient   Account `json:"recipient" gorm:"foreignKey:RecipientID"`
+
+	Status      TransferStatus `json:"status" gorm:"not null;index"`
+	RespondedAt sql.NullTime   `json:"responded_at"`
+}
+
+// A paid booking put on sale by its owner. A buyer holds the listing while paying for it, and once the payment
+// succeeds the booking changes hands and the seller is credited the price minus the platform fee
+type ResaleListing struct {
+	gorm.Model
+
+	// A booking can only be on sale once at a time
+	BookingID uint    `json:"booking_id" gorm:"not null;index;uniqueIndex:idx_listing_open,where:status = 'active' OR status = 'reserved'"`
+	Booking   Booking `json:"booking" gorm:"foreignKey:BookingID"`
+	SellerID  uint    `json:"seller_id" gorm:"not null;index"`
+	Seller    Account `json:"seller" gorm:"foreignKey:SellerID"`
+
+	// Price paid by the buyer and the fee kept by the platform, in the smallest currency unit (cents)
+	Price    int64  `json:"price" gorm:"not null;check:chk_resale_listings_price,price > 0"`
+	Fee      int64  `json:"fee" gorm:"not null"`
+	Currency string `json:"currency" gorm:"not null"`
+
+	Status ListingStatus `json:"status" gorm:"not null;index"`
+
+	// The buyer paying for the listing holds it until the hold expires. An expired hold makes the listing
+	// available again, unless the payment of the buyer completes first
+	BuyerID       *uint        `json:"buyer_id" gorm:"index"`
+	HoldExpiresAt sql.NullTime `json:"hold_expires_at"`
+	PaymentID     *uint        `json:"payment_id" gorm:"index"`
+	SoldAt        sql.NullTime `json:"sold_at"`
+}
+
+type AuditLog struct {
+	gorm.Model
+
+	// The account who performed the action
+	ActorID uint    `json:"actor_id" gorm:"not null;index"`
+	Actor   Account `json:"actor" gorm:"foreignKey:ActorID"`
+
+	// What has been done (ban, unban, change-role,...) to which record
+	Action     string `json:"action" gorm:"not null"`
+	TargetType string `json:"target_type" gorm:"not null;index:idx_audit_target"`
+	TargetID   uint   `json:"target_id" gorm:"not null;index:idx_audit_target"`
+
+	// Extra detail of the action in JSON, for example the old and new role
+	Detail string `json:"detail"`
+
+	// IP address of the actor
+	IP string `json:"ip"`
+}
+
+// A webhook event that has been processed. Payment providers may deliver the same event more than once,
+// so the event ID is recorded in the same transaction as its effects
+type WebhookEvent struct {
+	// The event ID given by the provider
+	ID string `json:"id" gorm:"primaryKey"`
+
+	Type        string    `json:"type" gorm:"not null"`
+	ProcessedAt time.Time `json:"processed_at" gorm:"not null"`
+}
+
+type RefundRequest struct {
+	gorm.Model
+
+	// The booking to refund and the account who asks for it
+	BookingID   uint    `json:"booking_id" gorm:"not null;index"`
+	Booking     Booking `json:"booking" gorm:"foreignKey:BookingID"`
+	RequesterID uint    `json:"requester_id" gorm:"not null;index"`
+	Requester   Account `json:"requester" gorm:"foreignKey:RequesterID"`
+	Reason      string  `json:"reason" gorm:"not null"`
+
+	// The amount to refund in cents, computed by the refund policy when the request is made
+	Amount int64        `json:"amount" gorm:"not null;check:chk_refund_requests_amount,amount > 0"`
+	Status RefundStatus `json:"status" gorm:"not null;index"`
+
+	// The staff who approved or rejected the request
+	ReviewerID *uint        `json:"reviewer_id"`
+	ReviewNote string       `json:"review_note"`
+	ReviewedAt sql.NullTime `json:"reviewed_at"`
+	ProviderID string       `json:"provider_id"`
+	RefundedAt sql.NullTime `json:"refunded_at"`
+}
+
+// Every money movement of the system. Amount is always positive, the type tells the direction
+type LedgerEntry struct {
+	gorm.Model
+
+	// The account who pays or receives the money
+	AccountID uint    `json:"account_id" gorm:"not null;index"`
+	Account   Account `json:"account" gorm:"foreignKey:AccountID"`
+
+	Type     LedgerType `json:"type" gorm:"not null"`
+	Amount   int64      `json:"amount" gorm:"not null;check:chk_ledger_entries_amount,amount > 0"`
+	Currency string     `json:"currency" gorm:"not null"`
+
+	// What the money is for
+	PaymentID *uint `json:"payment_id" gorm:"index"`
+	BookingID *uint `json:"booking_id" gorm:"index"`
+
+	// The ID of the movement at the payment provider, like the payment intent or refund ID
+	Reference string `json:"reference" gorm:"not null"`
+}
+
+// Progress of refunding the bookings of a canceled event. Each booking is refunded by its own task,
+// so the progress survives worker restarts
+type EventCancellation struct {
+	gorm.Model
+
+	EventID uint  `json:"event_id" gorm:"not null;uniqueIndex"`
+	Event   Event `json:"event" gorm:"foreignKey:EventID"`
+
+	// Total is counted once, when the refund tasks are distributed
+	Total     uint `json:"total" gorm:"not null"`
+	Processed uint `json:"processed" gorm:"not null"`
+	FannedOut bool `json:"fanned_out" gorm:"not null"`
+
+	Status      CancellationStatus `json:"status" gorm:"not null"`
+	CompletedAt sql.NullTime       `json:"completed_at"`
 }
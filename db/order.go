@@ -0,0 +1,130 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrOrderNotPending = errors.New("order is no longer waiting for payment")
+
+// A line of an order: tickets of a tier, and optionally the seats to hold in a seated tier
+type OrderItem struct {
+	TicketID uint
+	Quantity uint
+	SeatIDs  []uint
+}
+
+// Reserve the tickets of all items for an account as a single pending order. Either every ticket is reserved,
+// or nothing is. The payment is started once the order is committed, then stored with SetOrderPayment.
+// Errors are the same as ReserveTickets
+func (queries *Queries) CreateOrder(ctx context.Context, accountID uint, items []OrderItem, hold time.Duration) (Order, error) {
+	items = mergeOrderItems(items)
+
+	// Reject early from the cache if any tier is sold out, giving back what has been taken so far
+	cached := map[uint]uint{}
+	releaseCached := func(err error) error {
+		errs := []error{err}
+		for ticketID, quantity := range cached {
+			errs = append(errs, queries.releaseStock(ctx, ticketID, quantity))
+		}
+		return errors.Join(errs...)
+	}
+	for _, item := range items {
+		ok, err := queries.reserveStock(ctx, item.TicketID, item.Quantity)
+		if err != nil && (errors.Is(err, ErrSoldOut) || errors.Is(err, gorm.ErrRecordNotFound)) {
+			return Order{}, releaseCached(err)
+		}
+		if ok {
+			cached[item.TicketID] += item.Quantity
+		}
+	}
+
+	order := Order{AccountID: accountID, Status: OrderPending}
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			bookings, err := reserveTickets(tx, accountID, item.TicketID, item.Quantity, item.SeatIDs, hold, &order.ID)
+			if err != nil {
+				return err
+			}
+			order.Bookings = append(order.Bookings, bookings...)
+		}
+		return nil
+	})
+	if err != nil {
+		return Order{}, releaseCached(err)
+	}
+
+	return order, nil
+}
+
+// Helper function: merge the items of the same tier and sort them by tier, so concurrent orders always lock
+// the tiers in the same order and never deadlock each other
+func mergeOrderItems(items []OrderItem) []OrderItem {
+	merged := make([]OrderItem, 0, len(items))
+	index := map[uint]int{}
+	for _, item := range items {
+		i, ok := index[item.TicketID]
+		if !ok {
+			index[item.TicketID] = len(merged)
+			merged = append(merged, OrderItem{TicketID: item.TicketID})
+			i = len(merged) - 1
+		}
+		merged[i].Quantity += item.Quantity
+		merged[i].SeatIDs = append(merged[i].SeatIDs, item.SeatIDs...)
+	}
+	slices.SortFunc(merged, func(a, b OrderItem) int {
+		return cmp.Compare(a.TicketID, b.TicketID)
+	})
+	return merged
+}
+
+// Record the payment started for a pending order and link the order and its bookings to it. The amount of each
+// booking is taken from order.Bookings. Return ErrOrderNotPending if any booking has been released in the meantime,
+// in which case nothing is recorded and the intent should be canceled
+func (queries *Queries) SetOrderPayment(ctx context.Context, order *Order, record *Payment) error {
+	return queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := RecordPayment(tx, record); err != nil {
+			return err
+		}
+
+		for i := range order.Bookings {
+			booking := &order.Bookings[i]
+			result := tx.
+				Model(&Booking{}).
+				Where("id = ? AND order_id = ? AND status = ?", booking.ID, order.ID, Pending).
+				Updates(map[string]any{"amount": booking.Amount, "payment_id": record.ID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrOrderNotPending
+			}
+			booking.PaymentID = &record.ID
+		}
+
+		result := tx.
+			Model(&Order{}).
+			Where("id = ? AND status = ?", order.ID, OrderPending).
+			Updates(map[string]any{"amount": record.Amount, "currency": record.Currency, "payment_id": record.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrderNotPending
+		}
+
+		order.Amount = record.Amount
+		order.Currency = record.Currency
+		order.PaymentID = &record.ID
+		return nil
+	})
+}
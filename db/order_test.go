@@ -0,0 +1,132 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Helper function: create a published event with a tier for each of the totals
+func createOrderTiers(t *testing.T, totals ...uint) (Account, []Ticket) {
+	suffix := time.Now().UnixNano()
+	account := Account{
+		Username: fmt.Sprintf("order-test-%d", suffix),
+		Email:    fmt.Sprintf("order-test-%d@example.com", suffix),
+		Status:   Active,
+		Role:     User,
+	}
+	require.NoError(t, queries.DB.Create(&account).Error)
+
+	event := Event{
+		HostID:      account.ID,
+		Name:        "Order test",
+		Description: "Order test",
+		Location:    "Order test",
+		StartTime:   time.Now().Add(time.Hour * 24),
+		EndTime:     time.Now().Add(time.Hour * 26),
+		Status:      Published,
+	}
+	require.NoError(t, queries.DB.Create(&event).Error)
+
+	tickets := make([]Ticket, len(totals))
+	for i, total := range totals {
+		tickets[i] = Ticket{
+			EventID:   event.ID,
+			Rank:      fmt.Sprintf("tier-%d", i+1),
+			Total:     total,
+			Available: total,
+			Price:     10,
+			Status:    Published,
+		}
+		require.NoError(t, queries.DB.Create(&tickets[i]).Error)
+	}
+	return account, tickets
+}
+
+func TestCreateOrderAllOrNothing(t *testing.T) {
+	connectStores(t)
+
+	account, tickets := createOrderTiers(t, 5, 1)
+	first, second := tickets[0], tickets[1]
+
+	// The second tier cannot fill the order, so nothing is reserved from the first one either
+	_, err := queries.CreateOrder(t.Context(), account.ID, []OrderItem{
+		{TicketID: first.ID, Quantity: 2},
+		{TicketID: second.ID, Quantity: 2},
+	}, time.Minute)
+	require.ErrorIs(t, err, ErrSoldOut)
+	require.NoError(t, queries.DB.First(&first, first.ID).Error)
+	require.Equal(t, uint(5), first.Available)
+
+	order, err := queries.CreateOrder(t.Context(), account.ID, []OrderItem{
+		{TicketID: first.ID, Quantity: 2},
+		{TicketID: second.ID, Quantity: 1},
+	}, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, order.Bookings, 3)
+
+	// The payment started afterwards is recorded on the order and each of its bookings
+	record := Payment{
+		AccountID: account.ID,
+		IntentID:  fmt.Sprintf("pi_order_%d", order.ID),
+		Amount:    3000,
+		Currency:  "usd",
+		Status:    PaymentPending,
+	}
+	for i := range order.Bookings {
+		order.Bookings[i].Amount = 1000
+	}
+	require.NoError(t, queries.SetOrderPayment(t.Context(), &order, &record))
+	require.Equal(t, int64(3000), order.Amount)
+	var linked int64
+	require.NoError(t, queries.DB.Model(&Booking{}).Where("payment_id = ? AND amount = ?", record.ID, 1000).
+		Count(&linked).Error)
+	require.Equal(t, int64(3), linked)
+
+	// Releasing the bookings releases the order, which can no longer be linked to a payment
+	ids := make([]uint, len(order.Bookings))
+	for i, booking := range order.Bookings {
+		ids[i] = booking.ID
+	}
+	_, err = queries.ReleaseBookings(t.Context(), ids)
+	require.NoError(t, err)
+	released := order
+	require.NoError(t, queries.DB.First(&released, order.ID).Error)
+	require.Equal(t, OrderReleased, released.Status)
+	late := record
+	late.ID = 0
+	late.IntentID = fmt.Sprintf("pi_order_late_%d", order.ID)
+	require.ErrorIs(t, queries.SetOrderPayment(t.Context(), &order, &late), ErrOrderNotPending)
+}
+
+func TestCreateOrderMergesTiers(t *testing.T) {
+	connectStores(t)
+
+	account, tickets := createOrderTiers(t, 2, 2)
+	first, second := tickets[0], tickets[1]
+
+	// Lines of the same tier are reserved together, so two lines cannot take more than the tier has
+	_, err := queries.CreateOrder(t.Context(), account.ID, []OrderItem{
+		{TicketID: second.ID, Quantity: 1},
+		{TicketID: first.ID, Quantity: 2},
+		{TicketID: first.ID, Quantity: 1},
+	}, time.Minute)
+	require.ErrorIs(t, err, ErrSoldOut)
+
+	order, err := queries.CreateOrder(t.Context(), account.ID, []OrderItem{
+		{TicketID: second.ID, Quantity: 1},
+		{TicketID: first.ID, Quantity: 1},
+		{TicketID: first.ID, Quantity: 1},
+	}, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, order.Bookings, 3)
+
+	// The tiers are reserved in a fixed order, whatever the order of the lines
+	require.Equal(t, first.ID, order.Bookings[0].TicketID)
+	require.Equal(t, first.ID, order.Bookings[1].TicketID)
+	require.Equal(t, second.ID, order.Bookings[2].TicketID)
+	require.NoError(t, queries.DB.First(&first, first.ID).Error)
+	require.Equal(t, uint(0), first.Available)
+}
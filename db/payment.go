@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrWebhookProcessed  = errors.New("webhook event has already been processed")
+	ErrPromoCodeInvalid  = errors.New("promo code is invalid or has expired")
+	ErrBookingNotPending = errors.New("booking is no longer waiting for payment")
+)
+
+// Record a webhook event and apply its effects in the same transaction. If the event has been recorded before,
+// nothing is applied and ErrWebhookProcessed is returned. If apply fails, the event is not recorded,
+// so it will be processed again when the provider retries
+func (queries *Queries) ProcessWebhookEvent(
+	ctx context.Context,
+	eventID, eventType string,
+	apply func(tx *gorm.DB) error,
+) error {
+	return queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&WebhookEvent{ID: eventID, Type: eventType, ProcessedAt: time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrWebhookProcessed
+		}
+
+		return apply(tx)
+	})
+}
+
+// Record the payment of a new intent within a transaction. The same idempotency key gives back the same intent,
+// in which case the payment recorded the first time is loaded into record instead
+func RecordPayment(tx *gorm.DB, record *Payment) error {
+	result := tx.
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "intent_id"}}, DoNothing: true}).
+		Create(record)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return tx.Where("intent_id = ?", record.IntentID).First(record).Error
+	}
+	return nil
+}
+
+// Record the payment of pending bookings of an account and link each booking to it with its share
+// of the amount, in a single transaction. Return ErrBookingNotPending if any of the bookings has been released,
+// its hold has expired or it has been linked to another payment since it was checked
+func (queries *Queries) SetBookingPayment(
+	ctx context.Context,
+	accountID uint,
+	bookings []Booking,
+	record *Payment,
+	now time.Time,
+) error {
+	return queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := RecordPayment(tx, record); err != nil {
+			return err
+		}
+
+		for i := range bookings {
+			booking := &bookings[i]
+			result := tx.
+				Model(&Booking{}).
+				Where("id = ? AND account_id = ? AND status = ? AND hold_expires_at > ?",
+					booking.ID, accountID, Pending, now).
+				Where("payment_id IS NULL OR payment_id = ?", record.ID).
+				Updates(map[string]any{"amount": booking.Amount, "payment_id": record.ID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrBookingNotPending
+			}
+			booking.PaymentID = &record.ID
+		}
+		return nil
+	})
+}
+
+// Get the unsettled payments among the given ones that nothing can be bought with anymore: none of their bookings
+// are still pending and no listing is held for them. Their intents should be canceled, so a late payment
+// cannot charge the buyer for tickets they will not get. Failed payments are included, since the buyer
+// can still retry them with another payment method
+func (queries *Queries) AbandonedPayments(ctx context.Context, ids []uint, now time.Time) ([]Payment, error) {
+	var payments []Payment
+	if len(ids) == 0 {
+		return payments, nil
+	}
+
+	err := queries.DB.WithContext(ctx).
+		Where("id IN ? AND status IN ?", ids, []PaymentStatus{PaymentPending, PaymentFailed}).
+		Where("NOT EXISTS (SELECT 1 FROM bookings WHERE bookings.payment_id = payments.id "+
+			"AND bookings.status = ? AND bookings.deleted_at IS NULL)", Pending).
+		Where("NOT EXISTS (SELECT 1 FROM resale_listings WHERE resale_listings.payment_id = payments.id "+
+			"AND resale_listings.status = ? AND resale_listings.hold_expires_at > ? "+
+			"AND resale_listings.deleted_at IS NULL)", ListingReserved, now).
+		Order("id").
+		Find(&payments).Error
+	return payments, err
+}
+
+// Mark pending bookings of an account as valid within a transaction. Bookings that are no longer pending,
+// for example released because the hold expired, are skipped. Return the bookings that have been confirmed
+func ConfirmBookings(tx *gorm.DB, accountID uint, ids []uint) ([]Booking, error) {
+	var confirmed []Booking
+	if len(ids) == 0 {
+		return confirmed, nil
+	}
+
+	result := tx.
+		Model(&confirmed).
+		Clauses(clause.Returning{}).
+		Where("id IN ? AND account_id = ? AND status = ?", ids, accountID, Pending).
+		Updates(map[string]any{"status": Valid, "hold_expires_at": nil})
+	return confirmed, result.Error
+}
+
+// Get the discount of the highest membership tier an account with this many points belongs to.
+// Return 0 if the account has not reached any tier
+func MembershipDiscount(tx *gorm.DB, point uint) (float64, error) {
+	var memberships []Membership
+	result := tx.
+		Where("base_point <= ?", point).
+		Order("base_point DESC").
+		Limit(1).
+		Find(&memberships)
+	if result.Error != nil || len(memberships) == 0 {
+		return 0, result.Error
+	}
+	return memberships[0].Discount, nil
+}
+
+// Find a promo code that can be used now for tickets of an event. Return ErrPromoCodeInvalid if the code does not
+// exist, has expired, has been used up or belongs to another event
+func FindPromoCode(tx *gorm.DB, code string, eventID uint, now time.Time) (PromoCode, error) {
+	var promo PromoCode
+	err := tx.
+		Where("code = ?", code).
+		Where("event_id IS NULL OR event_id = ?", eventID).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where("max_uses = 0 OR used < max_uses").
+		First(&promo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return promo, ErrPromoCodeInvalid
+	}
+	return promo, err
+}
+
+// Get the amount paid for a booking, along with its payment. Each booking knows its own share of the payment,
+// which stays right when other bookings of the same payment are resold or refunded
+func BookingPaidAmount(tx *gorm.DB, booking Booking) (int64, Payment, error) {
+	var record Payment
+	if booking.PaymentID == nil {
+		return 0, record, gorm.ErrRecordNotFound
+	}
+	if err := tx.First(&record, *booking.PaymentID).Error; err != nil {
+		return 0, record, err
+	}
+	return booking.Amount, record, nil
+}
+
+// Give tickets back to a tier within a transaction, after bookings of it have been refunded
+func ReturnTickets(tx *gorm.DB, ticketID uint, count uint) error {
+	return tx.
+		Model(&Ticket{}).
+		Where("id = ?", ticketID).
+		Update("available", gorm.Expr("available + ?", count)).Error
+}
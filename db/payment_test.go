@@ -0,0 +1,105 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Helper function: reserve tickets of a new tier and start a single payment for all of them
+func createTestPayment(t *testing.T, quantity uint) (Payment, []Booking) {
+	suffix := time.Now().UnixNano()
+	account := Account{
+		Username: fmt.Sprintf("payment-test-%d", suffix),
+		Email:    fmt.Sprintf("payment-test-%d@example.com", suffix),
+		Status:   Active,
+		Role:     User,
+	}
+	require.NoError(t, queries.DB.Create(&account).Error)
+
+	event := Event{
+		HostID:      account.ID,
+		Name:        "Payment test",
+		Description: "Payment test",
+		Location:    "Payment test",
+		StartTime:   time.Now().Add(time.Hour * 24),
+		EndTime:     time.Now().Add(time.Hour * 26),
+		Status:      Published,
+	}
+	require.NoError(t, queries.DB.Create(&event).Error)
+
+	ticket := Ticket{EventID: event.ID, Rank: "standard", Total: quantity, Available: quantity, Price: 10, Status: Published}
+	require.NoError(t, queries.DB.Create(&ticket).Error)
+
+	bookings, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, quantity, nil, time.Minute)
+	require.NoError(t, err)
+	record := Payment{
+		AccountID: account.ID,
+		IntentID:  fmt.Sprintf("pi_payment_test_%d", suffix),
+		Amount:    int64(quantity) * 1000,
+		Currency:  "usd",
+		Status:    PaymentPending,
+	}
+	require.NoError(t, queries.DB.Create(&record).Error)
+	for i := range bookings {
+		bookings[i].PaymentID = &record.ID
+		require.NoError(t, queries.DB.Save(&bookings[i]).Error)
+	}
+	return record, bookings
+}
+
+func TestAbandonedPayments(t *testing.T) {
+	connectStores(t)
+
+	record, bookings := createTestPayment(t, 2)
+
+	// The payment can still pay for the other booking
+	_, err := queries.ReleaseBookings(t.Context(), []uint{bookings[0].ID})
+	require.NoError(t, err)
+	abandoned, err := queries.AbandonedPayments(t.Context(), []uint{record.ID}, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, abandoned)
+
+	_, err = queries.ReleaseBookings(t.Context(), []uint{bookings[1].ID})
+	require.NoError(t, err)
+	abandoned, err = queries.AbandonedPayments(t.Context(), []uint{record.ID}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, abandoned, 1)
+
+	// Settled payments are left alone
+	require.NoError(t, queries.DB.Model(&record).Update("status", PaymentSucceeded).Error)
+	abandoned, err = queries.AbandonedPayments(t.Context(), []uint{record.ID}, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, abandoned)
+}
+
+func TestSetBookingPayment(t *testing.T) {
+	connectStores(t)
+
+	record, bookings := createTestPayment(t, 2)
+
+	// Recording the same intent again keeps the bookings linked to the same payment
+	again := Payment{
+		AccountID: record.AccountID,
+		IntentID:  record.IntentID,
+		Amount:    record.Amount,
+		Currency:  record.Currency,
+		Status:    PaymentPending,
+	}
+	require.NoError(t, queries.SetBookingPayment(t.Context(), record.AccountID, bookings, &again, time.Now()))
+	require.Equal(t, record.ID, again.ID)
+
+	// Another intent cannot take over the bookings, and neither can anyone once they are released
+	other := again
+	other.ID = 0
+	other.IntentID = fmt.Sprintf("pi_payment_other_%d", record.ID)
+	err := queries.SetBookingPayment(t.Context(), record.AccountID, bookings, &other, time.Now())
+	require.ErrorIs(t, err, ErrBookingNotPending)
+
+	_, err = queries.ReleaseBookings(t.Context(), []uint{bookings[0].ID})
+	require.NoError(t, err)
+	err = queries.SetBookingPayment(t.Context(), record.AccountID, bookings, &again, time.Now())
+	require.ErrorIs(t, err, ErrBookingNotPending)
+}
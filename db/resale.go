@@ -0,0 +1,297 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrResaleClosed         = errors.New("tickets of this event cannot be resold")
+	ErrBookingListed        = errors.New("booking is on sale")
+	ErrListingUnavailable   = errors.New("listing is no longer available")
+	ErrBuyOwnListing        = errors.New("cannot buy your own listing")
+	ErrListingNotCancelable = errors.New("listing is being paid for or has been closed")
+)
+
+// Returned when a ticket is put on sale above the resale cap of its event
+type PriceAboveCapError struct {
+	Cap int64
+}
+
+func (err *PriceAboveCapError) Error() string {
+	return fmt.Sprintf("price exceeds the resale cap of %d cents", err.Cap)
+}
+
+// Put a paid booking of an account on sale. The price and fee are counted in cents, the price cannot exceed
+// the resale cap of the event. Resale follows the rules of transfers, since the booking changes hands
+func (queries *Queries) CreateListing(
+	ctx context.Context,
+	bookingID, sellerID uint,
+	price, fee int64,
+	currency string,
+	cutoff time.Duration,
+) (ResaleListing, error) {
+	var listing ResaleListing
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		booking, err := lockTransferableBooking(tx, bookingID, sellerID, cutoff)
+		if err != nil {
+			return err
+		}
+		capPercent := booking.Ticket.Event.ResaleCapPercent
+		if capPercent == 0 {
+			return ErrResaleClosed
+		}
+		if priceCap := booking.Ticket.ResalePriceCap(capPercent); price > priceCap {
+			return &PriceAboveCapError{Cap: priceCap}
+		}
+
+		if err := checkBookingNotListed(tx, booking.ID); err != nil {
+			return err
+		}
+		var pending int64
+		result := tx.
+			Model(&TicketTransfer{}).
+			Where("booking_id = ? AND status = ?", booking.ID, TransferPending).
+			Count(&pending)
+		if result.Error != nil {
+			return result.Error
+		}
+		if pending > 0 {
+			return ErrTransferPending
+		}
+
+		listing = ResaleListing{
+			BookingID: booking.ID,
+			SellerID:  sellerID,
+			Price:     price,
+			Fee:       fee,
+			Currency:  currency,
+			Status:    ListingActive,
+		}
+		if err := tx.Create(&listing).Error; err != nil {
+			return err
+		}
+		listing.Booking = booking
+		return nil
+	})
+	return listing, err
+}
+
+// Take a listing off sale. A listing cannot be canceled while a buyer holds it
+func (queries *Queries) CancelListing(ctx context.Context, listingID, sellerID uint) (ResaleListing, error) {
+	var listing ResaleListing
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Booking.Ticket").
+			Where("seller_id = ?", sellerID).
+			First(&listing, listingID).Error
+		if err != nil {
+			return err
+		}
+		if !listing.Available(time.Now()) {
+			return ErrListingNotCancelable
+		}
+
+		listing.Status = ListingCanceled
+		return tx.Model(&listing).Updates(map[string]any{
+			"status":          listing.Status,
+			"buyer_id":        nil,
+			"hold_expires_at": nil,
+			"payment_id":      nil,
+		}).Error
+	})
+	return listing, err
+}
+
+// Hold a listing for a buyer while they pay for it. The payment is started once the hold is committed,
+// then stored with SetListingPayment. Calling it again while the buyer still holds the listing keeps the
+// same hold, so the payment can be started again with the same idempotency key. When an expired hold
+// is taken over, its payment is returned, so its intent can be canceled before it is paid late
+func (queries *Queries) ReserveListing(
+	ctx context.Context,
+	listingID, buyerID uint,
+	hold, cutoff time.Duration,
+) (ResaleListing, *uint, error) {
+	var (
+		listing  ResaleListing
+		replaced *uint
+	)
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&listing, listingID).Error
+		if err != nil {
+			return err
+		}
+		if listing.SellerID == buyerID {
+			return ErrBuyOwnListing
+		}
+
+		now := time.Now()
+		held := listing.Status == ListingReserved && listing.HoldExpiresAt.Time.After(now) &&
+			listing.BuyerID != nil && *listing.BuyerID == buyerID
+		if !held && !listing.Available(now) {
+			return ErrListingUnavailable
+		}
+
+		// The booking may have been used or the event closed since it has been listed
+		booking, err := lockTransferableBooking(tx, listing.BookingID, listing.SellerID, cutoff)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrListingUnavailable
+			}
+			return err
+		}
+		listing.Booking = booking
+
+		if !held {
+			if listing.PaymentID != nil {
+				replaced = listing.PaymentID
+				listing.PaymentID = nil
+			}
+			listing.Status = ListingReserved
+			listing.BuyerID = &buyerID
+			// Stored to the microsecond, so SetListingPayment can match the hold exactly
+			listing.HoldExpiresAt = sql.NullTime{Time: now.Add(hold).Truncate(time.Microsecond), Valid: true}
+		}
+		return tx.Model(&listing).Updates(map[string]any{
+			"status":          listing.Status,
+			"buyer_id":        listing.BuyerID,
+			"hold_expires_at": listing.HoldExpiresAt,
+			"payment_id":      listing.PaymentID,
+		}).Error
+	})
+	return listing, replaced, err
+}
+
+// Record the payment started for a hold returned by ReserveListing and link the listing to it.
+// Return ErrListingUnavailable if the hold has expired or been taken over in the meantime,
+// in which case nothing is recorded and the intent should be canceled
+func (queries *Queries) SetListingPayment(ctx context.Context, listing *ResaleListing, record *Payment) error {
+	return queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := RecordPayment(tx, record); err != nil {
+			return err
+		}
+
+		result := tx.
+			Model(&ResaleListing{}).
+			Where("id = ? AND status = ? AND buyer_id = ?", listing.ID, ListingReserved, listing.BuyerID).
+			Where("hold_expires_at = ? AND hold_expires_at > ?", listing.HoldExpiresAt, time.Now()).
+			Update("payment_id", record.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrListingUnavailable
+		}
+
+		listing.PaymentID = &record.ID
+		return nil
+	})
+}
+
+// Complete the sale of a listing paid by a payment within a transaction. The booking moves to the buyer with a new
+// ticket version, so the QR code of the seller stops working, and the seller is credited the price minus the fee.
+// Return ErrListingUnavailable if the listing is no longer held for this payment, or ErrBookingNotValid if the
+// booking can no longer be sold. In both cases nothing is changed and the payment must be refunded
+func CompleteResale(tx *gorm.DB, listingID, paymentID uint, intentID string) (ResaleListing, error) {
+	var listing ResaleListing
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("status = ? AND payment_id = ?", ListingReserved, paymentID).
+		First(&listing, listingID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return listing, ErrListingUnavailable
+		}
+		return listing, err
+	}
+
+	var booking Booking
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("account_id = ? AND status = ?", listing.SellerID, Valid).
+		First(&booking, listing.BookingID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return listing, ErrBookingNotValid
+		}
+		return listing, err
+	}
+
+	// The booking is now paid by the buyer, so a refund goes back to them
+	err = tx.Model(&booking).Updates(map[string]any{
+		"account_id":     *listing.BuyerID,
+		"ticket_version": gorm.Expr("ticket_version + 1"),
+		"payment_id":     paymentID,
+		"amount":         listing.Price,
+	}).Error
+	if err != nil {
+		return listing, err
+	}
+	booking.AccountID = *listing.BuyerID
+	booking.TicketVersion++
+	booking.PaymentID = &paymentID
+	booking.Amount = listing.Price
+
+	listing.Status = ListingSold
+	listing.SoldAt = sql.NullTime{Time: time.Now(), Valid: true}
+	err = tx.Model(&listing).Updates(map[string]any{"status": listing.Status, "sold_at": listing.SoldAt}).Error
+	if err != nil {
+		return listing, err
+	}
+	listing.Booking = booking
+
+	if credit := listing.Price - listing.Fee; credit > 0 {
+		err := tx.Create(&LedgerEntry{
+			AccountID: listing.SellerID,
+			Type:      LedgerResale,
+			Amount:    credit,
+			Currency:  listing.Currency,
+			PaymentID: &paymentID,
+			BookingID: &booking.ID,
+			Reference: intentID,
+		}).Error
+		if err != nil {
+			return listing, err
+		}
+	}
+	return listing, nil
+}
+
+// Check if a listing can be bought: it is on sale, or the hold of its last buyer has expired
+func (listing *ResaleListing) Available(now time.Time) bool {
+	return listing.Status == ListingActive ||
+		(listing.Status == ListingReserved && !listing.HoldExpiresAt.Time.After(now))
+}
+
+// Scope of the listings that can be bought, see ResaleListing.Available
+func AvailableListings(now time.Time) func(tx *gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("resale_listings.status = ? OR (resale_listings.status = ? AND resale_listings.hold_expires_at <= ?)",
+			ListingActive, ListingReserved, now)
+	}
+}
+
+// Helper function: check that a booking is not on sale, so it cannot change hands in two ways at once
+func checkBookingNotListed(tx *gorm.DB, bookingID uint) error {
+	var open int64
+	result := tx.
+		Model(&ResaleListing{}).
+		Where("booking_id = ? AND status IN ?", bookingID, []ListingStatus{ListingActive, ListingReserved}).
+		Count(&open)
+	if result.Error != nil {
+		return result.Error
+	}
+	if open > 0 {
+		return ErrBookingListed
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Helper function: create a published event with tickets at 10.00 each, paid by the seller with a single payment,
+// and an account to buy them
+func createResaleBookings(t *testing.T, quantity uint) (Account, Account, Ticket, []Booking) {
+	suffix := time.Now().UnixNano()
+	accounts := make([]Account, 2)
+	for i := range accounts {
+		accounts[i] = Account{
+			Username: fmt.Sprintf("resale-test-%d-%d", suffix, i),
+			Email:    fmt.Sprintf("resale-test-%d-%d@example.com", suffix, i),
+			Status:   Active,
+			Role:     User,
+		}
+		require.NoError(t, queries.DB.Create(&accounts[i]).Error)
+	}
+
+	event := Event{
+		HostID:      accounts[0].ID,
+		Name:        "Resale test",
+		Description: "Resale test",
+		Location:    "Resale test",
+		StartTime:   time.Now().Add(time.Hour * 24),
+		EndTime:     time.Now().Add(time.Hour * 26),
+		Status:      Published,
+	}
+	require.NoError(t, queries.DB.Create(&event).Error)
+
+	ticket := Ticket{
+		EventID:   event.ID,
+		Rank:      "standard",
+		Total:     quantity,
+		Available: quantity,
+		Price:     10,
+		Status:    Published,
+	}
+	require.NoError(t, queries.DB.Create(&ticket).Error)
+
+	bookings, err := queries.ReserveTickets(t.Context(), accounts[0].ID, ticket.ID, quantity, nil, time.Minute)
+	require.NoError(t, err)
+	record := Payment{
+		AccountID: accounts[0].ID,
+		IntentID:  fmt.Sprintf("pi_resale_seller_%d", suffix),
+		Amount:    int64(quantity) * 1000,
+		Currency:  "usd",
+		Status:    PaymentSucceeded,
+	}
+	require.NoError(t, queries.DB.Create(&record).Error)
+	for i := range bookings {
+		err := queries.DB.Model(&bookings[i]).
+			Updates(map[string]any{"status": Valid, "payment_id": record.ID, "amount": 1000}).Error
+		require.NoError(t, err)
+	}
+	return accounts[0], accounts[1], ticket, bookings
+}
+
+func TestResaleListing(t *testing.T) {
+	connectStores(t)
+
+	seller, buyer, ticket, bookings := createResaleBookings(t, 1)
+	booking := bookings[0]
+
+	// The face price is 10.00, so the default cap of 110% allows up to 11.00
+	_, err := queries.CreateListing(t.Context(), booking.ID, seller.ID, 1101, 110, "usd", time.Hour)
+	var priceErr *PriceAboveCapError
+	require.ErrorAs(t, err, &priceErr)
+	require.Equal(t, int64(1100), priceErr.Cap)
+
+	listing, err := queries.CreateListing(t.Context(), booking.ID, seller.ID, 1100, 110, "usd", time.Hour)
+	require.NoError(t, err)
+	_, err = queries.CreateListing(t.Context(), booking.ID, seller.ID, 1000, 100, "usd", time.Hour)
+	require.ErrorIs(t, err, ErrBookingListed)
+	_, err = queries.CreateTransfer(t.Context(), booking.ID, seller.ID, buyer.ID, time.Hour)
+	require.ErrorIs(t, err, ErrBookingListed)
+
+	// The buyer holds the listing while paying, so the seller can no longer take it off sale
+	_, _, err = queries.ReserveListing(t.Context(), listing.ID, seller.ID, time.Minute, time.Hour)
+	require.ErrorIs(t, err, ErrBuyOwnListing)
+	listing, _, err = queries.ReserveListing(t.Context(), listing.ID, buyer.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, ListingReserved, listing.Status)
+
+	// The payment is only linked to the hold it has been started for, and recording it twice gives the same payment
+	record := Payment{
+		AccountID: buyer.ID,
+		IntentID:  fmt.Sprintf("pi_resale_%d", listing.ID),
+		Amount:    listing.Price,
+		Currency:  "usd",
+		Status:    PaymentPending,
+	}
+	stale, lost := listing, record
+	stale.HoldExpiresAt.Time = stale.HoldExpiresAt.Time.Add(-time.Second)
+	require.ErrorIs(t, queries.SetListingPayment(t.Context(), &stale, &lost), ErrListingUnavailable)
+	again := record
+	require.NoError(t, queries.SetListingPayment(t.Context(), &listing, &record))
+	require.NoError(t, queries.SetListingPayment(t.Context(), &listing, &again))
+	require.Equal(t, record.ID, again.ID)
+	_, err = queries.CancelListing(t.Context(), listing.ID, seller.ID)
+	require.ErrorIs(t, err, ErrListingNotCancelable)
+
+	// The payment moves the booking to the buyer with a new ticket version and credits the seller minus the fee
+	var sold ResaleListing
+	err = queries.DB.Transaction(func(tx *gorm.DB) error {
+		sold, err = CompleteResale(tx, listing.ID, record.ID, record.IntentID)
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, ListingSold, sold.Status)
+	require.Equal(t, buyer.ID, sold.Booking.AccountID)
+	require.Equal(t, booking.TicketVersion+1, sold.Booking.TicketVersion)
+
+	var credit LedgerEntry
+	require.NoError(t, queries.DB.Where("account_id = ? AND type = ?", seller.ID, LedgerResale).First(&credit).Error)
+	require.Equal(t, int64(990), credit.Amount)
+
+	_, err = queries.CheckIn(t.Context(), booking.ID, ticket.EventID, booking.TicketVersion, seller.ID)
+	require.ErrorIs(t, err, ErrTicketCodeRevoked)
+}
+
+func TestResoldBookingPaidAmount(t *testing.T) {
+	connectStores(t)
+
+	seller, buyer, _, bookings := createResaleBookings(t, 3)
+
+	// One ticket of the payment is resold above its face price
+	listing, err := queries.CreateListing(t.Context(), bookings[0].ID, seller.ID, 1100, 110, "usd", time.Hour)
+	require.NoError(t, err)
+	listing, _, err = queries.ReserveListing(t.Context(), listing.ID, buyer.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+	record := Payment{
+		AccountID: buyer.ID,
+		IntentID:  fmt.Sprintf("pi_resale_%d", listing.ID),
+		Amount:    listing.Price,
+		Currency:  "usd",
+		Status:    PaymentPending,
+	}
+	require.NoError(t, queries.SetListingPayment(t.Context(), &listing, &record))
+	err = queries.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := CompleteResale(tx, listing.ID, record.ID, record.IntentID)
+		return err
+	})
+	require.NoError(t, err)
+
+	// The buyer gets back what they paid, and the tickets the seller kept are still worth their own share
+	// of the original payment, not a share of what is left linked to it
+	var resold, kept Booking
+	require.NoError(t, queries.DB.First(&resold, bookings[0].ID).Error)
+	require.NoError(t, queries.DB.First(&kept, bookings[1].ID).Error)
+	paid, resalePayment, err := BookingPaidAmount(queries.DB, resold)
+	require.NoError(t, err)
+	require.Equal(t, int64(1100), paid)
+	require.Equal(t, record.ID, resalePayment.ID)
+	paid, original, err := BookingPaidAmount(queries.DB, kept)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), paid)
+	require.Equal(t, *bookings[1].PaymentID, original.ID)
+}
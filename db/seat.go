@@ -0,0 +1,100 @@
+package db
+
+import (
+	"errors"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSeatUnavailable      = errors.New("seat is not available")
+	ErrInvalidSeatSelection = errors.New("selected seats do not belong to the tier or do not match the quantity")
+)
+
+// Helper function: hold a seat of a seated tier for each new booking, copying the seat label to the booking.
+// If seat IDs are given, exactly those seats are held, otherwise the best available seats are picked.
+// Tiers without a seat map are left as is. It must run in the transaction that creates the bookings
+func assignSeats(tx *gorm.DB, ticketID uint, bookings []Booking, seatIDs []uint) error {
+	var seated int64
+	if err := tx.Model(&EventSeat{}).Where("ticket_id = ?", ticketID).Count(&seated).Error; err != nil {
+		return err
+	}
+	if seated == 0 {
+		if len(seatIDs) > 0 {
+			return ErrInvalidSeatSelection
+		}
+		return nil
+	}
+
+	// Lock only the event seats, not the layout rows joined for ordering
+	query := tx.
+		Preload("Seat").
+		Joins("JOIN seats ON seats.id = event_seats.seat_id").
+		Joins("JOIN sections ON sections.id = seats.section_id").
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "event_seats"}}).
+		Where("event_seats.ticket_id = ?", ticketID)
+
+	var seats []EventSeat
+	if len(seatIDs) > 0 {
+		// Lock in a fixed order, so concurrent selections cannot deadlock
+		ids := slices.Clone(seatIDs)
+		slices.Sort(ids)
+		ids = slices.Compact(ids)
+		if len(ids) != len(bookings) {
+			return ErrInvalidSeatSelection
+		}
+
+		if err := query.Where("event_seats.seat_id IN ?", ids).Order("event_seats.seat_id").Find(&seats).Error; err != nil {
+			return err
+		}
+		if len(seats) != len(ids) {
+			return ErrInvalidSeatSelection
+		}
+		for _, seat := range seats {
+			if seat.BookingID != nil {
+				return ErrSeatUnavailable
+			}
+		}
+	} else {
+		// Best available: the front rows of the best sections first, then from the start of the row.
+		// Seats locked by other buyers are skipped, otherwise every buyer would wait on the same front seats
+		// and then find them taken, and be told the tier is sold out while free seats are left
+		err := query.
+			Clauses(clause.Locking{
+				Strength: "UPDATE",
+				Table:    clause.Table{Name: "event_seats"},
+				Options:  "SKIP LOCKED",
+			}).
+			Where("event_seats.booking_id IS NULL").
+			Order("sections.priority, seats.row_index, seats.number").
+			Limit(len(bookings)).
+			Find(&seats).Error
+		if err != nil {
+			return err
+		}
+		if len(seats) < len(bookings) {
+			return ErrSoldOut
+		}
+	}
+
+	for i := range bookings {
+		if err := tx.Model(&seats[i]).Update("booking_id", bookings[i].ID).Error; err != nil {
+			return err
+		}
+		bookings[i].SeatNumber = seats[i].Seat.Label
+		if err := tx.Model(&bookings[i]).Update("seat_number", bookings[i].SeatNumber).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Give the seats held by bookings back to the seat map, after the bookings have been released or refunded
+func FreeSeats(tx *gorm.DB, bookingIDs []uint) error {
+	if len(bookingIDs) == 0 {
+		return nil
+	}
+	return tx.Model(&EventSeat{}).Where("booking_id IN ?", bookingIDs).Update("booking_id", nil).Error
+}
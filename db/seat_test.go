@@ -0,0 +1,139 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Helper function: create a published event whose single tier sells a row of seats of a new venue,
+// one ticket for each seat
+func createSeatedTier(t *testing.T, count int) (Account, Ticket, []Seat) {
+	suffix := time.Now().UnixNano()
+	account := Account{
+		Username: fmt.Sprintf("seat-test-%d", suffix),
+		Email:    fmt.Sprintf("seat-test-%d@example.com", suffix),
+		Status:   Active,
+		Role:     User,
+	}
+	require.NoError(t, queries.DB.Create(&account).Error)
+
+	event := Event{
+		HostID:      account.ID,
+		Name:        "Seat test",
+		Description: "Seat test",
+		Location:    "Seat test",
+		StartTime:   time.Now().Add(time.Hour * 24),
+		EndTime:     time.Now().Add(time.Hour * 26),
+		Status:      Published,
+	}
+	require.NoError(t, queries.DB.Create(&event).Error)
+
+	ticket := Ticket{
+		EventID:   event.ID,
+		Rank:      "standard",
+		Total:     uint(count),
+		Available: uint(count),
+		Price:     10,
+		Status:    Published,
+	}
+	require.NoError(t, queries.DB.Create(&ticket).Error)
+
+	venue := Venue{CreatorID: account.ID, Name: "Seat test", Address: "Seat test"}
+	section := Section{Name: "Floor"}
+	for number := 1; number <= count; number++ {
+		section.Seats = append(section.Seats, Seat{Row: "A", Number: number, Label: fmt.Sprintf("Floor A%d", number)})
+	}
+	venue.Sections = []Section{section}
+	require.NoError(t, queries.DB.Create(&venue).Error)
+
+	seats := venue.Sections[0].Seats
+	for _, seat := range seats {
+		require.NoError(t, queries.DB.Create(&EventSeat{EventID: event.ID, SeatID: seat.ID, TicketID: ticket.ID}).Error)
+	}
+	return account, ticket, seats
+}
+
+func TestReserveSeats(t *testing.T) {
+	connectStores(t)
+
+	account, ticket, seats := createSeatedTier(t, 5)
+
+	// Many buyers pick the same seat, only one should get it
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  []error
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, []uint{seats[2].ID}, time.Minute)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			errs = append(errs, err)
+		}()
+	}
+	wg.Wait()
+
+	success := 0
+	for _, err := range errs {
+		if err == nil {
+			success++
+			continue
+		}
+		require.ErrorIs(t, err, ErrSeatUnavailable)
+	}
+	require.Equal(t, 1, success)
+
+	// Best available starts from the front of the row and skips the taken seat
+	bookings, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 3, nil, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "Floor A1", bookings[0].SeatNumber)
+	require.Equal(t, "Floor A2", bookings[1].SeatNumber)
+	require.Equal(t, "Floor A4", bookings[2].SeatNumber)
+
+	// Seats outside of the tier are rejected
+	_, err = queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, []uint{seats[4].ID + 1000000}, time.Minute)
+	require.ErrorIs(t, err, ErrInvalidSeatSelection)
+
+	// Released seats can be picked again
+	_, err = queries.ReleaseBookings(t.Context(), []uint{bookings[0].ID})
+	require.NoError(t, err)
+	again, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, []uint{seats[0].ID}, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "Floor A1", again[0].SeatNumber)
+
+	// As many buyers as seats pick the best available at once, none of them should be told the tier
+	// is sold out or get a taken seat
+	const total = 30
+	account, ticket, _ = createSeatedTier(t, total)
+	labels := map[string]bool{}
+	errs = nil
+	for range total {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bookings, err := queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, nil, time.Minute)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			labels[bookings[0].SeatNumber] = true
+		}()
+	}
+	wg.Wait()
+	require.Empty(t, errs)
+	require.Len(t, labels, total)
+
+	_, err = queries.ReserveTickets(t.Context(), account.ID, ticket.ID, 1, nil, time.Minute)
+	require.ErrorIs(t, err, ErrSoldOut)
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// A login session of an account on one device. Each session owns exactly one active refresh token at a time,
+// which is rotated every time the session is refreshed
+type Session struct {
+	ID               string    `json:"id"`
+	AccountID        uint      `json:"account_id"`
+	DeviceName       string    `json:"device_name"`
+	IP               string    `json:"ip"`
+	LastSeen         time.Time `json:"last_seen"`
+	IssuedAt         time.Time `json:"issued_at"`
+	RefreshTokenHash string    `json:"refresh_token_hash"`
+}
+
+// Cache key of a session
+func SessionKey(accountID uint, sessionID string) string {
+	return fmt.Sprintf("session:%d:%s", accountID, sessionID)
+}
+
+// Cache key of the set of session IDs of an account
+func SessionSetKey(accountID uint) string {
+	return fmt.Sprintf("sessions:%d", accountID)
+}
+
+// Create or update a session. The session will be removed if it is not refreshed before expired
+func (queries *Queries) SaveSession(ctx context.Context, session Session, expired time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	setKey := SessionSetKey(session.AccountID)
+	_, err = queries.Cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, SessionKey(session.AccountID, session.ID), data, expired)
+		pipe.SAdd(ctx, setKey, session.ID)
+		pipe.Expire(ctx, setKey, expired)
+		return nil
+	})
+	return err
+}
+
+// Get a session of an account
+func (queries *Queries) GetSession(ctx context.Context, accountID uint, sessionID string) (Session, error) {
+	var session Session
+	data, err := queries.Cache.Get(ctx, SessionKey(accountID, sessionID)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return session, ErrSessionNotFound
+		}
+		return session, err
+	}
+
+	err = json.Unmarshal(data, &session)
+	return session, err
+}
+
+// List all the sessions of an account. Expired sessions are removed from the session set along the way
+func (queries *Queries) ListSessions(ctx context.Context, accountID uint) ([]Session, error) {
+	setKey := SessionSetKey(accountID)
+	ids, err := queries.Cache.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, id := range ids {
+		session, err := queries.GetSession(ctx, accountID, id)
+		if err != nil {
+			if errors.Is(err, ErrSessionNotFound) {
+				queries.Cache.SRem(ctx, setKey, id)
+				continue
+			}
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// Delete a session and its current refresh token, so the session can no longer be refreshed
+func (queries *Queries) DeleteSession(ctx context.Context, accountID uint, sessionID string) error {
+	session, err := queries.GetSession(ctx, accountID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = queries.Cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, SessionKey(accountID, sessionID), RefreshTokenKey(session.RefreshTokenHash))
+		pipe.SRem(ctx, SessionSetKey(accountID), sessionID)
+		return nil
+	})
+	return err
+}
+
+// Delete all sessions of an account
+func (queries *Queries) DeleteAllSessions(ctx context.Context, accountID uint) error {
+	sessions, err := queries.ListSessions(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{SessionSetKey(accountID)}
+	for _, session := range sessions {
+		keys = append(keys, SessionKey(accountID, session.ID), RefreshTokenKey(session.RefreshTokenHash))
+	}
+
+	return queries.Cache.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteSessions(t *testing.T) {
+	connectStores(t)
+
+	account := createTestAccount(t)
+	other := createTestAccount(t)
+	first := createTestSession(t, account)
+	second := createTestSession(t, account)
+	third := createTestSession(t, account)
+	kept := createTestSession(t, other)
+
+	sessions, err := queries.ListSessions(t.Context(), account.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 3)
+
+	// Logging out of one device removes its session and refresh token only
+	require.NoError(t, queries.DeleteSession(t.Context(), account.ID, first.ID))
+	_, err = queries.GetSession(t.Context(), account.ID, first.ID)
+	require.ErrorIs(t, err, ErrSessionNotFound)
+	_, err = queries.UseRefreshToken(t.Context(), first.RefreshTokenHash)
+	require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	require.ErrorIs(t, queries.DeleteSession(t.Context(), account.ID, first.ID), ErrSessionNotFound)
+
+	sessions, err = queries.ListSessions(t.Context(), account.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	// A session cannot be deleted through another account
+	require.ErrorIs(t, queries.DeleteSession(t.Context(), other.ID, second.ID), ErrSessionNotFound)
+
+	// Logging out everywhere removes the remaining sessions, but not those of other accounts
+	require.NoError(t, queries.DeleteAllSessions(t.Context(), account.ID))
+	sessions, err = queries.ListSessions(t.Context(), account.ID)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+	for _, session := range []Session{second, third} {
+		_, err = queries.UseRefreshToken(t.Context(), session.RefreshTokenHash)
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	}
+
+	sessions, err = queries.ListSessions(t.Context(), other.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	sessionID, err := queries.UseRefreshToken(t.Context(), kept.RefreshTokenHash)
+	require.NoError(t, err)
+	require.Equal(t, kept.ID, sessionID)
+}
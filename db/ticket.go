@@ -0,0 +1,32 @@
+package db
+
+import (
+	"errors"
+	"math"
+)
+
+var ErrTierBelowSold = errors.New("total cannot be less than the number of sold tickets")
+
+// Number of tickets of this tier that have been sold or are being held by pending bookings
+func (ticket *Ticket) Sold() uint {
+	return ticket.Total - ticket.Available
+}
+
+// Change the total tickets of this tier, keeping the sold count unchanged
+func (ticket *Ticket) Resize(total uint) error {
+	sold := ticket.Sold()
+	if total < sold {
+		return ErrTierBelowSold
+	}
+
+	ticket.Total = total
+	ticket.Available = total - sold
+	return nil
+}
+
+// Highest price this ticket can be resold for, in the smallest currency unit (cents), given the resale cap
+// of its event as a percentage of the face price. It is rounded down so the cap is never exceeded
+func (ticket *Ticket) ResalePriceCap(capPercent uint) int64 {
+	price := int64(math.Round(ticket.Price * 100))
+	return price * int64(capPercent) / 100
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketResize(t *testing.T) {
+	ticket := Ticket{Total: 100, Available: 70}
+	require.Equal(t, uint(30), ticket.Sold())
+
+	// Grow: the new tickets are available
+	require.NoError(t, ticket.Resize(150))
+	require.Equal(t, uint(150), ticket.Total)
+	require.Equal(t, uint(120), ticket.Available)
+
+	// Shrink down to exactly the sold count
+	require.NoError(t, ticket.Resize(30))
+	require.Equal(t, uint(0), ticket.Available)
+
+	// Shrink below the sold count is rejected and nothing changes
+	require.ErrorIs(t, ticket.Resize(29), ErrTierBelowSold)
+	require.Equal(t, uint(30), ticket.Total)
+	require.Equal(t, uint(0), ticket.Available)
+}
+
+func TestTicketResalePriceCap(t *testing.T) {
+	ticket := Ticket{Price: 19.99}
+	require.Equal(t, int64(2198), ticket.ResalePriceCap(110))
+	require.Equal(t, int64(1999), ticket.ResalePriceCap(100))
+
+	// A zero cap closes the resale market
+	require.Equal(t, int64(0), ticket.ResalePriceCap(0))
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// The cache value of a used refresh token. An active refresh token holds the ID of its session instead
+const refreshTokenUsed = "used"
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used")
+	ErrResetTokenNotFound   = errors.New("reset token not found")
+)
+
+// Cache key of the token version of an account
+func TokenVersionKey(id uint) string {
+	return fmt.Sprintf("token-version:%d", id)
+}
+
+// Cache key of a refresh token. We only store the hash of the token, never the token itself
+func RefreshTokenKey(tokenHash string) string {
+	return fmt.Sprintf("refresh-token:%s", tokenHash)
+}
+
+// Cache key of a password reset token. Like refresh token, we only store the hash
+func ResetTokenKey(tokenHash string) string {
+	return fmt.Sprintf("reset-password:%s", tokenHash)
+}
+
+// Cache key of the latest password reset token of an account
+func AccountResetTokenKey(accountID uint) string {
+	return fmt.Sprintf("reset-password-account:%d", accountID)
+}
+
+// Store a password reset token of an account. Only the latest token of an account is valid,
+// so requesting a new one invalidates the ones sent before
+func (queries *Queries) StoreResetToken(ctx context.Context, accountID uint, tokenHash string, expired time.Duration) error {
+	_, err := queries.Cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, ResetTokenKey(tokenHash), accountID, expired)
+		pipe.Set(ctx, AccountResetTokenKey(accountID), tokenHash, expired)
+		return nil
+	})
+	return err
+}
+
+// Consume a password reset token and return the ID of its account. The token is deleted when read,
+// so it can only be used once. Return ErrResetTokenNotFound if the token is unknown, expired or superseded
+func (queries *Queries) UseResetToken(ctx context.Context, tokenHash string) (uint, error) {
+	accountID, err := queries.Cache.GetDel(ctx, ResetTokenKey(tokenHash)).Uint64()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, ErrResetTokenNotFound
+		}
+		return 0, err
+	}
+
+	// Check if this is still the latest token of the account
+	key := AccountResetTokenKey(uint(accountID))
+	latest, err := queries.Cache.Get(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	if latest != tokenHash {
+		return 0, ErrResetTokenNotFound
+	}
+
+	return uint(accountID), queries.Cache.Del(ctx, key).Err()
+}
+
+// Store a newly issued refresh token of a session, which can then be exchanged exactly once
+func (queries *Queries) StoreRefreshToken(
+	ctx context.Context,
+	tokenHash string,
+	sessionID string,
+	expired time.Duration,
+) error {
+	return queries.Cache.Set(ctx, RefreshTokenKey(tokenHash), sessionID, expired).Err()
+}
+
+// Mark a refresh token as used and return the ID of the session it belongs to. The check and the update
+// happen in a single Redis command, so two concurrent requests with the same token cannot both succeed.
+// Return ErrRefreshTokenReused if the token has been used before, or ErrRefreshTokenNotFound if the
+// token was never issued, has expired or its session has been deleted
+func (queries *Queries) UseRefreshToken(ctx context.Context, tokenHash string) (string, error) {
+	old, err := queries.Cache.SetArgs(ctx, RefreshTokenKey(tokenHash), refreshTokenUsed, redis.SetArgs{
+		Mode:    "XX",
+		Get:     true,
+		KeepTTL: true,
+	}).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", ErrRefreshTokenNotFound
+		}
+		return "", err
+	}
+
+	if old == refreshTokenUsed {
+		return "", ErrRefreshTokenReused
+	}
+	return old, nil
+}
+
+// Cache the token version of an account, unless a newer version is already cached. A request that read
+// the version from the database just before it was bumped would otherwise bring back the old version
+var cacheTokenVersionScript = redis.NewScript(`
+local cached = redis.call('GET', KEYS[1])
+if cached and tonumber(cached) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+return 1
+`)
+
+// Cache the token version of an account for an hour, without ever replacing a newer version
+func (queries *Queries) CacheTokenVersion(ctx context.Context, id, version uint) error {
+	ttl := int64(time.Hour / time.Second)
+	return cacheTokenVersionScript.Run(ctx, queries.Cache, []string{TokenVersionKey(id)}, version, ttl).Err()
+}
+
+// Increase the token version of an account, which revokes all tokens issued before.
+// The cache is only updated once the new version is committed, so it never holds a version the database
+// does not have. If the cache cannot be updated, the error is returned with the new version
+func (queries *Queries) BumpTokenVersion(ctx context.Context, id uint) (uint, error) {
+	var version uint
+	result := queries.DB.WithContext(ctx).
+		Raw("UPDATE accounts SET token_version = token_version + 1 WHERE id = ? RETURNING token_version", id).
+		Scan(&version)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	return version, queries.CacheTokenVersion(ctx, id, version)
+}
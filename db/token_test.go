@@ -0,0 +1,121 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Helper function: create an active account
+func createTestAccount(t *testing.T) Account {
+	suffix := time.Now().UnixNano()
+	account := Account{
+		Username: fmt.Sprintf("token-test-%d", suffix),
+		Email:    fmt.Sprintf("token-test-%d@example.com", suffix),
+		Status:   Active,
+		Role:     User,
+	}
+	require.NoError(t, queries.DB.Create(&account).Error)
+	return account
+}
+
+// Helper function: save a session of an account with its refresh token
+func createTestSession(t *testing.T, account Account) Session {
+	suffix := time.Now().UnixNano()
+	session := Session{
+		ID:               fmt.Sprintf("session-%d", suffix),
+		AccountID:        account.ID,
+		RefreshTokenHash: fmt.Sprintf("hash-%d", suffix),
+	}
+	require.NoError(t, queries.SaveSession(t.Context(), session, time.Minute))
+	require.NoError(t, queries.StoreRefreshToken(t.Context(), session.RefreshTokenHash, session.ID, time.Minute))
+	return session
+}
+
+func TestUseRefreshToken(t *testing.T) {
+	connectStores(t)
+
+	account := createTestAccount(t)
+	suffix := time.Now().UnixNano()
+
+	// A token that has never been issued cannot be used, and is not stored by trying
+	_, err := queries.UseRefreshToken(t.Context(), fmt.Sprintf("unknown-%d", suffix))
+	require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	_, err = queries.UseRefreshToken(t.Context(), fmt.Sprintf("unknown-%d", suffix))
+	require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+
+	// Two sessions of the same account
+	sessions := []Session{createTestSession(t, account), createTestSession(t, account)}
+
+	// A token can be exchanged once, and keeps its expiration after being used
+	sessionID, err := queries.UseRefreshToken(t.Context(), sessions[0].RefreshTokenHash)
+	require.NoError(t, err)
+	require.Equal(t, sessions[0].ID, sessionID)
+	ttl, err := queries.Cache.TTL(t.Context(), RefreshTokenKey(sessions[0].RefreshTokenHash)).Result()
+	require.NoError(t, err)
+	require.Positive(t, ttl)
+
+	// Using it again is detected as a reuse
+	_, err = queries.UseRefreshToken(t.Context(), sessions[0].RefreshTokenHash)
+	require.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	// A reuse revokes every session of the account, so the tokens of the other sessions stop working too
+	versionBefore := account.TokenVersion
+	version, err := queries.BumpTokenVersion(t.Context(), account.ID)
+	require.NoError(t, err)
+	require.Equal(t, versionBefore+1, version)
+	cached, err := queries.Cache.Get(t.Context(), TokenVersionKey(account.ID)).Uint64()
+	require.NoError(t, err)
+	require.Equal(t, uint64(version), cached)
+
+	// A version read from the database before the bump cannot replace the new one in the cache
+	require.NoError(t, queries.CacheTokenVersion(t.Context(), account.ID, versionBefore))
+	cached, err = queries.Cache.Get(t.Context(), TokenVersionKey(account.ID)).Uint64()
+	require.NoError(t, err)
+	require.Equal(t, uint64(version), cached)
+
+	require.NoError(t, queries.DeleteAllSessions(t.Context(), account.ID))
+	left, err := queries.ListSessions(t.Context(), account.ID)
+	require.NoError(t, err)
+	require.Empty(t, left)
+	_, err = queries.UseRefreshToken(t.Context(), sessions[1].RefreshTokenHash)
+	require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+}
+
+func TestUseResetToken(t *testing.T) {
+	connectStores(t)
+
+	account := createTestAccount(t)
+	suffix := time.Now().UnixNano()
+	first := fmt.Sprintf("reset-%d-1", suffix)
+	second := fmt.Sprintf("reset-%d-2", suffix)
+
+	// A token can only be used once
+	require.NoError(t, queries.StoreResetToken(t.Context(), account.ID, first, time.Minute))
+	accountID, err := queries.UseResetToken(t.Context(), first)
+	require.NoError(t, err)
+	require.Equal(t, account.ID, accountID)
+	_, err = queries.UseResetToken(t.Context(), first)
+	require.ErrorIs(t, err, ErrResetTokenNotFound)
+
+	// Only the latest token of an account works, asking again invalidates the ones sent before
+	require.NoError(t, queries.StoreResetToken(t.Context(), account.ID, first, time.Minute))
+	require.NoError(t, queries.StoreResetToken(t.Context(), account.ID, second, time.Minute))
+	_, err = queries.UseResetToken(t.Context(), first)
+	require.ErrorIs(t, err, ErrResetTokenNotFound)
+	accountID, err = queries.UseResetToken(t.Context(), second)
+	require.NoError(t, err)
+	require.Equal(t, account.ID, accountID)
+	_, err = queries.UseResetToken(t.Context(), second)
+	require.ErrorIs(t, err, ErrResetTokenNotFound)
+
+	// Unknown and expired tokens are rejected
+	_, err = queries.UseResetToken(t.Context(), fmt.Sprintf("reset-%d-unknown", suffix))
+	require.ErrorIs(t, err, ErrResetTokenNotFound)
+	require.NoError(t, queries.StoreResetToken(t.Context(), account.ID, first, time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	_, err = queries.UseResetToken(t.Context(), first)
+	require.ErrorIs(t, err, ErrResetTokenNotFound)
+}
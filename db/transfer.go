@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTransferClosed     = errors.New("tickets of this event can no longer be transferred")
+	ErrTransferToSelf     = errors.New("cannot transfer a ticket to yourself")
+	ErrTransferPending    = errors.New("booking already has a pending transfer")
+	ErrTransferNotPending = errors.New("transfer is no longer pending")
+	ErrRefundRequested    = errors.New("booking has an open refund request")
+)
+
+// Start the transfer of a paid booking to another account. The booking stays with its owner until the recipient
+// accepts. Transfers close a while before the event starts, given by cutoff, and when the organiser disables them.
+// The returned transfer has its parties and booking loaded, as do the other transfer queries
+func (queries *Queries) CreateTransfer(
+	ctx context.Context,
+	bookingID, senderID, recipientID uint,
+	cutoff time.Duration,
+) (TicketTransfer, error) {
+	if senderID == recipientID {
+		return TicketTransfer{}, ErrTransferToSelf
+	}
+
+	var transfer TicketTransfer
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		booking, err := lockBookingToTransfer(tx, bookingID, senderID, cutoff)
+		if err != nil {
+			return err
+		}
+
+		transfer = TicketTransfer{
+			BookingID:   booking.ID,
+			SenderID:    senderID,
+			RecipientID: recipientID,
+			Status:      TransferPending,
+		}
+		if err := tx.Create(&transfer).Error; err != nil {
+			return err
+		}
+		if err := tx.Preload("Sender").Preload("Recipient").First(&transfer, transfer.ID).Error; err != nil {
+			return err
+		}
+		transfer.Booking = booking
+		return nil
+	})
+	return transfer, err
+}
+
+// Check if a booking could be transferred by its owner right now, with the same errors as CreateTransfer,
+// without starting a transfer
+func (queries *Queries) CheckTransferable(ctx context.Context, bookingID, senderID uint, cutoff time.Duration) error {
+	return queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := lockBookingToTransfer(tx, bookingID, senderID, cutoff)
+		return err
+	})
+}
+
+// Helper function: lock a booking that is about to be transferred, checking that it is transferable
+// and is neither on sale nor already being transferred
+func lockBookingToTransfer(tx *gorm.DB, bookingID, senderID uint, cutoff time.Duration) (Booking, error) {
+	booking, err := lockTransferableBooking(tx, bookingID, senderID, cutoff)
+	if err != nil {
+		return booking, err
+	}
+	if err := checkBookingNotListed(tx, booking.ID); err != nil {
+		return booking, err
+	}
+
+	var pending int64
+	result := tx.
+		Model(&TicketTransfer{}).
+		Where("booking_id = ? AND status = ?", booking.ID, TransferPending).
+		Count(&pending)
+	if result.Error != nil {
+		return booking, result.Error
+	}
+	if pending > 0 {
+		return booking, ErrTransferPending
+	}
+	return booking, nil
+}
+
+// Accept a transfer sent to an account. The booking moves to the recipient and its ticket version is bumped,
+// so the QR code held by the sender stops working at the gate. The same rules as CreateTransfer are checked again,
+// since the event or the booking may have changed in the meantime
+func (queries *Queries) AcceptTransfer(ctx context.Context, transferID, recipientID uint, cutoff time.Duration) (TicketTransfer, error) {
+	var transfer TicketTransfer
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Sender").
+			Preload("Recipient").
+			Where("recipient_id = ?", recipientID).
+			First(&transfer, transferID).Error
+		if err != nil {
+			return err
+		}
+		if transfer.Status != TransferPending {
+			return ErrTransferNotPending
+		}
+
+		booking, err := lockTransferableBooking(tx, transfer.BookingID, transfer.SenderID, cutoff)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// The sender no longer owns the booking
+				return ErrBookingNotValid
+			}
+			return err
+		}
+		if err := checkBookingNotListed(tx, booking.ID); err != nil {
+			return err
+		}
+
+		err = tx.Model(&booking).Updates(map[string]any{
+			"account_id":     recipientID,
+			"ticket_version": gorm.Expr("ticket_version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		booking.AccountID = recipientID
+		booking.TicketVersion++
+
+		if err := respondTransfer(tx, &transfer, TransferAccepted); err != nil {
+			return err
+		}
+		transfer.Booking = booking
+		return nil
+	})
+	return transfer, err
+}
+
+// Decline a transfer sent to an account
+func (queries *Queries) DeclineTransfer(ctx context.Context, transferID, recipientID uint) (TicketTransfer, error) {
+	return queries.closeTransfer(ctx, transferID, "recipient_id", recipientID, TransferDeclined)
+}
+
+// Cancel a transfer sent by an account before it is accepted
+func (queries *Queries) CancelTransfer(ctx context.Context, transferID, senderID uint) (TicketTransfer, error) {
+	return queries.closeTransfer(ctx, transferID, "sender_id", senderID, TransferCanceled)
+}
+
+// Helper method: close a pending transfer of one of its parties without moving the booking
+func (queries *Queries) closeTransfer(
+	ctx context.Context,
+	transferID uint,
+	party string,
+	accountID uint,
+	status TransferStatus,
+) (TicketTransfer, error) {
+	var transfer TicketTransfer
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Sender").
+			Preload("Recipient").
+			Preload("Booking.Ticket.Event").
+			Where(party+" = ?", accountID).
+			First(&transfer, transferID).Error
+		if err != nil {
+			return err
+		}
+		if transfer.Status != TransferPending {
+			return ErrTransferNotPending
+		}
+
+		return respondTransfer(tx, &transfer, status)
+	})
+	return transfer, err
+}
+
+// Helper function: record the answer to a pending transfer
+func respondTransfer(tx *gorm.DB, transfer *TicketTransfer, status TransferStatus) error {
+	transfer.Status = status
+	transfer.RespondedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return tx.Model(transfer).Updates(map[string]any{
+		"status":       transfer.Status,
+		"responded_at": transfer.RespondedAt,
+	}).Error
+}
+
+// Helper function: lock a booking of an account with its tier and event, and check it can be transferred now
+func lockTransferableBooking(tx *gorm.DB, bookingID, ownerID uint, cutoff time.Duration) (Booking, error) {
+	var booking Booking
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Ticket.Event").
+		Where("account_id = ?", ownerID).
+		First(&booking, bookingID).Error
+	if err != nil {
+		return booking, err
+	}
+	if booking.Status != Valid {
+		return booking, ErrBookingNotValid
+	}
+
+	event := booking.Ticket.Event
+	if event.Status != Published || event.TransfersDisabled || !time.Now().Add(cutoff).Before(event.StartTime) {
+		return booking, ErrTransferClosed
+	}
+
+	// The refund would go to the payment of the current owner
+	var open int64
+	result := tx.
+		Model(&RefundRequest{}).
+		Where("booking_id = ? AND status = ?", booking.ID, RefundRequested).
+		Count(&open)
+	if result.Error != nil {
+		return booking, result.Error
+	}
+	if open > 0 {
+		return booking, ErrRefundRequested
+	}
+	return booking, nil
+}
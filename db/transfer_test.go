@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Helper function: create a published event starting in a day, with an unpaid booking of it
+// held by the sender and an account to send it to
+func createTransferBooking(t *testing.T) (Account, Account, Ticket, Booking) {
+	suffix := time.Now().UnixNano()
+	accounts := make([]Account, 2)
+	for i := range accounts {
+		accounts[i] = Account{
+			Username: fmt.Sprintf("transfer-test-%d-%d", suffix, i),
+			Email:    fmt.Sprintf("transfer-test-%d-%d@example.com", suffix, i),
+			Status:   Active,
+			Role:     User,
+		}
+		require.NoError(t, queries.DB.Create(&accounts[i]).Error)
+	}
+
+	event := Event{
+		HostID:      accounts[0].ID,
+		Name:        "Transfer test",
+		Description: "Transfer test",
+		Location:    "Transfer test",
+		StartTime:   time.Now().Add(time.Hour * 24),
+		EndTime:     time.Now().Add(time.Hour * 26),
+		Status:      Published,
+	}
+	require.NoError(t, queries.DB.Create(&event).Error)
+
+	ticket := Ticket{EventID: event.ID, Rank: "standard", Total: 1, Available: 1, Price: 10, Status: Published}
+	require.NoError(t, queries.DB.Create(&ticket).Error)
+
+	bookings, err := queries.ReserveTickets(t.Context(), accounts[0].ID, ticket.ID, 1, nil, time.Minute)
+	require.NoError(t, err)
+	return accounts[0], accounts[1], ticket, bookings[0]
+}
+
+func TestTransferTicket(t *testing.T) {
+	connectStores(t)
+
+	sender, recipient, ticket, booking := createTransferBooking(t)
+
+	// Only paid bookings can be transferred
+	_, err := queries.CreateTransfer(t.Context(), booking.ID, sender.ID, recipient.ID, time.Hour)
+	require.ErrorIs(t, err, ErrBookingNotValid)
+	require.NoError(t, queries.DB.Model(&booking).Update("status", Valid).Error)
+
+	// The event starts in a day, so a longer cutoff closes transfers
+	_, err = queries.CreateTransfer(t.Context(), booking.ID, sender.ID, recipient.ID, time.Hour*48)
+	require.ErrorIs(t, err, ErrTransferClosed)
+	_, err = queries.CreateTransfer(t.Context(), booking.ID, sender.ID, sender.ID, time.Hour)
+	require.ErrorIs(t, err, ErrTransferToSelf)
+
+	transfer, err := queries.CreateTransfer(t.Context(), booking.ID, sender.ID, recipient.ID, time.Hour)
+	require.NoError(t, err)
+	_, err = queries.CreateTransfer(t.Context(), booking.ID, sender.ID, recipient.ID, time.Hour)
+	require.ErrorIs(t, err, ErrTransferPending)
+
+	// Only the recipient can accept, and the accepted booking gets a new ticket version
+	_, err = queries.AcceptTransfer(t.Context(), transfer.ID, sender.ID, time.Hour)
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	transfer, err = queries.AcceptTransfer(t.Context(), transfer.ID, recipient.ID, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, TransferAccepted, transfer.Status)
+	require.Equal(t, recipient.ID, transfer.Booking.AccountID)
+	require.Equal(t, booking.TicketVersion+1, transfer.Booking.TicketVersion)
+
+	_, err = queries.CheckIn(t.Context(), booking.ID, ticket.EventID, booking.TicketVersion, sender.ID)
+	require.ErrorIs(t, err, ErrTicketCodeRevoked)
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Condition on tickets: someone waiting for the tier wants no more than its available tickets,
+// so they must be offered to them before anyone else can buy them
+const waitlistOfferDue = "EXISTS (SELECT 1 FROM waitlist_entries WHERE waitlist_entries.ticket_id = tickets.id " +
+	"AND waitlist_entries.status = ? AND waitlist_entries.quantity <= tickets.available " +
+	"AND waitlist_entries.deleted_at IS NULL)"
+
+var (
+	ErrWaitlistNotNeeded = errors.New("tickets are available, book them directly")
+	ErrAlreadyWaiting    = errors.New("already waiting for this ticket tier")
+	ErrOfferUnavailable  = errors.New("offer has expired or has already been used")
+)
+
+// Join the waitlist of a tier. It is only possible when the tier cannot sell the wanted quantity right now,
+// either because too few tickets are left or because they are kept for the offers of other people
+func (queries *Queries) JoinWaitlist(ctx context.Context, accountID, ticketID, quantity uint) (WaitlistEntry, error) {
+	var entry WaitlistEntry
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ticket Ticket
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Event").
+			First(&ticket, ticketID).Error
+		if err != nil {
+			return err
+		}
+		if ticket.Status != Published || !ticket.Event.StartTime.After(time.Now()) {
+			return ErrTicketNotOnSale
+		}
+
+		var due, joined int64
+		result := tx.
+			Model(&WaitlistEntry{}).
+			Where("ticket_id = ? AND status = ? AND quantity <= ?", ticketID, WaitlistWaiting, ticket.Available).
+			Count(&due)
+		if result.Error != nil {
+			return result.Error
+		}
+		if due == 0 && ticket.Available >= quantity {
+			return ErrWaitlistNotNeeded
+		}
+		result = tx.
+			Model(&WaitlistEntry{}).
+			Where("ticket_id = ? AND account_id = ? AND status IN ?", ticketID, accountID,
+				[]WaitlistStatus{WaitlistWaiting, WaitlistOffered}).
+			Count(&joined)
+		if result.Error != nil {
+			return result.Error
+		}
+		if joined > 0 {
+			return ErrAlreadyWaiting
+		}
+
+		entry = WaitlistEntry{TicketID: ticketID, AccountID: accountID, Quantity: quantity, Status: WaitlistWaiting}
+		return tx.Create(&entry).Error
+	})
+	return entry, err
+}
+
+// Get the place of a waiting entry in its queue, starting from 1. Return 0 if the entry is no longer waiting
+func (queries *Queries) WaitlistPosition(ctx context.Context, entry WaitlistEntry) (int64, error) {
+	if entry.Status != WaitlistWaiting {
+		return 0, nil
+	}
+
+	var position int64
+	err := queries.DB.WithContext(ctx).
+		Model(&WaitlistEntry{}).
+		Where("ticket_id = ? AND status = ? AND id <= ?", entry.TicketID, WaitlistWaiting, entry.ID).
+		Count(&position).Error
+	return position, err
+}
+
+// Offer the available tickets of a tier to the waitlist, in the order people joined. The tickets of an offer
+// are taken from the tier and held for the entry until the offer expires. An entry that wants more tickets
+// than are left keeps its place, while the people behind who fit go ahead, so returned tickets never sit
+// unsold waiting for a large entry. Return the entries that have been offered, with their account and tier loaded
+func (queries *Queries) OfferWaitlist(ctx context.Context, ticketID uint, duration time.Duration) ([]WaitlistEntry, error) {
+	var offered []WaitlistEntry
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ticket Ticket
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Event").
+			First(&ticket, ticketID).Error
+		if err != nil {
+			return err
+		}
+		if ticket.Status != Published || !ticket.Event.StartTime.After(time.Now()) {
+			return nil
+		}
+
+		available := ticket.Available
+		for {
+			var entries []WaitlistEntry
+			result := tx.
+				Preload("Account").
+				Where("ticket_id = ? AND status = ? AND quantity <= ?", ticketID, WaitlistWaiting, available).
+				Order("id").
+				Limit(1).
+				Find(&entries)
+			if result.Error != nil {
+				return result.Error
+			}
+			if len(entries) == 0 {
+				break
+			}
+
+			entry := entries[0]
+			entry.Status = WaitlistOffered
+			entry.OfferExpiresAt = sql.NullTime{Time: time.Now().Add(duration), Valid: true}
+			err := tx.Model(&entry).Updates(map[string]any{
+				"status":           entry.Status,
+				"offer_expires_at": entry.OfferExpiresAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			available -= entry.Quantity
+			entry.Ticket = ticket
+			offered = append(offered, entry)
+		}
+
+		if available == ticket.Available {
+			return nil
+		}
+		return tx.Model(&ticket).Update("available", available).Error
+	})
+	if err != nil || len(offered) == 0 {
+		return offered, err
+	}
+
+	// The database has been updated, the cached stock is loaded again on the next booking
+	return offered, queries.InvalidateTicketStock(ctx, ticketID)
+}
+
+// Expire the offers that have not been claimed in time, giving their tickets back to the tiers, so they can be
+// offered to the next people. Return the expired entries, even if the error is not nil, since the error then
+// only means the cached stock could not be updated
+func (queries *Queries) ExpireWaitlistOffers(ctx context.Context, now time.Time) ([]WaitlistEntry, error) {
+	var expired []WaitlistEntry
+	counts := map[uint]uint{}
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Model(&expired).
+			Clauses(clause.Returning{}).
+			Where("status = ? AND offer_expires_at <= ?", WaitlistOffered, now).
+			Update("status", WaitlistExpired)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for _, entry := range expired {
+			counts[entry.TicketID] += entry.Quantity
+		}
+		return returnTicketsInOrder(tx, counts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for ticketID := range counts {
+		errs = append(errs, queries.InvalidateTicketStock(ctx, ticketID))
+	}
+	return expired, errors.Join(errs...)
+}
+
+// Get the tiers that have enough tickets left for someone waiting for them, which happens when an offer
+// could not be made in time
+func (queries *Queries) PendingWaitlistTickets(ctx context.Context) ([]uint, error) {
+	var ticketIDs []uint
+	err := queries.DB.WithContext(ctx).
+		Model(&Ticket{}).
+		Where("available > 0 AND status = ?", Published).
+		Where(waitlistOfferDue, WaitlistWaiting).
+		Order("id").
+		Pluck("id", &ticketIDs).Error
+	return ticketIDs, err
+}
+
+// Claim the offer of a waitlist entry of an account, turning its held tickets into pending bookings
+// that must be paid like any other booking
+func (queries *Queries) ClaimWaitlistOffer(
+	ctx context.Context,
+	entryID, accountID uint,
+	hold time.Duration,
+) (WaitlistEntry, []Booking, error) {
+	var (
+		entry    WaitlistEntry
+		bookings []Booking
+	)
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("account_id = ?", accountID).
+			First(&entry, entryID).Error
+		if err != nil {
+			return err
+		}
+		if entry.Status != WaitlistOffered || !entry.OfferExpiresAt.Time.After(time.Now()) {
+			return ErrOfferUnavailable
+		}
+
+		// The tickets have been taken from the tier when the offer was made
+		bookings, err = createBookings(tx, accountID, entry.TicketID, entry.Quantity, nil, hold, nil)
+		if err != nil {
+			return err
+		}
+
+		entry.Status = WaitlistClaimed
+		return tx.Model(&entry).Update("status", entry.Status).Error
+	})
+	return entry, bookings, err
+}
+
+// Leave the waitlist. If the entry has an offer, its tickets are given back to the tier. Return the entry once it
+// has left, even if the error is not nil, since the error then only means the cached stock could not be updated
+func (queries *Queries) LeaveWaitlist(ctx context.Context, entryID, accountID uint) (WaitlistEntry, error) {
+	var entry WaitlistEntry
+	err := queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("account_id = ?", accountID).
+			First(&entry, entryID).Error
+		if err != nil {
+			return err
+		}
+		if entry.Status != WaitlistWaiting && entry.Status != WaitlistOffered {
+			return ErrOfferUnavailable
+		}
+
+		if entry.Status == WaitlistOffered {
+			if err := ReturnTickets(tx, entry.TicketID, entry.Quantity); err != nil {
+				return err
+			}
+		}
+		entry.Status = WaitlistLeft
+		return tx.Model(&entry).Update("status", entry.Status).Error
+	})
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+
+	return entry, queries.InvalidateTicketStock(ctx, entry.TicketID)
+}
+
+// Helper function: give tickets back to tiers in a fixed order, so concurrent updates cannot deadlock
+func returnTicketsInOrder(tx *gorm.DB, counts map[uint]uint) error {
+	ticketIDs := make([]uint, 0, len(counts))
+	for ticketID := range counts {
+		ticketIDs = append(ticketIDs, ticketID)
+	}
+	slices.Sort(ticketIDs)
+	for _, ticketID := range ticketIDs {
+		if err := ReturnTickets(tx, ticketID, counts[ticketID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
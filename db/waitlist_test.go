@@ -0,0 +1,119 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Helper function: create a published event with a single tier, the account buying its tickets
+// and as many other accounts to wait for them
+func createWaitlistTier(t *testing.T, total uint, waiting int) (Account, Ticket, []Account) {
+	suffix := time.Now().UnixNano()
+	accounts := make([]Account, waiting+1)
+	for i := range accounts {
+		accounts[i] = Account{
+			Username: fmt.Sprintf("waitlist-test-%d-%d", suffix, i),
+			Email:    fmt.Sprintf("waitlist-test-%d-%d@example.com", suffix, i),
+			Status:   Active,
+			Role:     User,
+		}
+		require.NoError(t, queries.DB.Create(&accounts[i]).Error)
+	}
+
+	event := Event{
+		HostID:      accounts[0].ID,
+		Name:        "Waitlist test",
+		Description: "Waitlist test",
+		Location:    "Waitlist test",
+		StartTime:   time.Now().Add(time.Hour * 24),
+		EndTime:     time.Now().Add(time.Hour * 26),
+		Status:      Published,
+	}
+	require.NoError(t, queries.DB.Create(&event).Error)
+
+	ticket := Ticket{EventID: event.ID, Rank: "standard", Total: total, Available: total, Price: 10, Status: Published}
+	require.NoError(t, queries.DB.Create(&ticket).Error)
+
+	return accounts[0], ticket, accounts[1:]
+}
+
+func TestWaitlistOffers(t *testing.T) {
+	connectStores(t)
+
+	buyer, ticket, waiting := createWaitlistTier(t, 2, 2)
+	first, second := waiting[0], waiting[1]
+
+	// Nobody can wait while tickets are left
+	_, err := queries.JoinWaitlist(t.Context(), first.ID, ticket.ID, 1)
+	require.ErrorIs(t, err, ErrWaitlistNotNeeded)
+
+	bookings, err := queries.ReserveTickets(t.Context(), buyer.ID, ticket.ID, 2, nil, time.Minute)
+	require.NoError(t, err)
+
+	entry, err := queries.JoinWaitlist(t.Context(), first.ID, ticket.ID, 1)
+	require.NoError(t, err)
+	_, err = queries.JoinWaitlist(t.Context(), first.ID, ticket.ID, 1)
+	require.ErrorIs(t, err, ErrAlreadyWaiting)
+	next, err := queries.JoinWaitlist(t.Context(), second.ID, ticket.ID, 1)
+	require.NoError(t, err)
+	position, err := queries.WaitlistPosition(t.Context(), next)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), position)
+
+	// A released ticket is kept for the waitlist, and offered to the first person only
+	_, err = queries.ReleaseBookings(t.Context(), []uint{bookings[0].ID})
+	require.NoError(t, err)
+	_, err = queries.ReserveTickets(t.Context(), buyer.ID, ticket.ID, 1, nil, time.Minute)
+	require.ErrorIs(t, err, ErrSoldOut)
+
+	offered, err := queries.OfferWaitlist(t.Context(), ticket.ID, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, offered, 1)
+	require.Equal(t, entry.ID, offered[0].ID)
+
+	// An expired offer rolls over to the next person
+	expired, err := queries.ExpireWaitlistOffers(t.Context(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	offered, err = queries.OfferWaitlist(t.Context(), ticket.ID, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, offered, 1)
+	require.Equal(t, next.ID, offered[0].ID)
+
+	_, _, err = queries.ClaimWaitlistOffer(t.Context(), entry.ID, first.ID, time.Minute)
+	require.ErrorIs(t, err, ErrOfferUnavailable)
+	_, claimed, err := queries.ClaimWaitlistOffer(t.Context(), next.ID, second.ID, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.NoError(t, queries.DB.First(&ticket, ticket.ID).Error)
+	require.Equal(t, uint(0), ticket.Available)
+
+	// A large entry does not hold back returned tickets: smaller entries behind it go ahead,
+	// and what nobody waiting can use is sold again
+	buyer, ticket, _ = createWaitlistTier(t, 3, 0)
+	bookings, err = queries.ReserveTickets(t.Context(), buyer.ID, ticket.ID, 3, nil, time.Minute)
+	require.NoError(t, err)
+	large, err := queries.JoinWaitlist(t.Context(), first.ID, ticket.ID, 3)
+	require.NoError(t, err)
+	small, err := queries.JoinWaitlist(t.Context(), second.ID, ticket.ID, 1)
+	require.NoError(t, err)
+
+	_, err = queries.ReleaseBookings(t.Context(), []uint{bookings[0].ID, bookings[1].ID})
+	require.NoError(t, err)
+	_, err = queries.ReserveTickets(t.Context(), buyer.ID, ticket.ID, 1, nil, time.Minute)
+	require.ErrorIs(t, err, ErrSoldOut)
+
+	offered, err = queries.OfferWaitlist(t.Context(), ticket.ID, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, offered, 1)
+	require.Equal(t, small.ID, offered[0].ID)
+	position, err = queries.WaitlistPosition(t.Context(), large)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), position)
+
+	_, err = queries.ReserveTickets(t.Context(), buyer.ID, ticket.ID, 1, nil, time.Minute)
+	require.NoError(t, err)
+}
@@ -9,19 +9,24 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/danglnh07/ticket-system/api"
 	"github.com/danglnh07/ticket-system/db"
 	"github.com/danglnh07/ticket-system/service/mail"
 	"github.com/danglnh07/ticket-system/service/notify"
+	"github.com/danglnh07/ticket-system/service/oauth"
 	"github.com/danglnh07/ticket-system/service/payment"
 	"github.com/danglnh07/ticket-system/service/scheduler"
 	"github.com/danglnh07/ticket-system/service/security"
 	"github.com/danglnh07/ticket-system/service/worker"
 	"github.com/danglnh07/ticket-system/util"
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -42,17 +47,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Setup stripe secret key globally
-	payment.InitStripe(config.StripeSecretKey)
-
-	// Run the cron
-	s := scheduler.NewScheduler()
-
-	// Add job
-	// s.AddJob("@every 15m", func() { /*Add your job to be run here*/ })
-
-	// Run the cron job in separate goroutine
-	s.RunCronJobs()
+	// Connect to Redis
+	if err := queries.ConnectRedis(&redis.Options{Addr: config.RedisAddr}); err != nil {
+		logger.Error("Error connecting to Redis", "error", err)
+		os.Exit(1)
+	}
 
 	// Create dependencies for server
 	mailService := mail.NewEmailService(config)
@@ -61,12 +60,50 @@ func main() {
 		Addr: config.RedisAddr,
 	}, logger)
 	hub := notify.NewHub(logger)
+	google := oauth.NewGoogleOAuth(config)
+	paymentProvider := payment.NewStripeProvider(config)
+
+	// Remind a day by email and an hour by popup before the event starts
+	calendar := notify.NewGoogleCalendar(config.GoogleClientID, config.GoogleClientSecret, 24*60, 60)
+
+	// Run the cron
+	s := scheduler.NewScheduler()
+
+	// Add job. Each booking has its own release task, this only catches the ones that have been lost
+	err := s.AddJob("@every 1m", func() {
+		err := distributor.DistributeTask(
+			context.Background(), worker.ReleaseExpiredBookings, nil, asynq.Unique(time.Minute))
+		if err != nil && !errors.Is(err, asynq.ErrDuplicateTask) {
+			logger.Error("Failed to enqueue expired bookings sweeper", "error", err)
+		}
+	})
+	if err != nil {
+		logger.Error("Error adding cron job", "error", err)
+		os.Exit(1)
+	}
+
+	// Each offer has its own expiry task, this only catches the ones that have been lost
+	err = s.AddJob("@every 1m", func() {
+		err := distributor.DistributeTask(
+			context.Background(), worker.ExpireWaitlistOffers, nil, asynq.Unique(time.Minute))
+		if err != nil && !errors.Is(err, asynq.ErrDuplicateTask) {
+			logger.Error("Failed to enqueue waitlist offer sweeper", "error", err)
+		}
+	})
+	if err != nil {
+		logger.Error("Error adding cron job", "error", err)
+		os.Exit(1)
+	}
+
+	// Run the cron job in separate goroutine
+	s.RunCronJobs()
 
 	// Start the background server in separate goroutine (since it's will block the main thread)
-	go StartBackgroundProcessor(asynq.RedisClientOpt{Addr: config.RedisAddr}, queries, mailService, hub, logger)
+	go StartBackgroundProcessor(
+		asynq.RedisClientOpt{Addr: config.RedisAddr}, queries, mailService, hub, paymentProvider, calendar, config, distributor, logger)
 
 	// Start server
-	server := api.NewServer(queries, mailService, jwtService, distributor, hub, config, logger)
+	server := api.NewServer(queries, mailService, jwtService, distributor, hub, google, paymentProvider, config, logger)
 	if err := server.Start(); err != nil {
 		logger.Error("Failed to start server", "error", err)
 		os.Exit(1)
@@ -78,10 +115,15 @@ func StartBackgroundProcessor(
 	queries *db.Queries,
 	mailService mail.MailService,
 	hub *notify.Hub,
+	paymentProvider payment.PaymentProvider,
+	calendar *notify.GoogleCalendar,
+	config *util.Config,
+	distributor worker.TaskDistributor,
 	logger *slog.Logger,
 ) error {
 	// Create the processor
-	processor := worker.NewRedisTaskProcessor(redisOpts, queries, mailService, hub, logger)
+	processor := worker.NewRedisTaskProcessor(
+		redisOpts, queries, mailService, hub, paymentProvider, calendar, config, distributor, logger)
 
 	// Start process tasks
 	return processor.Start()
@@ -1,8 +1,12 @@
 package mail
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
 	"strings"
 
 	"github.com/danglnh07/ticket-system/util"
@@ -11,8 +15,21 @@ import (
 // Universal interface for mail service
 type MailService interface {
 	SendEmail(to, subject, body string) error
+
+	// Send an HTML email with images attached inline, the body refers to each of them with cid:<ContentID>
+	SendEmailWithImages(to, subject, body string, images []InlineImage) error
+}
+
+// An image attached to an email, shown in the body rather than as an attachment
+type InlineImage struct {
+	ContentID   string
+	ContentType string
+	Data        []byte
 }
 
+// Base64 lines of an email must not be longer than this
+const base64LineLength = 76
+
 // Email service struct, which holds configurations related to email sending
 type EmailService struct {
 	Host  string
@@ -61,3 +78,69 @@ func (service *EmailService) SendEmail(to, subject, body string) error {
 		[]byte(message.String()),
 	)
 }
+
+// Method to send email with inline images, as a multipart/related message
+func (service *EmailService) SendEmailWithImages(to, subject, body string, images []InlineImage) error {
+	// Build the parts first, the boundary is needed in the headers
+	var parts bytes.Buffer
+	writer := multipart.NewWriter(&parts)
+
+	html, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := html.Write([]byte(body)); err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {image.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-ID":                {"<" + image.ContentID + ">"},
+			"Content-Disposition":       {"inline"},
+		})
+		if err != nil {
+			return err
+		}
+		encoded := base64.StdEncoding.EncodeToString(image.Data)
+		for len(encoded) > 0 {
+			n := min(len(encoded), base64LineLength)
+			if _, err := part.Write([]byte(encoded[:n] + "\r\n")); err != nil {
+				return err
+			}
+			encoded = encoded[n:]
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	// Set email headers with MIME version and content type
+	headers := make(map[string]string)
+	headers["From"] = service.Email
+	headers["To"] = to
+	headers["Subject"] = subject
+	headers["MIME-Version"] = "1.0"
+	headers["Content-Type"] = fmt.Sprintf(`multipart/related; type="text/html"; boundary="%s"`, writer.Boundary())
+
+	// Build the message with headers
+	var message strings.Builder
+	for key, value := range headers {
+		message.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	}
+	message.WriteString("\r\n")
+	message.Write(parts.Bytes())
+
+	addr := fmt.Sprintf("%s:%s", service.Host, service.Port)
+	return smtp.SendMail(
+		addr,
+		service.Auth,
+		service.Email,
+		[]string{to},
+		[]byte(message.String()),
+	)
+}
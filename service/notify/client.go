@@ -1,10 +1,17 @@
 package notify
 
-import "github.com/gorilla/websocket"
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
 
 type Client struct {
 	ClientID uint
 	conn     *websocket.Conn
+
+	// A websocket connection supports only one writer at a time
+	mutex sync.Mutex
 }
 
 func NewClient(clientID uint, conn *websocket.Conn) *Client {
@@ -15,5 +22,7 @@ func NewClient(clientID uint, conn *websocket.Conn) *Client {
 }
 
 func (client *Client) Notify(message any) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	return client.conn.WriteJSON(message)
 }
@@ -6,8 +6,10 @@ import (
 	"sync"
 )
 
+// The hub is used by HTTP handlers and workers at the same time, so the clients are guarded by a mutex
 type Hub struct {
 	clients map[uint]*Client
+	mutex   sync.RWMutex
 	logger  *slog.Logger
 }
 
@@ -19,13 +21,24 @@ func NewHub(logger *slog.Logger) *Hub {
 }
 
 func (hub *Hub) Subscribe(client *Client) {
-	// Add the client to the map using client ID
+	// Add the client to the map using client ID. A new connection of the same user replaces the old one
+	hub.mutex.Lock()
+	old, ok := hub.clients[client.ClientID]
 	hub.clients[client.ClientID] = client
+	hub.mutex.Unlock()
+
+	if ok && old != client {
+		old.conn.Close()
+	}
 }
 
 func (hub *Hub) Unsubscribe(clientID uint, client *Client) {
-	// Remove the client out of the map
-	delete(hub.clients, clientID)
+	// Remove the client out of the map, unless it has already been replaced by a newer connection
+	hub.mutex.Lock()
+	if hub.clients[clientID] == client {
+		delete(hub.clients, clientID)
+	}
+	hub.mutex.Unlock()
 
 	// Close the websocket connection to clean up resource
 	client.conn.Close()
@@ -43,7 +56,15 @@ func (hub *Hub) Broadcast(message any) int {
 		mutex   = sync.Mutex{}
 	)
 
+	// Send to a snapshot of the clients, so the hub is not locked while writing to slow connections
+	hub.mutex.RLock()
+	clients := make([]*Client, 0, len(hub.clients))
 	for _, clt := range hub.clients {
+		clients = append(clients, clt)
+	}
+	hub.mutex.RUnlock()
+
+	for _, clt := range clients {
 		wg.Add(1)
 		go func(client *Client) {
 			defer wg.Done()
@@ -51,6 +72,7 @@ func (hub *Hub) Broadcast(message any) int {
 			// Send message
 			if err := client.Notify(message); err != nil {
 				hub.logger.Error("Error sending message to client", "id", client.ClientID, "error", err)
+				return
 			}
 
 			// Increase the number of success
@@ -66,7 +88,9 @@ func (hub *Hub) Broadcast(message any) int {
 }
 
 func (hub *Hub) Publish(clientID uint, message any) error {
+	hub.mutex.RLock()
 	client, ok := hub.clients[clientID]
+	hub.mutex.RUnlock()
 	if !ok {
 		return fmt.Errorf("client ID not registed in hub")
 	}
@@ -74,6 +98,8 @@ func (hub *Hub) Publish(clientID uint, message any) error {
 }
 
 func (hub *Hub) IsUserOnline(clientID uint) bool {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
 	_, isOnline := hub.clients[clientID]
 	return isOnline
 }
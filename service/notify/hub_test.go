@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/gorilla/websocket"
@@ -95,3 +96,29 @@ func TestHubBroadcast(t *testing.T) {
 	hub.Unsubscribe(client2.ClientID, client2)
 	require.Equal(t, 0, len(hub.clients))
 }
+
+// Test that the hub can be used from many goroutines at once. Run with -race to catch data races
+func TestHubConcurrent(t *testing.T) {
+	// Create the test server
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+
+			conn := newTestConn(t, server)
+			client := NewClient(id, conn)
+			hub.Subscribe(client)
+			hub.Broadcast(map[string]string{"msg": "hi"})
+			require.True(t, hub.IsUserOnline(id))
+			require.NoError(t, hub.Publish(id, map[string]string{"msg": "only you"}))
+			hub.Unsubscribe(id, client)
+		}(uint(100 + i))
+	}
+	wg.Wait()
+
+	require.Equal(t, 0, len(hub.clients))
+}
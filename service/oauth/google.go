@@ -0,0 +1,149 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danglnh07/ticket-system/util"
+)
+
+// The scopes we request from Google. Calendar events scope is needed so we can add events to user's calendar
+const GoogleScopes = "openid email profile https://www.googleapis.com/auth/calendar.events"
+
+// Google OAuth2 client for the authorization code flow with PKCE
+type GoogleOAuth struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// Google endpoints
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+
+	client *http.Client
+}
+
+func NewGoogleOAuth(config *util.Config) *GoogleOAuth {
+	return &GoogleOAuth{
+		ClientID:     config.GoogleClientID,
+		ClientSecret: config.GoogleClientSecret,
+		RedirectURL:  config.GoogleRedirectURL,
+		AuthURL:      config.GoogleAuthURL,
+		TokenURL:     config.GoogleTokenURL,
+		UserInfoURL:  config.GoogleUserInfoURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Method to compute the PKCE code challenge of a code verifier, using the S256 method
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Method to build the URL of Google consent page that user will be redirected to
+func (google *GoogleOAuth) AuthCodeURL(state, codeChallenge string) string {
+	query := url.Values{}
+	query.Set("client_id", google.ClientID)
+	query.Set("redirect_uri", google.RedirectURL)
+	query.Set("response_type", "code")
+	query.Set("scope", GoogleScopes)
+	query.Set("state", state)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	// Ask for offline access so Google gives us a refresh token, which is needed to access calendar later
+	query.Set("access_type", "offline")
+	query.Set("prompt", "consent")
+
+	return fmt.Sprintf("%s?%s", google.AuthURL, query.Encode())
+}
+
+type GoogleToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"` // Only returned on the first consent
+	ExpiresIn    int    `json:"expires_in"`    // Seconds
+	Scope        string `json:"scope"`
+	TokenType    string `json:"token_type"`
+	IDToken      string `json:"id_token"`
+}
+
+// Method to exchange the authorization code for tokens
+func (google *GoogleOAuth) Exchange(ctx context.Context, code, codeVerifier string) (*GoogleToken, error) {
+	payload := url.Values{}
+	payload.Set("client_id", google.ClientID)
+	payload.Set("client_secret", google.ClientSecret)
+	payload.Set("code", code)
+	payload.Set("code_verifier", codeVerifier)
+	payload.Set("redirect_uri", google.RedirectURL)
+	payload.Set("grant_type", "authorization_code")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, google.TokenURL, strings.NewReader(payload.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token GoogleToken
+	if err := google.do(req, &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access token")
+	}
+
+	return &token, nil
+}
+
+type GoogleUser struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+}
+
+// Method to get the profile of the user who owns the access token
+func (google *GoogleOAuth) UserInfo(ctx context.Context, accessToken string) (*GoogleUser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, google.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var user GoogleUser
+	if err := google.do(req, &user); err != nil {
+		return nil, err
+	}
+	if user.Sub == "" {
+		return nil, fmt.Errorf("user info response has no subject")
+	}
+
+	return &user, nil
+}
+
+// Helper method: send the request and decode the JSON response body into dest
+func (google *GoogleOAuth) do(req *http.Request, dest any) error {
+	resp, err := google.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if 200 > resp.StatusCode || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed: %s\nMessage: %s", resp.Status, message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
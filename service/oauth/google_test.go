@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/danglnh07/ticket-system/util"
+	"github.com/stretchr/testify/require"
+)
+
+// Fake Google server, which only accepts the code and verifier that match the test data
+func newFakeGoogle(t *testing.T, code, verifier string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.PostForm.Get("code") != code || r.PostForm.Get("code_verifier") != verifier {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "fake-access-token",
+			"refresh_token": "fake-refresh-token",
+			"expires_in":    3599,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fake-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"sub":            "1234567890",
+			"email":          "someone@example.com",
+			"email_verified": true,
+		})
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// Example from RFC 7636, Appendix B
+	require.Equal(t,
+		"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"),
+	)
+}
+
+func TestGoogleAuthCodeFlow(t *testing.T) {
+	// Create test data
+	code := util.RandomString(20)
+	verifier := util.RandomString(43)
+
+	server := newFakeGoogle(t, code, verifier)
+	defer server.Close()
+
+	google := NewGoogleOAuth(&util.Config{
+		GoogleClientID:    "client-id",
+		GoogleRedirectURL: "http://localhost/callback",
+		GoogleAuthURL:     server.URL + "/auth",
+		GoogleTokenURL:    server.URL + "/token",
+		GoogleUserInfoURL: server.URL + "/userinfo",
+	})
+
+	// Check the consent page URL
+	authURL, err := url.Parse(google.AuthCodeURL("some-state", CodeChallenge(verifier)))
+	require.NoError(t, err)
+	require.Equal(t, "some-state", authURL.Query().Get("state"))
+	require.Equal(t, CodeChallenge(verifier), authURL.Query().Get("code_challenge"))
+	require.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+	require.Equal(t, GoogleScopes, authURL.Query().Get("scope"))
+
+	// Wrong verifier should be rejected
+	_, err = google.Exchange(t.Context(), code, "wrong-verifier")
+	require.Error(t, err)
+
+	// Exchange code and get user info
+	token, err := google.Exchange(t.Context(), code, verifier)
+	require.NoError(t, err)
+	require.Equal(t, "fake-access-token", token.AccessToken)
+	require.Equal(t, "fake-refresh-token", token.RefreshToken)
+
+	user, err := google.UserInfo(t.Context(), token.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, "1234567890", user.Sub)
+	require.Equal(t, "someone@example.com", user.Email)
+	require.True(t, user.EmailVerified)
+}
@@ -0,0 +1,78 @@
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTelegramInvalidHash = errors.New("invalid telegram login hash")
+	ErrTelegramExpired     = errors.New("telegram login data has expired")
+)
+
+// The payload sent by Telegram Login Widget. Optional fields are empty if the user does not have them
+type TelegramUser struct {
+	ID        int64  `json:"id" binding:"required"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+	PhotoURL  string `json:"photo_url"`
+	AuthDate  int64  `json:"auth_date" binding:"required"`
+	Hash      string `json:"hash" binding:"required"`
+}
+
+// Build the data-check-string: all received fields except hash, as key=value sorted by key and joined by \n
+func (user TelegramUser) DataCheckString() string {
+	fields := map[string]string{
+		"id":        fmt.Sprintf("%d", user.ID),
+		"auth_date": fmt.Sprintf("%d", user.AuthDate),
+	}
+	optional := map[string]string{
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"username":   user.Username,
+		"photo_url":  user.PhotoURL,
+	}
+	for key, val := range optional {
+		if val != "" {
+			fields[key] = val
+		}
+	}
+
+	pairs := make([]string, 0, len(fields))
+	for key, val := range fields {
+		pairs = append(pairs, key+"="+val)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "\n")
+}
+
+// Method to compute the hash of the login data, which is the HMAC-SHA256 of the data-check-string
+// using SHA-256 of the bot token as the key
+func TelegramHash(user TelegramUser, botToken string) string {
+	key := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(user.DataCheckString()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Method to verify the Telegram login data: the hash must match and the data must not be older than maxAge
+func VerifyTelegramLogin(user TelegramUser, botToken string, maxAge time.Duration, now time.Time) error {
+	if !hmac.Equal([]byte(TelegramHash(user, botToken)), []byte(strings.ToLower(user.Hash))) {
+		return ErrTelegramInvalidHash
+	}
+
+	authDate := time.Unix(user.AuthDate, 0)
+	if now.Sub(authDate) > maxAge || authDate.After(now.Add(time.Minute)) {
+		return ErrTelegramExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package oauth
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/danglnh07/ticket-system/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTelegramDataCheckString(t *testing.T) {
+	user := TelegramUser{
+		ID:        42,
+		FirstName: "John",
+		Username:  "john",
+		AuthDate:  1700000000,
+		Hash:      "ignored",
+	}
+
+	// Fields are sorted by key, empty fields and hash are left out
+	require.Equal(t, "auth_date=1700000000\nfirst_name=John\nid=42\nusername=john", user.DataCheckString())
+}
+
+func TestVerifyTelegramLogin(t *testing.T) {
+	// Create test data
+	botToken := util.RandomString(30)
+	now := time.Now()
+	user := TelegramUser{
+		ID:        rand.Int63(),
+		FirstName: util.RandomString(6),
+		Username:  util.RandomString(8),
+		AuthDate:  now.Add(-time.Minute).Unix(),
+	}
+	user.Hash = TelegramHash(user, botToken)
+
+	// Valid login data
+	require.NoError(t, VerifyTelegramLogin(user, botToken, time.Hour, now))
+
+	// Wrong bot token
+	require.ErrorIs(t, VerifyTelegramLogin(user, "another-token", time.Hour, now), ErrTelegramInvalidHash)
+
+	// Tampered data
+	tampered := user
+	tampered.ID++
+	require.ErrorIs(t, VerifyTelegramLogin(tampered, botToken, time.Hour, now), ErrTelegramInvalidHash)
+
+	// Data older than max age
+	require.ErrorIs(t, VerifyTelegramLogin(user, botToken, time.Hour, now.Add(2*time.Hour)), ErrTelegramExpired)
+}
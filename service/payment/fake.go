@@ -0,0 +1,200 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/webhook"
+)
+
+var (
+	ErrIntentNotFound     = errors.New("payment intent not found")
+	ErrRefundExceedsTotal = errors.New("refund amount exceeds what is left to refund")
+	ErrIntentNotPending   = errors.New("payment intent has already succeeded or been canceled")
+)
+
+// In-memory payment provider for tests and local development. Payments never complete by themselves:
+// call Succeed or Fail to settle an intent and get the signed webhook payload Stripe would have sent
+type FakeProvider struct {
+	mutex         sync.Mutex
+	webhookSecret string
+	sequence      int
+	intents       map[string]*stripe.PaymentIntent
+	idempotency   map[string]string
+	refunded      map[string]int64
+	refunds       map[string]*stripe.Refund
+}
+
+// Constructor for fake payment provider
+func NewFakeProvider(webhookSecret string) *FakeProvider {
+	return &FakeProvider{
+		webhookSecret: webhookSecret,
+		intents:       make(map[string]*stripe.PaymentIntent),
+		idempotency:   make(map[string]string),
+		refunded:      make(map[string]int64),
+		refunds:       make(map[string]*stripe.Refund),
+	}
+}
+
+// Helper method: generate the next ID with a prefix. The caller must hold the mutex
+func (provider *FakeProvider) nextID(prefix string) string {
+	provider.sequence++
+	return fmt.Sprintf("%s_fake_%d", prefix, provider.sequence)
+}
+
+func (provider *FakeProvider) CreatePaymentIntent(
+	amount int64,
+	metadata map[string]string,
+	idempotencyKey string,
+) (*stripe.PaymentIntent, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if id, ok := provider.idempotency[idempotencyKey]; ok {
+		intent := *provider.intents[id]
+		return &intent, nil
+	}
+
+	id := provider.nextID("pi")
+	intent := &stripe.PaymentIntent{
+		ID:           id,
+		Amount:       amount,
+		Currency:     stripe.Currency(Currency),
+		ClientSecret: id + "_secret",
+		Metadata:     metadata,
+		Status:       stripe.PaymentIntentStatusRequiresPaymentMethod,
+		Created:      time.Now().Unix(),
+	}
+	provider.intents[id] = intent
+	provider.idempotency[idempotencyKey] = id
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (provider *FakeProvider) GetPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	intent, ok := provider.intents[id]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+func (provider *FakeProvider) CancelPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	intent, ok := provider.intents[id]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	if intent.Status == stripe.PaymentIntentStatusSucceeded || intent.Status == stripe.PaymentIntentStatusCanceled {
+		return nil, ErrIntentNotPending
+	}
+	intent.Status = stripe.PaymentIntentStatusCanceled
+	intent.CanceledAt = time.Now().Unix()
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (provider *FakeProvider) CreateRefund(
+	paymentIntentID string,
+	reason RefundReason,
+	amount int64,
+	idempotencyKey string,
+) (*stripe.Refund, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if refund, ok := provider.refunds[idempotencyKey]; ok {
+		copied := *refund
+		return &copied, nil
+	}
+
+	intent, ok := provider.intents[paymentIntentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	if amount <= 0 || provider.refunded[paymentIntentID]+amount > intent.AmountReceived {
+		return nil, ErrRefundExceedsTotal
+	}
+	provider.refunded[paymentIntentID] += amount
+
+	refund := &stripe.Refund{
+		ID:            provider.nextID("re"),
+		Amount:        amount,
+		Currency:      intent.Currency,
+		PaymentIntent: &stripe.PaymentIntent{ID: paymentIntentID},
+		Reason:        stripe.RefundReason(reason),
+		Status:        stripe.RefundStatusSucceeded,
+		Created:       time.Now().Unix(),
+	}
+	provider.refunds[idempotencyKey] = refund
+
+	copied := *refund
+	return &copied, nil
+}
+
+func (provider *FakeProvider) ConstructWebhookEvent(payload []byte, signature string) (stripe.Event, error) {
+	return webhook.ConstructEventWithOptions(payload, signature, provider.webhookSecret,
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true})
+}
+
+// Settle an intent as paid. Return the signed payload and signature header of the payment_intent.succeeded event
+func (provider *FakeProvider) Succeed(id string) ([]byte, string, error) {
+	return provider.settle(id, stripe.PaymentIntentStatusSucceeded, "payment_intent.succeeded")
+}
+
+// Settle an intent as failed. Return the signed payload and signature header of the payment_intent.payment_failed event
+func (provider *FakeProvider) Fail(id string) ([]byte, string, error) {
+	return provider.settle(id, stripe.PaymentIntentStatusRequiresPaymentMethod, "payment_intent.payment_failed")
+}
+
+// Helper method: update the intent status and build the webhook event of it
+func (provider *FakeProvider) settle(id string, status stripe.PaymentIntentStatus, eventType string) ([]byte, string, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	intent, ok := provider.intents[id]
+	if !ok {
+		return nil, "", ErrIntentNotFound
+	}
+	if intent.Status == stripe.PaymentIntentStatusSucceeded || intent.Status == stripe.PaymentIntentStatusCanceled {
+		return nil, "", ErrIntentNotPending
+	}
+	intent.Status = status
+	if status == stripe.PaymentIntentStatusSucceeded {
+		intent.AmountReceived = intent.Amount
+	}
+
+	object, err := json.Marshal(intent)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err := json.Marshal(map[string]any{
+		"id":          provider.nextID("evt"),
+		"object":      "event",
+		"type":        eventType,
+		"api_version": stripe.APIVersion,
+		"created":     time.Now().Unix(),
+		"data":        map[string]json.RawMessage{"object": object},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload: payload,
+		Secret:  provider.webhookSecret,
+	})
+	return signed.Payload, signed.Header, nil
+}
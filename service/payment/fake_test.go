@@ -0,0 +1,68 @@
+package payment
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v82"
+)
+
+func TestFakeProvider(t *testing.T) {
+	provider := NewFakeProvider("whsec_test")
+	metadata := map[string]string{MetadataAccountID: "1", MetadataBookingIDs: "2,3"}
+
+	// The same idempotency key gives the same intent
+	intent, err := provider.CreatePaymentIntent(2000, metadata, "key")
+	require.NoError(t, err)
+	require.NotEmpty(t, intent.ClientSecret)
+	again, err := provider.CreatePaymentIntent(2000, metadata, "key")
+	require.NoError(t, err)
+	require.Equal(t, intent.ID, again.ID)
+
+	// Nothing can be refunded before payment
+	_, err = provider.CreateRefund(intent.ID, RequestedByCustomer, 100, "refund-0")
+	require.ErrorIs(t, err, ErrRefundExceedsTotal)
+
+	// The signed event can be verified and carries the intent
+	payload, signature, err := provider.Succeed(intent.ID)
+	require.NoError(t, err)
+	event, err := provider.ConstructWebhookEvent(payload, signature)
+	require.NoError(t, err)
+	require.Equal(t, stripe.EventType("payment_intent.succeeded"), event.Type)
+
+	var pi stripe.PaymentIntent
+	require.NoError(t, json.Unmarshal(event.Data.Raw, &pi))
+	require.Equal(t, intent.ID, pi.ID)
+	require.Equal(t, int64(2000), pi.AmountReceived)
+	require.Equal(t, "2,3", pi.Metadata[MetadataBookingIDs])
+
+	// A tampered payload is rejected
+	_, err = provider.ConstructWebhookEvent(append(payload, ' '), signature)
+	require.Error(t, err)
+
+	// Partial refunds up to the amount received
+	refund, err := provider.CreateRefund(intent.ID, RequestedByCustomer, 1500, "refund-1")
+	require.NoError(t, err)
+	require.Equal(t, int64(1500), refund.Amount)
+	_, err = provider.CreateRefund(intent.ID, RequestedByCustomer, 600, "refund-2")
+	require.ErrorIs(t, err, ErrRefundExceedsTotal)
+
+	// Retrying with the same key gives the same refund, and does not refund twice
+	retried, err := provider.CreateRefund(intent.ID, RequestedByCustomer, 1500, "refund-1")
+	require.NoError(t, err)
+	require.Equal(t, refund.ID, retried.ID)
+	_, err = provider.CreateRefund(intent.ID, RequestedByCustomer, 500, "refund-3")
+	require.NoError(t, err)
+
+	// A paid intent cannot be canceled, and a canceled one cannot be paid
+	_, err = provider.CancelPaymentIntent(intent.ID)
+	require.ErrorIs(t, err, ErrIntentNotPending)
+	other, err := provider.CreatePaymentIntent(1000, metadata, "other")
+	require.NoError(t, err)
+	canceled, err := provider.CancelPaymentIntent(other.ID)
+	require.NoError(t, err)
+	require.Equal(t, stripe.PaymentIntentStatusCanceled, canceled.Status)
+	_, _, err = provider.Succeed(other.ID)
+	require.ErrorIs(t, err, ErrIntentNotPending)
+}
@@ -1,32 +1,116 @@
 package payment
 
 import (
+	"math"
+	"strconv"
+	"strings"
+
 	"github.com/stripe/stripe-go/v82"
-	"github.com/stripe/stripe-go/v82/paymentintent"
-	"github.com/stripe/stripe-go/v82/refund"
 )
 
-// Set the stripe secret key system-wide for the Stripe SDK to work
-func InitStripe(key string) {
-	stripe.Key = key
+// Universal interface for payment provider. Payment objects use the Stripe types, since Stripe is the provider
+// we use in production, and any other provider must adapt to them
+type PaymentProvider interface {
+	// Create a payment intent. Requests with the same idempotency key return the same intent,
+	// so retrying a request never creates a second charge
+	CreatePaymentIntent(amount int64, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, error)
+
+	// Get a payment intent by its ID
+	GetPaymentIntent(id string) (*stripe.PaymentIntent, error)
+
+	// Cancel a payment intent that has not been paid, so it can no longer be confirmed.
+	// It fails if the intent has already succeeded or been canceled
+	CancelPaymentIntent(id string) (*stripe.PaymentIntent, error)
+
+	// Refund a part of a payment intent. It fails if the amount exceeds what is left to refund.
+	// Like payment intents, requests with the same idempotency key return the same refund
+	CreateRefund(paymentIntentID string, reason RefundReason, amount int64, idempotencyKey string) (*stripe.Refund, error)
+
+	// Verify the signature of a webhook payload and parse its event
+	ConstructWebhookEvent(payload []byte, signature string) (stripe.Event, error)
+}
+
+// Metadata keys of a payment intent, which link the payment back to the account and its bookings
+const (
+	MetadataAccountID  = "account_id"
+	MetadataBookingIDs = "booking_ids"
+	MetadataOrderID    = "order_id"
+	MetadataListingID  = "listing_id"
+)
+
+// Format IDs as a metadata value. Stripe metadata values are strings of at most 500 characters
+func FormatIDs(ids []uint) string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(strs, ",")
+}
+
+// Parse IDs from a metadata value
+func ParseIDs(value string) ([]uint, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	strs := strings.Split(value, ",")
+	ids := make([]uint, len(strs))
+	for i, str := range strs {
+		id, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = uint(id)
+	}
+	return ids, nil
+}
+
+// All payments are charged in this currency
+const Currency = string(stripe.CurrencyUSD)
+
+// Stripe rejects payments below 50 cents
+const MinimumAmount = 50
+
+// Compute the amount to charge in the smallest currency unit (cents) from the ticket prices,
+// applying the membership discount then the promo code discount. Discounts are percentages
+func ComputeAmount(prices []float64, membershipDiscount, promoDiscount float64) int64 {
+	var total float64
+	for _, price := range prices {
+		total += price
+	}
+
+	total = total * (100 - membershipDiscount) / 100
+	total = total * (100 - promoDiscount) / 100
+	return int64(math.Round(total * 100))
 }
 
-// Method to create payment intent, return the client secret of the intent, or error
-func CreatePaymentIntent(amount int64) (string, error) {
-	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(amount),
-		Currency: stripe.String(string(stripe.CurrencyUSD)),
-		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
-			Enabled: stripe.Bool(true),
-		},
+// Split an amount between tickets in proportion to their prices, so each ticket knows what has been paid for it
+// and can be refunded alone. The cents left by rounding go to the first tickets, so the shares always add up
+func SplitAmount(amount int64, prices []float64) []int64 {
+	shares := make([]int64, len(prices))
+	if len(prices) == 0 {
+		return shares
 	}
 
-	intent, err := paymentintent.New(params)
-	if err != nil {
-		return "", err
+	var total float64
+	for _, price := range prices {
+		total += price
 	}
 
-	return intent.ClientSecret, nil
+	var assigned int64
+	for i, price := range prices {
+		if total > 0 {
+			shares[i] = int64(math.Floor(float64(amount) * price / total))
+		} else {
+			shares[i] = amount / int64(len(prices))
+		}
+		assigned += shares[i]
+	}
+	for i := 0; assigned < amount; i = (i + 1) % len(shares) {
+		shares[i]++
+		assigned++
+	}
+	return shares
 }
 
 type RefundReason string
@@ -37,20 +121,8 @@ const (
 	RequestedByCustomer RefundReason = "requested_by_customer"
 )
 
-// If the amount exceed the total amount of the payment intent, it will fail
-// Reason for the refund, which is either user-provided (duplicate, fraudulent, or requested_by_customer)
-// or generated by Stripe internally (expired_uncaptured_charge).
-func CreateRefund(paymentIntentID string, reason RefundReason, amount int64) (*stripe.Refund, error) {
-	params := &stripe.RefundParams{
-		PaymentIntent: stripe.String(string(paymentIntentID)),
-		Amount:        stripe.Int64(amount),
-		Reason:        stripe.String(string(reason)),
-	}
-
-	refund, err := refund.New(params)
-	if err != nil {
-		return nil, err
-	}
-
-	return refund, nil
+// Compute the fee kept from a resale price, both in the smallest currency unit (cents). The fee is a percentage
+// of the price, rounded to the nearest cent
+func ResaleFee(price int64, feePercent uint) int64 {
+	return int64(math.Round(float64(price) * float64(feePercent) / 100))
 }
@@ -0,0 +1,61 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataIDs(t *testing.T) {
+	ids := []uint{1, 20, 300}
+	require.Equal(t, "1,20,300", FormatIDs(ids))
+
+	parsed, err := ParseIDs(FormatIDs(ids))
+	require.NoError(t, err)
+	require.Equal(t, ids, parsed)
+
+	// Empty value means no ID
+	parsed, err = ParseIDs("")
+	require.NoError(t, err)
+	require.Empty(t, parsed)
+
+	_, err = ParseIDs("1,abc")
+	require.Error(t, err)
+}
+
+func TestComputeAmount(t *testing.T) {
+	// No discount
+	require.Equal(t, int64(3000), ComputeAmount([]float64{10, 20}, 0, 0))
+
+	// Discounts stack one after another: 100 - 10% = 90, then - 50% = 45
+	require.Equal(t, int64(4500), ComputeAmount([]float64{100}, 10, 50))
+
+	// Rounded to the nearest cent
+	require.Equal(t, int64(667), ComputeAmount([]float64{10}, 33.33, 0))
+
+	// Full discount
+	require.Equal(t, int64(0), ComputeAmount([]float64{10, 20}, 0, 100))
+}
+
+func TestSplitAmount(t *testing.T) {
+	// In proportion to the prices
+	require.Equal(t, []int64{1000, 2000}, SplitAmount(3000, []float64{10, 20}))
+
+	// Rounding leftovers go to the first tickets, and the shares add up to the amount
+	shares := SplitAmount(1000, []float64{10, 10, 10})
+	require.Equal(t, []int64{334, 333, 333}, shares)
+
+	// Free tickets split the amount equally
+	require.Equal(t, []int64{50, 50}, SplitAmount(100, []float64{0, 0}))
+
+	require.Empty(t, SplitAmount(100, nil))
+}
+
+func TestResaleFee(t *testing.T) {
+	require.Equal(t, int64(110), ResaleFee(1100, 10))
+
+	// Rounded to the nearest cent
+	require.Equal(t, int64(125), ResaleFee(2499, 5))
+
+	require.Equal(t, int64(0), ResaleFee(1100, 0))
+}
@@ -0,0 +1,31 @@
+package payment
+
+import "time"
+
+// A refund rule: if the refund is requested at least MinNotice before the event starts,
+// Percent of the paid amount is refunded
+type RefundRule struct {
+	MinNotice time.Duration
+	Percent   int64
+}
+
+// Refund rules, ordered from the longest notice to the shortest. The first rule that is met applies,
+// and nothing is refunded if none is met
+type RefundPolicy []RefundRule
+
+// Full refund more than 7 days before the event, half refund more than 48 hours before, nothing after that
+var DefaultRefundPolicy = RefundPolicy{
+	{MinNotice: time.Hour * 24 * 7, Percent: 100},
+	{MinNotice: time.Hour * 48, Percent: 50},
+}
+
+// Compute the refund amount of a paid amount for an event starting at start, if requested at now
+func (policy RefundPolicy) Amount(paid int64, start, now time.Time) int64 {
+	notice := start.Sub(now)
+	for _, rule := range policy {
+		if notice >= rule.MinNotice {
+			return paid * rule.Percent / 100
+		}
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundPolicy(t *testing.T) {
+	now := time.Now()
+	day := time.Hour * 24
+
+	// Full refund a week or more before
+	require.Equal(t, int64(1000), DefaultRefundPolicy.Amount(1000, now.Add(day*30), now))
+	require.Equal(t, int64(1000), DefaultRefundPolicy.Amount(1000, now.Add(day*7), now))
+
+	// Half refund between 48 hours and a week
+	require.Equal(t, int64(500), DefaultRefundPolicy.Amount(1000, now.Add(day*7-time.Minute), now))
+	require.Equal(t, int64(500), DefaultRefundPolicy.Amount(1000, now.Add(time.Hour*48), now))
+
+	// Nothing within 48 hours or after the event has started
+	require.Equal(t, int64(0), DefaultRefundPolicy.Amount(1000, now.Add(time.Hour*47), now))
+	require.Equal(t, int64(0), DefaultRefundPolicy.Amount(1000, now.Add(-time.Hour), now))
+
+	// Odd amounts round down
+	require.Equal(t, int64(499), DefaultRefundPolicy.Amount(999, now.Add(day*3), now))
+}
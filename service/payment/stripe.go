@@ -0,0 +1,79 @@
+package payment
+
+import (
+	"github.com/danglnh07/ticket-system/util"
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/paymentintent"
+	"github.com/stripe/stripe-go/v82/refund"
+	"github.com/stripe/stripe-go/v82/webhook"
+)
+
+// Stripe payment provider. Each provider has its own clients, so the secret key is not set system-wide
+type StripeProvider struct {
+	intents       paymentintent.Client
+	refunds       refund.Client
+	webhookSecret string
+}
+
+// Constructor for Stripe payment provider
+func NewStripeProvider(config *util.Config) *StripeProvider {
+	backend := stripe.GetBackend(stripe.APIBackend)
+	return &StripeProvider{
+		intents:       paymentintent.Client{B: backend, Key: config.StripeSecretKey},
+		refunds:       refund.Client{B: backend, Key: config.StripeSecretKey},
+		webhookSecret: config.StripeWebhookSecret,
+	}
+}
+
+func (provider *StripeProvider) CreatePaymentIntent(
+	amount int64,
+	metadata map[string]string,
+	idempotencyKey string,
+) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(amount),
+		Currency: stripe.String(Currency),
+		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		},
+	}
+	for key, value := range metadata {
+		params.AddMetadata(key, value)
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+
+	return provider.intents.New(params)
+}
+
+func (provider *StripeProvider) GetPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	return provider.intents.Get(id, nil)
+}
+
+func (provider *StripeProvider) CancelPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentCancelParams{
+		CancellationReason: stripe.String(string(stripe.PaymentIntentCancellationReasonAbandoned)),
+	}
+	return provider.intents.Cancel(id, params)
+}
+
+// Reason for the refund, which is either user-provided (duplicate, fraudulent, or requested_by_customer)
+// or generated by Stripe internally (expired_uncaptured_charge).
+func (provider *StripeProvider) CreateRefund(
+	paymentIntentID string,
+	reason RefundReason,
+	amount int64,
+	idempotencyKey string,
+) (*stripe.Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+		Amount:        stripe.Int64(amount),
+		Reason:        stripe.String(string(reason)),
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+
+	return provider.refunds.New(params)
+}
+
+func (provider *StripeProvider) ConstructWebhookEvent(payload []byte, signature string) (stripe.Event, error) {
+	return webhook.ConstructEvent(payload, signature, provider.webhookSecret)
+}
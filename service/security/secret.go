@@ -1,6 +1,8 @@
 package security
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
@@ -15,6 +17,27 @@ func Hash(str string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+// Method to sign a message using HMAC-SHA256, return the hex encoded signature
+func Sign(message string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Method to verify the HMAC-SHA256 signature of a message in constant time
+func VerifySignature(message, signature string, key []byte) bool {
+	return hmac.Equal([]byte(Sign(message, key)), []byte(signature))
+}
+
+// Method to generate a cryptographically secure random token from n random bytes, encoded with Base64 URL encoding
+func RandomToken(n int) (string, error) {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
 // Methods to encode a string using Base64 URL encoding
 func Encode(str string) string {
 	return base64.URLEncoding.EncodeToString([]byte(str))
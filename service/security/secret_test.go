@@ -1,6 +1,7 @@
 package security
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/danglnh07/ticket-system/util"
@@ -28,3 +29,58 @@ func TestBcryptHash(t *testing.T) {
 	// Compare hash and raw string
 	require.Equal(t, true, BcryptCompare(hashed, str))
 }
+
+func TestSignature(t *testing.T) {
+	// Create test data
+	message := util.RandomString(20)
+	key := []byte(util.RandomString(10))
+
+	// Sign and verify
+	signature := Sign(message, key)
+	require.NotEmpty(t, signature)
+	require.True(t, VerifySignature(message, signature, key))
+
+	// Tampered message or wrong key should not pass
+	require.False(t, VerifySignature(message+"x", signature, key))
+	require.False(t, VerifySignature(message, signature, []byte("another-key")))
+}
+
+func TestRandomToken(t *testing.T) {
+	// Generate two tokens, they should not be the same
+	token1, err := RandomToken(32)
+	require.NoError(t, err)
+	require.NotEmpty(t, token1)
+
+	token2, err := RandomToken(32)
+	require.NoError(t, err)
+	require.NotEqual(t, token1, token2)
+}
+
+func TestTicketCode(t *testing.T) {
+	// Create test data
+	claims := TicketClaims{BookingID: 42, EventID: 7, Seat: "A-12", Version: 1}
+	key := []byte(util.RandomString(10))
+
+	// Sign and parse
+	code, err := SignTicketCode(claims, key)
+	require.NoError(t, err)
+	parsed, err := ParseTicketCode(code, key)
+	require.NoError(t, err)
+	require.Equal(t, claims, parsed)
+
+	// A new version gives a new code
+	claims.Version++
+	next, err := SignTicketCode(claims, key)
+	require.NoError(t, err)
+	require.NotEqual(t, code, next)
+
+	// Tampered code, wrong key or garbage should not pass
+	message, signature, _ := strings.Cut(code, ".")
+	tampered := Encode(message) + "." + signature
+	_, err = ParseTicketCode(tampered, key)
+	require.ErrorIs(t, err, ErrInvalidTicketCode)
+	_, err = ParseTicketCode(code, []byte("another-key"))
+	require.ErrorIs(t, err, ErrInvalidTicketCode)
+	_, err = ParseTicketCode("booking:42", key)
+	require.ErrorIs(t, err, ErrInvalidTicketCode)
+}
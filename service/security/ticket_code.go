@@ -0,0 +1,51 @@
+package security
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidTicketCode = errors.New("invalid ticket code")
+
+// Content of the code printed on an e-ticket. The version is bumped every time the ticket changes hands,
+// so codes of previous owners stop working
+type TicketClaims struct {
+	BookingID uint   `json:"b"`
+	EventID   uint   `json:"e"`
+	Seat      string `json:"s,omitempty"`
+	Version   uint   `json:"v"`
+}
+
+// Method to create the code of an e-ticket: the Base64 URL encoded claims and their HMAC-SHA256 signature,
+// joined by a dot. The code is short enough to fit in a QR code that phone cameras read easily
+func SignTicketCode(claims TicketClaims, key []byte) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	message := base64.RawURLEncoding.EncodeToString(data)
+	return message + "." + Sign(message, key), nil
+}
+
+// Method to verify the signature of an e-ticket code and get its claims
+func ParseTicketCode(code string, key []byte) (TicketClaims, error) {
+	var claims TicketClaims
+
+	message, signature, ok := strings.Cut(code, ".")
+	if !ok || !VerifySignature(message, signature, key) {
+		return claims, ErrInvalidTicketCode
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(message)
+	if err != nil {
+		return claims, ErrInvalidTicketCode
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return claims, ErrInvalidTicketCode
+	}
+
+	return claims, nil
+}
@@ -27,6 +27,7 @@ type CustomClaims struct {
 	Role                 db.Role   `json:"role"`
 	TokenType            TokenType `json:"token_type"`
 	Version              int       `json:"version"`
+	SessionID            string    `json:"session_id"` // The login session the token has been issued in
 	jwt.RegisteredClaims           // Embed the JWT Registered claims
 }
 
@@ -36,7 +37,13 @@ func NewJWTService(config *util.Config) *JWTService {
 	}
 }
 
-func (service *JWTService) CreateToken(id uint, role db.Role, tokenType TokenType, version int) (string, error) {
+func (service *JWTService) CreateToken(
+	id uint,
+	role db.Role,
+	tokenType TokenType,
+	version int,
+	sessionID string,
+) (string, error) {
 	// Check token type and decide expiration time based on type
 	var expiration time.Duration
 	switch tokenType {
@@ -48,13 +55,21 @@ func (service *JWTService) CreateToken(id uint, role db.Role, tokenType TokenTyp
 		return "", fmt.Errorf("invalid token type")
 	}
 
+	// Generate a unique token ID, so two tokens issued in the same second are still different
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
 	// Create custom JWT claim
 	claims := CustomClaims{
 		ID:        id,
 		Role:      role,
 		TokenType: tokenType,
 		Version:   version,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,                                            // Unique ID of this token
 			Issuer:    Issuer,                                         // Who issue this token
 			Subject:   fmt.Sprintf("%d", id),                          // Whom the token is about
 			IssuedAt:  jwt.NewNumericDate(time.Now()),                 // When the token is created
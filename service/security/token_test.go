@@ -18,9 +18,11 @@ func TestToken(t *testing.T) {
 	role := []db.Role{db.User, db.Admin}[rand.Intn(2)]
 	tokenType := []TokenType{AccessToken, RefreshToken}[rand.Intn(2)]
 	version := rand.Intn(10)
+	sessionID, err := RandomToken(16)
+	require.NoError(t, err)
 
 	// Create token
-	token, err := service.CreateToken(id, role, tokenType, version)
+	token, err := service.CreateToken(id, role, tokenType, version, sessionID)
 	require.NoError(t, err)
 	require.NotEmpty(t, token)
 
@@ -34,4 +36,5 @@ func TestToken(t *testing.T) {
 	require.Equal(t, role, result.Role)
 	require.Equal(t, tokenType, result.TokenType)
 	require.Equal(t, version, result.Version)
+	require.Equal(t, sessionID, result.SessionID)
 }
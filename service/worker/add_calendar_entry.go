@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/notify"
+)
+
+type AddCalendarEntryPayload struct {
+	BookingID uint `json:"booking_id"`
+}
+
+// Add a paid booking to the Google Calendar of its owner, if they log in with Google.
+// The entry is deleted again if the event is canceled
+const AddCalendarEntry = "add-calendar-entry"
+
+func (processor *RedisTaskProcessor) AddCalendarEntry(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload AddCalendarEntryPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	var booking db.Booking
+	err := processor.queries.DB.WithContext(ctx).
+		Preload("Account").
+		Preload("Ticket.Event").
+		First(&booking, payload.BookingID).Error
+	if err != nil {
+		return err
+	}
+
+	// Only Google accounts have granted access to their calendar, and each booking is added once
+	account := booking.Account
+	if booking.Status != db.Valid || booking.CalendarEventID.Valid ||
+		account.OauthProvider != db.Google || !account.OauthRefreshToken.Valid {
+		return nil
+	}
+
+	accessToken, _, err := processor.calendar.RefreshToken(account.OauthRefreshToken.String)
+	if err != nil {
+		return err
+	}
+	event := booking.Ticket.Event
+	description := fmt.Sprintf("Booking #%d, %s ticket", booking.ID, booking.Ticket.Rank)
+	if booking.SeatNumber != "" {
+		description += ", seat " + booking.SeatNumber
+	}
+	entryID, err := processor.calendar.CreateEvent(accessToken, notify.CalendarPayload{
+		Title:       event.Name,
+		Location:    event.Location,
+		Description: description,
+		Start:       event.StartTime,
+		End:         event.EndTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The booking may have been refunded or added by another run in the meantime, the new entry is then removed
+	result := processor.queries.DB.WithContext(ctx).
+		Model(&db.Booking{}).
+		Where("id = ? AND account_id = ? AND status = ? AND calendar_event_id IS NULL", booking.ID, account.ID, db.Valid).
+		Update("calendar_event_id", entryID)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	return processor.calendar.DeleteEvent(accessToken, entryID)
+}
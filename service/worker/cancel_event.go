@@ -0,0 +1,395 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/payment"
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CancelEventPayload struct {
+	EventID uint `json:"event_id"`
+}
+
+type RefundCanceledBookingPayload struct {
+	BookingID uint `json:"booking_id"`
+}
+
+type SendEventCanceledEmailPayload struct {
+	Email     string    `json:"email"`
+	Username  string    `json:"username"`
+	EventName string    `json:"event_name"`
+	StartTime time.Time `json:"start_time"`
+	BookingID uint      `json:"booking_id"`
+	Amount    int64     `json:"amount"` // Cents
+	Currency  string    `json:"currency"`
+}
+
+const (
+	// Distribute one refund task for each paid booking of a canceled event. It is safe to run again,
+	// for example to resume a cancellation whose refund tasks have run out of retries
+	CancelEvent = "cancel-event"
+
+	// Refund a single booking of a canceled event and tell its owner
+	RefundCanceledBooking = "refund-canceled-booking"
+
+	SendEventCanceledEmail = "send-event-canceled-email"
+)
+
+func (processor *RedisTaskProcessor) CancelEvent(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload CancelEventPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	var event db.Event
+	if err := processor.queries.DB.WithContext(ctx).First(&event, payload.EventID).Error; err != nil {
+		return err
+	}
+	if event.Status != db.Canceled {
+		return fmt.Errorf("event %d has not been canceled", event.ID)
+	}
+
+	// Unpaid bookings are simply released
+	pending, err := processor.eventBookings(ctx, event.ID, db.Pending)
+	if err != nil {
+		return err
+	}
+	released, err := processor.queries.ReleaseBookings(ctx, pending)
+	if err != nil && released == nil {
+		return err
+	}
+	if err != nil {
+		processor.logger.Warn("Failed to update ticket stock", "error", err)
+	}
+	if err := processor.cancelReleasedPayments(ctx, released); err != nil {
+		return err
+	}
+
+	// Count the bookings to refund, only on the first run since refunded bookings are no longer valid
+	valid, err := processor.eventBookings(ctx, event.ID, db.Valid)
+	if err != nil {
+		return err
+	}
+	var cancellation db.EventCancellation
+	firstRun := false
+	err = processor.queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("event_id = ?", event.ID).
+			First(&cancellation).Error
+		if err != nil || cancellation.FannedOut {
+			return err
+		}
+
+		firstRun = true
+		cancellation.FannedOut = true
+		cancellation.Total = uint(len(valid))
+		if cancellation.Total == 0 {
+			cancellation.Status = db.CancellationCompleted
+			cancellation.CompletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+		return tx.Save(&cancellation).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// The task ID makes sure a booking is never queued twice, even if this task runs again
+	for _, id := range valid {
+		err := processor.distributor.DistributeTask(ctx, RefundCanceledBooking, RefundCanceledBookingPayload{id},
+			asynq.TaskID(fmt.Sprintf("%s:%d", RefundCanceledBooking, id)), asynq.MaxRetry(10))
+		if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			return err
+		}
+	}
+
+	if firstRun {
+		processor.hub.Broadcast(map[string]string{
+			"title":   "Event canceled",
+			"content": fmt.Sprintf("%s has been canceled by the organiser", event.Name),
+		})
+	}
+	if firstRun && cancellation.Status == db.CancellationCompleted {
+		return processor.notifyCancellationCompleted(ctx, event, cancellation)
+	}
+
+	return nil
+}
+
+// Helper method: get the IDs of the bookings of an event with a status
+func (processor *RedisTaskProcessor) eventBookings(ctx context.Context, eventID uint, status db.TicketStatus) ([]uint, error) {
+	var ids []uint
+	err := processor.queries.DB.WithContext(ctx).
+		Model(&db.Booking{}).
+		Joins("JOIN tickets ON tickets.id = bookings.ticket_id").
+		Where("tickets.event_id = ? AND bookings.status = ?", eventID, status).
+		Order("bookings.id").
+		Pluck("bookings.id", &ids).Error
+	return ids, err
+}
+
+func (processor *RedisTaskProcessor) RefundCanceledBooking(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload RefundCanceledBookingPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	// The booking is marked as refunding and committed first, so the payment provider is never called
+	// while the booking is locked. Only refunding bookings are refunded, so the booking can no longer
+	// be used, transferred or resold in the meantime
+	var (
+		booking      db.Booking
+		cancellation db.EventCancellation
+		completed    bool
+		refunding    bool
+	)
+	err := processor.queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Account").
+			Preload("Ticket.Event").
+			First(&booking, payload.BookingID).Error
+		if err != nil || booking.CancellationHandled {
+			return err
+		}
+
+		switch booking.Status {
+		case db.Refunding:
+			// A previous run has failed to refund it
+			refunding = true
+			return nil
+		case db.Valid:
+			refunding = true
+			booking.Status = db.Refunding
+			return tx.Model(&booking).Update("status", booking.Status).Error
+		}
+
+		// The booking has been refunded or used since the tasks were distributed, there is nothing to refund
+		// but it still counts towards the progress, otherwise the cancellation would never complete
+		if err := tx.Model(&booking).Update("cancellation_handled", true).Error; err != nil {
+			return err
+		}
+		cancellation, completed, err = countCancellationProgress(tx, booking.Ticket.Event)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Canceled events are always fully refunded. The idempotency key makes sure a retry never refunds twice
+	email := SendEventCanceledEmailPayload{
+		Email:     booking.Account.Email,
+		Username:  booking.Account.Username,
+		EventName: booking.Ticket.Event.Name,
+		StartTime: booking.Ticket.Event.StartTime,
+		BookingID: booking.ID,
+	}
+	var entry *db.LedgerEntry
+	if refunding {
+		paid, record, err := db.BookingPaidAmount(processor.queries.DB.WithContext(ctx), booking)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if paid > 0 {
+			refund, err := processor.payment.CreateRefund(record.IntentID, payment.RequestedByCustomer, paid,
+				fmt.Sprintf("cancel-refund:%d", booking.ID))
+			if err != nil {
+				return err
+			}
+			entry = &db.LedgerEntry{
+				AccountID: booking.AccountID,
+				Type:      db.LedgerRefund,
+				Amount:    refund.Amount,
+				Currency:  string(refund.Currency),
+				PaymentID: &record.ID,
+				BookingID: &booking.ID,
+				Reference: refund.ID,
+			}
+			email.Amount = refund.Amount
+			email.Currency = string(refund.Currency)
+		}
+	}
+
+	// Record the refund, only once if runs of this task overlap
+	refunded := false
+	if refunding {
+		err = processor.queries.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var locked db.Booking
+			err := tx.
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("status = ? AND cancellation_handled = ?", db.Refunding, false).
+				First(&locked, booking.ID).Error
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil
+				}
+				return err
+			}
+
+			if entry != nil {
+				if err := tx.Create(entry).Error; err != nil {
+					return err
+				}
+			}
+			err = tx.Model(&locked).Updates(map[string]any{"status": db.Refund, "cancellation_handled": true}).Error
+			if err != nil {
+				return err
+			}
+
+			// An open refund request of the booking has nothing left to refund
+			err = tx.
+				Model(&db.RefundRequest{}).
+				Where("booking_id = ? AND status = ?", booking.ID, db.RefundRequested).
+				Updates(map[string]any{
+					"status":      db.RefundRejected,
+					"review_note": "The event has been canceled and the booking has been refunded in full",
+					"reviewed_at": sql.NullTime{Time: time.Now(), Valid: true},
+				}).Error
+			if err != nil {
+				return err
+			}
+
+			refunded = true
+			cancellation, completed, err = countCancellationProgress(tx, booking.Ticket.Event)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The booking is handled once committed, so a retry of this task would not tell anyone again.
+	// Failing to queue the messages is only logged
+	if completed {
+		if err := processor.notifyCancellationCompleted(ctx, booking.Ticket.Event, cancellation); err != nil {
+			processor.logger.Warn("Failed to notify the organiser", "event_id", cancellation.EventID, "error", err)
+		}
+	}
+	if !refunded {
+		return nil
+	}
+	if err := processor.distributor.DistributeTask(ctx, SendEventCanceledEmail, email, asynq.MaxRetry(5)); err != nil {
+		processor.logger.Warn("Failed to send event canceled email", "booking_id", booking.ID, "error", err)
+	}
+	err = processor.distributor.DistributeTask(ctx, SendNotification, SendNotificationPayload{
+		ReceiverID: booking.AccountID,
+		Title:      "Event canceled",
+		Content:    fmt.Sprintf("%s has been canceled, your booking #%d has been refunded", email.EventName, booking.ID),
+	})
+	if err != nil {
+		processor.logger.Warn("Failed to send notification", "booking_id", booking.ID, "error", err)
+	}
+
+	// Live progress for the organiser, if they are watching
+	if processor.hub.IsUserOnline(booking.Ticket.Event.HostID) {
+		err := processor.hub.Publish(booking.Ticket.Event.HostID, map[string]any{
+			"type":      "cancellation-progress",
+			"event_id":  cancellation.EventID,
+			"processed": cancellation.Processed,
+			"total":     cancellation.Total,
+		})
+		if err != nil {
+			processor.logger.Warn("Failed to publish cancellation progress", "error", err)
+		}
+	}
+
+	// The booking has been refunded, failing to clean up the calendar should not refund it again
+	if err := processor.deleteCalendarEntry(booking); err != nil {
+		processor.logger.Warn("Failed to delete Google Calendar entry", "booking_id", booking.ID, "error", err)
+	}
+
+	return nil
+}
+
+// Helper function: count a handled booking of a canceled event within a transaction,
+// and complete the cancellation with the last booking. Return true if it has just been completed
+func countCancellationProgress(tx *gorm.DB, event db.Event) (db.EventCancellation, bool, error) {
+	var cancellation db.EventCancellation
+	result := tx.
+		Model(&cancellation).
+		Clauses(clause.Returning{}).
+		Where("event_id = ?", event.ID).
+		Update("processed", gorm.Expr("processed + 1"))
+	if result.Error != nil {
+		return cancellation, false, result.Error
+	}
+	if cancellation.Status != db.CancellationRunning || cancellation.Processed < cancellation.Total {
+		return cancellation, false, nil
+	}
+
+	cancellation.Status = db.CancellationCompleted
+	cancellation.CompletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := tx.Save(&cancellation).Error; err != nil {
+		return cancellation, false, err
+	}
+	return cancellation, true, nil
+}
+
+// Helper method: tell the organiser that all bookings of the canceled event have been refunded
+func (processor *RedisTaskProcessor) notifyCancellationCompleted(
+	ctx context.Context,
+	event db.Event,
+	cancellation db.EventCancellation,
+) error {
+	return processor.distributor.DistributeTask(ctx, SendNotification, SendNotificationPayload{
+		ReceiverID: event.HostID,
+		Title:      "Cancellation completed",
+		Content:    fmt.Sprintf("All %d bookings of %s have been refunded", cancellation.Total, event.Name),
+	})
+}
+
+// Helper method: delete the Google Calendar entry of a booking, if the buyer has added one
+func (processor *RedisTaskProcessor) deleteCalendarEntry(booking db.Booking) error {
+	account := booking.Account
+	if !booking.CalendarEventID.Valid || account.OauthProvider != db.Google || !account.OauthRefreshToken.Valid {
+		return nil
+	}
+
+	accessToken, _, err := processor.calendar.RefreshToken(account.OauthRefreshToken.String)
+	if err != nil {
+		return err
+	}
+	return processor.calendar.DeleteEvent(accessToken, booking.CalendarEventID.String)
+}
+
+func (processor *RedisTaskProcessor) SendEventCanceledEmail(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload SendEventCanceledEmailPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	// Prepare the HTML email body
+	tmpl, err := template.ParseFS(fs, "event_canceled.html")
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, map[string]any{
+		"Username":  payload.Username,
+		"EventName": payload.EventName,
+		"StartTime": payload.StartTime.Format("15:04 Monday, 02 January 2006"),
+		"BookingID": payload.BookingID,
+		"Refunded":  payload.Amount > 0,
+		"Amount":    fmt.Sprintf("%.2f %s", float64(payload.Amount)/100, payload.Currency),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Send email
+	return processor.mailService.SendEmail(payload.Email, "Ticket - Event canceled", buffer.String())
+}
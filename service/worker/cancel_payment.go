@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/stripe/stripe-go/v82"
+)
+
+type CancelPaymentsPayload struct {
+	PaymentIDs []uint `json:"payment_ids"`
+}
+
+// Cancel the intents of unsettled payments that nothing can be bought with anymore, for example because their
+// bookings have been released. Payments that are still in use or have been settled are skipped
+const CancelPayments = "cancel-payments"
+
+func (processor *RedisTaskProcessor) CancelPayments(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload CancelPaymentsPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	payments, err := processor.queries.AbandonedPayments(ctx, payload.PaymentIDs, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, record := range payments {
+		if err := processor.cancelPayment(ctx, record); err != nil {
+			errs = append(errs, fmt.Errorf("payment %d: %w", record.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Helper method: cancel the intent of a payment and record it. If the intent cannot be canceled because it has
+// been paid in the meantime, it is left to the webhook, which refunds it
+func (processor *RedisTaskProcessor) cancelPayment(ctx context.Context, record db.Payment) error {
+	if _, err := processor.payment.CancelPaymentIntent(record.IntentID); err != nil {
+		intent, getErr := processor.payment.GetPaymentIntent(record.IntentID)
+		if getErr != nil {
+			return errors.Join(err, getErr)
+		}
+		switch intent.Status {
+		case stripe.PaymentIntentStatusSucceeded:
+			return nil
+		case stripe.PaymentIntentStatusCanceled:
+		default:
+			return err
+		}
+	}
+
+	return processor.queries.DB.WithContext(ctx).
+		Model(&db.Payment{}).
+		Where("id = ? AND status IN ?", record.ID, []db.PaymentStatus{db.PaymentPending, db.PaymentFailed}).
+		Update("status", db.PaymentCanceled).Error
+}
@@ -2,19 +2,20 @@ package worker
 
 import (
 	"context"
-	"encoding/json"
 	"log/slog"
 
 	"github.com/danglnh07/ticket-system/db"
 	"github.com/danglnh07/ticket-system/service/mail"
 	"github.com/danglnh07/ticket-system/service/notify"
+	"github.com/danglnh07/ticket-system/service/payment"
+	"github.com/danglnh07/ticket-system/util"
 	"github.com/hibiken/asynq"
 )
 
 // Task processor interface
 type TaskProcessor interface {
 	Start() error
-	ProcessTask(ctx context.Context, task *asynq.Task, handle func(payload any) error) error
+	ProcessTask(ctx context.Context, task *asynq.Task, handle func(ctx context.Context, payload []byte) error) error
 }
 
 // Redis task processor
@@ -26,6 +27,12 @@ type RedisTaskProcessor struct {
 	queries     *db.Queries
 	mailService mail.MailService
 	hub         *notify.Hub
+	payment     payment.PaymentProvider
+	calendar    *notify.GoogleCalendar
+	config      *util.Config
+
+	// Used to enqueue follow-up tasks, like notifications
+	distributor TaskDistributor
 
 	// Logger for debugging
 	logger *slog.Logger
@@ -37,6 +44,10 @@ func NewRedisTaskProcessor(
 	queries *db.Queries,
 	mailService mail.MailService,
 	hub *notify.Hub,
+	paymentProvider payment.PaymentProvider,
+	calendar *notify.GoogleCalendar,
+	config *util.Config,
+	distributor TaskDistributor,
 	logger *slog.Logger,
 ) TaskProcessor {
 	return &RedisTaskProcessor{
@@ -44,6 +55,10 @@ func NewRedisTaskProcessor(
 		queries:     queries,
 		mailService: mailService,
 		hub:         hub,
+		payment:     paymentProvider,
+		calendar:    calendar,
+		config:      config,
+		distributor: distributor,
 		logger:      logger,
 	}
 }
@@ -55,23 +70,63 @@ func (processor *RedisTaskProcessor) Start() error {
 	mux.HandleFunc(SendVerifyEmail, func(ctx context.Context, t *asynq.Task) error {
 		return processor.ProcessTask(ctx, t, processor.SendVerifyEmail)
 	})
+	mux.HandleFunc(SendResetPasswordEmail, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.SendResetPasswordEmail)
+	})
 	mux.HandleFunc(SendNotification, func(ctx context.Context, t *asynq.Task) error {
 		return processor.ProcessTask(ctx, t, processor.SendNotification)
 	})
+	mux.HandleFunc(SendBookingConfirmation, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.SendBookingConfirmation)
+	})
+	mux.HandleFunc(AddCalendarEntry, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.AddCalendarEntry)
+	})
+	mux.HandleFunc(ReleaseBooking, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.ReleaseBooking)
+	})
+	mux.HandleFunc(ReleaseExpiredBookings, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.ReleaseExpiredBookings)
+	})
+	mux.HandleFunc(CancelPayments, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.CancelPayments)
+	})
+	mux.HandleFunc(RefundLatePayment, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.RefundLatePayment)
+	})
+	mux.HandleFunc(CancelEvent, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.CancelEvent)
+	})
+	mux.HandleFunc(RefundCanceledBooking, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.RefundCanceledBooking)
+	})
+	mux.HandleFunc(SendEventCanceledEmail, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.SendEventCanceledEmail)
+	})
+	mux.HandleFunc(OfferWaitlist, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.OfferWaitlist)
+	})
+	mux.HandleFunc(ExpireWaitlistOffers, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.ExpireWaitlistOffers)
+	})
+	mux.HandleFunc(SendWaitlistOfferEmail, func(ctx context.Context, t *asynq.Task) error {
+		return processor.ProcessTask(ctx, t, processor.SendWaitlistOfferEmail)
+	})
 
 	return processor.server.Start(mux)
 }
 
+// Method to process a task. The raw payload is passed to the handler, which is responsible for
+// unmarshalling it into its own payload struct (unmarshalling into any would only give us a map)
 func (processor *RedisTaskProcessor) ProcessTask(
 	ctx context.Context,
 	task *asynq.Task,
-	handle func(payload any) error,
+	handle func(ctx context.Context, payload []byte) error,
 ) error {
-	// Unmarshal the payload
-	var payload any
-	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+	if err := handle(ctx, task.Payload()); err != nil {
+		processor.logger.Error("Failed to process task", "task_name", task.Type(), "error", err)
 		return err
 	}
 
-	return handle(payload)
+	return nil
 }
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/danglnh07/ticket-system/service/payment"
+)
+
+type RefundLatePaymentPayload struct {
+	PaymentID uint  `json:"payment_id"`
+	Amount    int64 `json:"amount"` // Cents
+}
+
+// Refund a payment, or a part of it, that has been received after what it pays for has been given to someone else.
+// The idempotency key gives back the same refund when the task is retried, and the ledger entry is only written once
+const RefundLatePayment = "refund-late-payment"
+
+func (processor *RedisTaskProcessor) RefundLatePayment(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload RefundLatePaymentPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	var record db.Payment
+	if err := processor.queries.DB.WithContext(ctx).First(&record, payload.PaymentID).Error; err != nil {
+		return err
+	}
+
+	refund, err := processor.payment.CreateRefund(record.IntentID, payment.RequestedByCustomer, payload.Amount,
+		"late-payment:"+record.IntentID)
+	if err != nil {
+		return err
+	}
+
+	entry := db.LedgerEntry{
+		AccountID: record.AccountID,
+		Type:      db.LedgerRefund,
+		Amount:    refund.Amount,
+		Currency:  string(refund.Currency),
+		PaymentID: &record.ID,
+		Reference: refund.ID,
+	}
+	return processor.queries.DB.WithContext(ctx).
+		Where(db.LedgerEntry{Type: db.LedgerRefund, Reference: refund.ID}).
+		FirstOrCreate(&entry).Error
+}
@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+)
+
+type ReleaseBookingPayload struct {
+	BookingIDs []uint `json:"booking_ids"`
+}
+
+const (
+	// Scheduled with asynq.ProcessIn when the bookings are created, to release them once their hold expires
+	ReleaseBooking = "release-booking"
+
+	// Run by the cron job to release any expired hold whose release task has been lost
+	ReleaseExpiredBookings = "release-expired-bookings"
+)
+
+func (processor *RedisTaskProcessor) ReleaseBooking(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload ReleaseBookingPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	// Paid or canceled bookings are skipped, so nothing happens if the user has paid in time
+	released, err := processor.queries.ReleaseBookings(ctx, payload.BookingIDs)
+	if err != nil && released == nil {
+		return err
+	}
+	if err != nil {
+		processor.logger.Warn("Failed to update ticket stock", "error", err)
+	}
+
+	return errors.Join(
+		processor.notifyReleasedBookings(ctx, released),
+		processor.offerReleasedTickets(ctx, released),
+		processor.cancelReleasedPayments(ctx, released),
+	)
+}
+
+func (processor *RedisTaskProcessor) ReleaseExpiredBookings(ctx context.Context, pl []byte) error {
+	released, err := processor.queries.ReleaseExpiredBookings(ctx, time.Now())
+	if err != nil && released == nil {
+		return err
+	}
+	if err != nil {
+		processor.logger.Warn("Failed to update ticket stock", "error", err)
+	}
+	if len(released) > 0 {
+		processor.logger.Info("Released expired bookings", "count", len(released))
+	}
+
+	return errors.Join(
+		processor.notifyReleasedBookings(ctx, released),
+		processor.offerReleasedTickets(ctx, released),
+		processor.cancelReleasedPayments(ctx, released),
+	)
+}
+
+// Helper method: tell each owner how many of their bookings have been released
+func (processor *RedisTaskProcessor) notifyReleasedBookings(ctx context.Context, released []db.Booking) error {
+	counts := map[uint]int{}
+	for _, booking := range released {
+		counts[booking.AccountID]++
+	}
+
+	for accountID, count := range counts {
+		err := processor.distributor.DistributeTask(ctx, SendNotification, SendNotificationPayload{
+			ReceiverID: accountID,
+			Title:      "Booking expired",
+			Content:    fmt.Sprintf("%d of your bookings were not paid in time and have been released", count),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Helper method: offer the tickets of released bookings to the people waiting for them
+func (processor *RedisTaskProcessor) offerReleasedTickets(ctx context.Context, released []db.Booking) error {
+	ticketIDs := make([]uint, len(released))
+	for i, booking := range released {
+		ticketIDs[i] = booking.TicketID
+	}
+	return processor.offerWaitlist(ctx, ticketIDs)
+}
+
+// Helper method: cancel the intents of the payments of released bookings, unless other bookings can still be paid
+// with them. Payments that land anyway are refunded by the webhook
+func (processor *RedisTaskProcessor) cancelReleasedPayments(ctx context.Context, released []db.Booking) error {
+	paymentIDs := ReleasedPayments(released)
+	if len(paymentIDs) == 0 {
+		return nil
+	}
+	return processor.distributor.DistributeTask(ctx, CancelPayments, CancelPaymentsPayload{PaymentIDs: paymentIDs})
+}
+
+// Get the payments of released bookings, each once
+func ReleasedPayments(released []db.Booking) []uint {
+	var paymentIDs []uint
+	for _, booking := range released {
+		if booking.PaymentID != nil && !slices.Contains(paymentIDs, *booking.PaymentID) {
+			paymentIDs = append(paymentIDs, *booking.PaymentID)
+		}
+	}
+	return paymentIDs
+}
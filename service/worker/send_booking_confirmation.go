@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/danglnh07/ticket-system/service/mail"
+	"github.com/danglnh07/ticket-system/util"
+)
+
+type BookingConfirmationTicket struct {
+	BookingID  uint   `json:"booking_id"`
+	Rank       string `json:"rank"`
+	SeatNumber string `json:"seat_number"`
+
+	// Signed e-ticket code, shown as a QR code at the gate
+	Code string `json:"code"`
+}
+
+type SendBookingConfirmationPayload struct {
+	Email     string                      `json:"email"`
+	Username  string                      `json:"username"`
+	EventName string                      `json:"event_name"`
+	Location  string                      `json:"location"`
+	StartTime time.Time                   `json:"start_time"`
+	Tickets   []BookingConfirmationTicket `json:"tickets"`
+}
+
+const SendBookingConfirmation = "send-booking-confirmation"
+
+// Template data of a ticket. The QR code is rendered in memory and attached to the email, since most mail clients
+// do not show data URLs. The template refers to the attachment by its content ID
+type confirmationTicket struct {
+	BookingConfirmationTicket
+	QRCode template.URL
+}
+
+func (processor *RedisTaskProcessor) SendBookingConfirmation(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload SendBookingConfirmationPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	// Render the QR code of each ticket
+	tickets := make([]confirmationTicket, len(payload.Tickets))
+	images := make([]mail.InlineImage, len(payload.Tickets))
+	for i, ticket := range payload.Tickets {
+		png, err := util.GenerateQRCode(ticket.Code)
+		if err != nil {
+			return err
+		}
+		contentID := fmt.Sprintf("qr-%d@ticket", ticket.BookingID)
+		images[i] = mail.InlineImage{ContentID: contentID, ContentType: "image/png", Data: png}
+		tickets[i] = confirmationTicket{
+			BookingConfirmationTicket: ticket,
+			QRCode:                    template.URL("cid:" + contentID),
+		}
+	}
+
+	// Prepare the HTML email body
+	tmpl, err := template.ParseFS(fs, "booking_confirmation.html")
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, map[string]any{
+		"Username":  payload.Username,
+		"EventName": payload.EventName,
+		"Location":  payload.Location,
+		"StartTime": payload.StartTime.Format("15:04 Monday, 02 January 2006"),
+		"Tickets":   tickets,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Send email
+	return processor.mailService.SendEmailWithImages(payload.Email, "Ticket - Your tickets are confirmed", buffer.String(),
+		images)
+}
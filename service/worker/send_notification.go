@@ -1,6 +1,8 @@
 package worker
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 )
 
@@ -12,11 +14,11 @@ type SendNotificationPayload struct {
 
 const SendNotification = "send-notification"
 
-func (processor *RedisTaskProcessor) SendNotification(pl any) error {
+func (processor *RedisTaskProcessor) SendNotification(ctx context.Context, pl []byte) error {
 	// Check if the payload type is correct
-	payload, ok := pl.(SendNotificationPayload)
-	if !ok {
-		return fmt.Errorf("invalid payload type for this task")
+	var payload SendNotificationPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
 	}
 
 	// Check if user is online
@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+)
+
+type SendResetPasswordEmailPayload struct {
+	Email     string `json:"email"`
+	Username  string `json:"username"`
+	Link      string `json:"link"`
+	ExpiresIn int    `json:"expires_in"` // Minutes
+}
+
+const SendResetPasswordEmail = "send-reset-password-email"
+
+func (processor *RedisTaskProcessor) SendResetPasswordEmail(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload SendResetPasswordEmailPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	// Prepare the HTML email body
+	tmpl, err := template.ParseFS(fs, "reset_password.html")
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	if err = tmpl.Execute(&buffer, payload); err != nil {
+		return err
+	}
+
+	// Send email
+	err = processor.mailService.SendEmail(payload.Email, "Ticket - Reset your password", buffer.String())
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package worker
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"html/template"
 )
@@ -15,14 +17,14 @@ type SendVerifyEmailPayload struct {
 
 const SendVerifyEmail = "send-verify-email"
 
-//go:embed verify_email.html
+//go:embed *.html
 var fs embed.FS
 
-func (processor *RedisTaskProcessor) SendVerifyEmail(pl any) error {
+func (processor *RedisTaskProcessor) SendVerifyEmail(ctx context.Context, pl []byte) error {
 	// Check if the payload type is correct
-	payload, ok := pl.(SendVerifyEmailPayload)
-	if !ok {
-		return fmt.Errorf("invalid payload type for this task")
+	var payload SendVerifyEmailPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
 	}
 
 	// Prepare the HTML email body
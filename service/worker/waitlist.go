@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"slices"
+	"time"
+
+	"github.com/danglnh07/ticket-system/db"
+	"github.com/hibiken/asynq"
+)
+
+type OfferWaitlistPayload struct {
+	TicketIDs []uint `json:"ticket_ids"`
+}
+
+type SendWaitlistOfferEmailPayload struct {
+	Email     string    `json:"email"`
+	Username  string    `json:"username"`
+	EventName string    `json:"event_name"`
+	Rank      string    `json:"rank"`
+	EntryID   uint      `json:"entry_id"`
+	Quantity  uint      `json:"quantity"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+const (
+	// Offer the tickets given back to tiers to the people waiting for them
+	OfferWaitlist = "offer-waitlist"
+
+	// Scheduled with asynq.ProcessIn when offers are made, and run by the cron job for any lost schedule.
+	// The tickets of expired offers roll over to the next people in the queue
+	ExpireWaitlistOffers = "expire-waitlist-offers"
+
+	SendWaitlistOfferEmail = "send-waitlist-offer-email"
+)
+
+func (processor *RedisTaskProcessor) OfferWaitlist(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload OfferWaitlistPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	return processor.offerWaitlist(ctx, payload.TicketIDs)
+}
+
+func (processor *RedisTaskProcessor) ExpireWaitlistOffers(ctx context.Context, pl []byte) error {
+	expired, err := processor.queries.ExpireWaitlistOffers(ctx, time.Now())
+	if err != nil && expired == nil {
+		return err
+	}
+	if err != nil {
+		processor.logger.Warn("Failed to update ticket stock", "error", err)
+	}
+	if len(expired) > 0 {
+		processor.logger.Info("Expired waitlist offers", "count", len(expired))
+	}
+
+	for _, entry := range expired {
+		err := processor.distributor.DistributeTask(ctx, SendNotification, SendNotificationPayload{
+			ReceiverID: entry.AccountID,
+			Title:      "Waitlist offer expired",
+			Content:    fmt.Sprintf("Your offer for %d tickets was not claimed in time and has been passed on", entry.Quantity),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Also catch the tickets whose offer has been lost, for example when a release task has failed
+	ticketIDs, err := processor.queries.PendingWaitlistTickets(ctx)
+	if err != nil {
+		return err
+	}
+	return processor.offerWaitlist(ctx, ticketIDs)
+}
+
+// Helper method: offer the available tickets of tiers to their waitlists, tell the people who got an offer,
+// and schedule the expiry of the new offers
+func (processor *RedisTaskProcessor) offerWaitlist(ctx context.Context, ticketIDs []uint) error {
+	slices.Sort(ticketIDs)
+	ticketIDs = slices.Compact(ticketIDs)
+
+	duration := processor.config.WaitlistOfferDuration
+	var errs []error
+	offered := false
+	for _, ticketID := range ticketIDs {
+		entries, err := processor.queries.OfferWaitlist(ctx, ticketID, duration)
+		if err != nil && entries == nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			processor.logger.Warn("Failed to update ticket stock", "error", err)
+		}
+
+		for _, entry := range entries {
+			offered = true
+			errs = append(errs, processor.notifyWaitlistOffer(ctx, entry))
+		}
+	}
+
+	// Let the offers expire on time. If this fails, the cron job expires them instead
+	if offered {
+		err := processor.distributor.DistributeTask(ctx, ExpireWaitlistOffers, nil, asynq.ProcessIn(duration))
+		if err != nil {
+			processor.logger.Warn("Failed to schedule waitlist offer expiry", "error", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Helper method: tell the owner of a waitlist entry about their offer, in app and by email
+func (processor *RedisTaskProcessor) notifyWaitlistOffer(ctx context.Context, entry db.WaitlistEntry) error {
+	err := processor.distributor.DistributeTask(ctx, SendNotification, SendNotificationPayload{
+		ReceiverID: entry.AccountID,
+		Title:      "Tickets available",
+		Content: fmt.Sprintf("%d %s tickets for %s are held for you until %s, claim them before someone else gets them",
+			entry.Quantity, entry.Ticket.Rank, entry.Ticket.Event.Name, entry.OfferExpiresAt.Time.Format(time.RFC3339)),
+	})
+	if err != nil {
+		return err
+	}
+
+	return processor.distributor.DistributeTask(ctx, SendWaitlistOfferEmail, SendWaitlistOfferEmailPayload{
+		Email:     entry.Account.Email,
+		Username:  entry.Account.Username,
+		EventName: entry.Ticket.Event.Name,
+		Rank:      entry.Ticket.Rank,
+		EntryID:   entry.ID,
+		Quantity:  entry.Quantity,
+		ExpiresAt: entry.OfferExpiresAt.Time,
+	})
+}
+
+func (processor *RedisTaskProcessor) SendWaitlistOfferEmail(ctx context.Context, pl []byte) error {
+	// Check if the payload type is correct
+	var payload SendWaitlistOfferEmailPayload
+	if err := json.Unmarshal(pl, &payload); err != nil {
+		return fmt.Errorf("invalid payload type for this task: %w", err)
+	}
+
+	// Prepare the HTML email body
+	tmpl, err := template.ParseFS(fs, "waitlist_offer.html")
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, map[string]any{
+		"Username":  payload.Username,
+		"EventName": payload.EventName,
+		"Rank":      payload.Rank,
+		"EntryID":   payload.EntryID,
+		"Quantity":  payload.Quantity,
+		"ExpiresAt": payload.ExpiresAt.Format("15:04 Monday, 02 January 2006"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Send email
+	return processor.mailService.SendEmail(payload.Email, "Ticket - Tickets are waiting for you", buffer.String())
+}
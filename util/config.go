@@ -9,6 +9,9 @@ import (
 )
 
 type Config struct {
+	// Server config. Domain is the public base URL of the server, used when building links sent to users
+	Domain string
+
 	// Database config
 	DBConn string
 
@@ -25,10 +28,35 @@ type Config struct {
 	SecretKey              []byte
 	TokenExpiration        time.Duration
 	RefreshTokenExpiration time.Duration
+	VerifyLinkExpiration   time.Duration
+	ResetTokenExpiration   time.Duration
+
+	// How long a pending booking holds its tickets while waiting for payment
+	BookingHoldDuration time.Duration
+
+	// How long a waitlist offer is reserved for its user before it goes to the next person
+	WaitlistOfferDuration time.Duration
+
+	// Tickets cannot be transferred to another account within this long before the event starts
+	TransferCutoff time.Duration
+
+	// Percentage of the price of a resold ticket kept by the platform
+	ResaleFeePercent uint
 
-	// OAuth2 config
+	// The frontend page where user choose a new password. The reset token is appended as the token query parameter
+	ResetPasswordURL string
+
+	// OAuth2 config. The endpoints are configurable so we can test against a local fake server
 	GoogleClientID     string
 	GoogleClientSecret string
+	GoogleRedirectURL  string
+	GoogleAuthURL      string
+	GoogleTokenURL     string
+	GoogleUserInfoURL  string
+
+	// Telegram Login Widget config. Login data older than TelegramAuthMaxAge is rejected
+	TelegramBotToken   string
+	TelegramAuthMaxAge time.Duration
 
 	// Rate limiting config
 	MaxRequest int
@@ -40,10 +68,25 @@ type Config struct {
 	StripeWebhookSecret  string
 }
 
+const (
+	defaultGoogleAuthURL     = "https://accounts.google.com/o/oauth2/v2/auth"
+	defaultGoogleTokenURL    = "https://oauth2.googleapis.com/token"
+	defaultGoogleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+)
+
+// Helper function: get the environment variable, or the fallback value if it is not set
+func getEnv(key, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
+}
+
 func LoadConfig(path string) *Config {
 	err := godotenv.Load(path)
 	if err != nil {
 		return &Config{
+			Domain:                 os.Getenv("DOMAIN"),
 			DBConn:                 os.Getenv("DB_CONN"),
 			RedisAddr:              os.Getenv("REDIS_ADDRESS"),
 			SMTPHost:               "smtp.gmail.com",
@@ -53,8 +96,21 @@ func LoadConfig(path string) *Config {
 			SecretKey:              []byte(os.Getenv("SECRET_KEY")),
 			TokenExpiration:        time.Hour,
 			RefreshTokenExpiration: time.Hour * 24,
+			VerifyLinkExpiration:   time.Hour * 24,
+			ResetTokenExpiration:   time.Minute * 30,
+			BookingHoldDuration:    time.Minute * 15,
+			WaitlistOfferDuration:  time.Minute * 30,
+			TransferCutoff:         time.Hour * 24,
+			ResaleFeePercent:       10,
+			ResetPasswordURL:       os.Getenv("RESET_PASSWORD_URL"),
 			GoogleClientID:         os.Getenv("GOOGLE_CLIENT_ID"),
 			GoogleClientSecret:     os.Getenv("GOOGLE_CLIENT_SECRET"),
+			GoogleRedirectURL:      os.Getenv("GOOGLE_REDIRECT_URL"),
+			GoogleAuthURL:          getEnv("GOOGLE_AUTH_URL", defaultGoogleAuthURL),
+			GoogleTokenURL:         getEnv("GOOGLE_TOKEN_URL", defaultGoogleTokenURL),
+			GoogleUserInfoURL:      getEnv("GOOGLE_USERINFO_URL", defaultGoogleUserInfoURL),
+			TelegramBotToken:       os.Getenv("TELEGRAM_BOT_TOKEN"),
+			TelegramAuthMaxAge:     time.Hour * 24,
 			MaxRequest:             100,
 			RefillRate:             time.Second * 10,
 			StripePublishableKey:   os.Getenv("STRIPE_PUBLISHABLE_KEY"),
@@ -76,6 +132,48 @@ func LoadConfig(path string) *Config {
 		refreshTokenExpiration = 1440
 	}
 
+	verifyLinkExpiration, err := strconv.Atoi(os.Getenv("VERIFY_LINK_EXPIRATION"))
+	if err != nil {
+		// Fallback to default value (1440 minutes = 24 hours)
+		verifyLinkExpiration = 1440
+	}
+
+	resetTokenExpiration, err := strconv.Atoi(os.Getenv("RESET_TOKEN_EXPIRATION"))
+	if err != nil {
+		// Fallback to default value (30 minutes)
+		resetTokenExpiration = 30
+	}
+
+	bookingHoldDuration, err := strconv.Atoi(os.Getenv("BOOKING_HOLD_DURATION"))
+	if err != nil {
+		// Fallback to default value (15 minutes)
+		bookingHoldDuration = 15
+	}
+
+	waitlistOfferDuration, err := strconv.Atoi(os.Getenv("WAITLIST_OFFER_DURATION"))
+	if err != nil {
+		// Fallback to default value (30 minutes)
+		waitlistOfferDuration = 30
+	}
+
+	transferCutoff, err := strconv.Atoi(os.Getenv("TRANSFER_CUTOFF"))
+	if err != nil {
+		// Fallback to default value (1440 minutes = 24 hours)
+		transferCutoff = 1440
+	}
+
+	resaleFeePercent, err := strconv.ParseUint(os.Getenv("RESALE_FEE_PERCENT"), 10, 0)
+	if err != nil || resaleFeePercent > 100 {
+		// Fallback to default value (10%)
+		resaleFeePercent = 10
+	}
+
+	telegramAuthMaxAge, err := strconv.Atoi(os.Getenv("TELEGRAM_AUTH_MAX_AGE"))
+	if err != nil {
+		// Fallback to default value (1440 minutes = 24 hours)
+		telegramAuthMaxAge = 1440
+	}
+
 	maxRequest, err := strconv.Atoi(os.Getenv("MAX_REQUEST"))
 	if err != nil {
 		maxRequest = 100
@@ -88,6 +186,7 @@ func LoadConfig(path string) *Config {
 	}
 
 	return &Config{
+		Domain:                 os.Getenv("DOMAIN"),
 		DBConn:                 os.Getenv("DB_CONN"),
 		RedisAddr:              os.Getenv("REDIS_ADDRESS"),
 		SMTPHost:               os.Getenv("SMTP_HOST"),
@@ -97,8 +196,21 @@ func LoadConfig(path string) *Config {
 		SecretKey:              []byte(os.Getenv("SECRET_KEY")),
 		TokenExpiration:        time.Minute * time.Duration(tokenExpiration),
 		RefreshTokenExpiration: time.Minute * time.Duration(refreshTokenExpiration),
+		VerifyLinkExpiration:   time.Minute * time.Duration(verifyLinkExpiration),
+		ResetTokenExpiration:   time.Minute * time.Duration(resetTokenExpiration),
+		BookingHoldDuration:    time.Minute * time.Duration(bookingHoldDuration),
+		WaitlistOfferDuration:  time.Minute * time.Duration(waitlistOfferDuration),
+		TransferCutoff:         time.Minute * time.Duration(transferCutoff),
+		ResaleFeePercent:       uint(resaleFeePercent),
+		ResetPasswordURL:       os.Getenv("RESET_PASSWORD_URL"),
 		GoogleClientID:         os.Getenv("GOOGLE_CLIENT_ID"),
 		GoogleClientSecret:     os.Getenv("GOOGLE_CLIENT_SECRET"),
+		GoogleRedirectURL:      os.Getenv("GOOGLE_REDIRECT_URL"),
+		GoogleAuthURL:          getEnv("GOOGLE_AUTH_URL", defaultGoogleAuthURL),
+		GoogleTokenURL:         getEnv("GOOGLE_TOKEN_URL", defaultGoogleTokenURL),
+		GoogleUserInfoURL:      getEnv("GOOGLE_USERINFO_URL", defaultGoogleUserInfoURL),
+		TelegramBotToken:       os.Getenv("TELEGRAM_BOT_TOKEN"),
+		TelegramAuthMaxAge:     time.Minute * time.Duration(telegramAuthMaxAge),
 		MaxRequest:             maxRequest,
 		RefillRate:             time.Second * time.Duration(refillRate),
 		StripePublishableKey:   os.Getenv("STRIPE_PUBLISHABLE_KEY"),
@@ -1,7 +1,44 @@
 package util
 
-import "github.com/skip2/go-qrcode"
+import (
+	"fmt"
+	"strings"
 
-func GenerateQRCode(content, dest string) error {
-	return qrcode.WriteFile(content, qrcode.Medium, 256, dest)
+	"github.com/skip2/go-qrcode"
+)
+
+// Size in pixels of the rendered PNG QR codes
+const QRCodeSize = 256
+
+// Render a QR code as a PNG image in memory
+func GenerateQRCode(content string) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, QRCodeSize)
+}
+
+// Render a QR code as an SVG image, with one square per dark module so it scales without blurring
+func GenerateQRCodeSVG(content string) ([]byte, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bitmap already includes the quiet zone around the code
+	bitmap := qr.Bitmap()
+	size := len(bitmap)
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder,
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`,
+		size, size, QRCodeSize, QRCodeSize)
+	fmt.Fprintf(&builder, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&builder, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	builder.WriteString(`"/></svg>`)
+
+	return []byte(builder.String()), nil
 }